@@ -0,0 +1,39 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+)
+
+// adminMiddleware protects /admin/* with ADMIN_TOKEN (sent as
+// "Authorization: Bearer <token>"). Without a token configured the admin
+// routes are closed (403), not open. Admin routes are never rate limited
+// or throttled.
+func adminMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !requireAdmin(w, r) {
+			return
+		}
+		next(w, r)
+	}
+}
+
+// requireAdmin checks the admin token, answering 403/401 and returning
+// false when the request doesn't carry it.
+func requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if config.AdminToken == "" {
+		writeJSON(w, r, http.StatusForbidden, map[string]string{
+			"error": "Admin endpoints are disabled: ADMIN_TOKEN is not set",
+		})
+		return false
+	}
+	expected := "Bearer " + config.AdminToken
+	got := r.Header.Get("Authorization")
+	if subtle.ConstantTimeCompare([]byte(got), []byte(expected)) != 1 {
+		writeJSON(w, r, http.StatusUnauthorized, map[string]string{
+			"error": "Invalid or missing admin token",
+		})
+		return false
+	}
+	return true
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func auditEntries(t *testing.T, log string) []auditEntry {
+	t.Helper()
+	var entries []auditEntry
+	for _, line := range strings.Split(strings.TrimSpace(log), "\n") {
+		if line == "" {
+			continue
+		}
+		var e auditEntry
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatalf("audit line %q: %v", line, err)
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+func TestPostIsAudited(t *testing.T) {
+	withConfig(t, nil)
+	useTestDB(t)
+	audit := captureAudit(t)
+
+	r := httptest.NewRequest(http.MethodPost, "/api/db/messages", strings.NewReader(`{"content":"hello audit"}`))
+	dbPostHandler(httptest.NewRecorder(), r)
+
+	entries := auditEntries(t, audit.String())
+	if len(entries) != 1 {
+		t.Fatalf("%d audit entries, want 1", len(entries))
+	}
+	e := entries[0]
+	if e.Action != "create" || len(e.IDs) != 1 || e.Bytes != len("hello audit") || e.Preview == "" {
+		t.Fatalf("audit entry = %+v", e)
+	}
+}
+
+func TestReplayIsAudited(t *testing.T) {
+	withConfig(t, nil)
+	useTestDB(t)
+	audit := captureAudit(t)
+	recordFailedWrite(Message{Content: "a", ContentType: defaultContentType}, errors.New("down"))
+	recordFailedWrite(Message{Content: "b", ContentType: defaultContentType}, errors.New("down"))
+
+	replayFailedHandler(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/admin/replay-failed", nil))
+
+	entries := auditEntries(t, audit.String())
+	if len(entries) != 1 {
+		t.Fatalf("%d audit entries, want 1 for the replay", len(entries))
+	}
+	e := entries[0]
+	if e.Action != "create" || e.Count != 2 || len(e.IDs) != 2 || e.Path != "/admin/replay-failed" {
+		t.Fatalf("audit entry = %+v, want a create of the 2 replayed ids", e)
+	}
+}
+
+func TestDeleteIsAudited(t *testing.T) {
+	withConfig(t, nil)
+	useTestDB(t)
+	postBulk(dbBulkHandler, `[{"content":"1"},{"content":"2"}]`)
+	audit := captureAudit(t)
+
+	r := httptest.NewRequest(http.MethodPost, "/api/db/messages/bulk-delete", strings.NewReader(`{"ids":[1,2]}`))
+	dbBulkDeleteHandler(httptest.NewRecorder(), r)
+
+	entries := auditEntries(t, audit.String())
+	if len(entries) != 1 || entries[0].Action != "delete" || entries[0].Count != 2 {
+		t.Fatalf("audit entries = %+v, want one delete of 2", entries)
+	}
+}
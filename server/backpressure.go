@@ -0,0 +1,81 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Dynamic Retry-After for 503s from saturated queues: the estimate is the
+// backlog divided by how fast the queue is currently draining, clamped to
+// [1, RETRY_AFTER_MAX_SEC].
+
+// throughput tracks completions per second as a moving average over
+// one-second windows.
+type throughput struct {
+	mu    sync.Mutex
+	start time.Time
+	count int
+	rate  float64
+}
+
+const throughputAlpha = 0.3
+
+var (
+	qosThroughput        = &throughput{}
+	writeQueueThroughput = &throughput{}
+)
+
+func (t *throughput) done() {
+	t.mu.Lock()
+	t.roll(time.Now())
+	t.count++
+	t.mu.Unlock()
+}
+
+func (t *throughput) perSecond() float64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.roll(time.Now())
+	return t.rate
+}
+
+func (t *throughput) roll(now time.Time) {
+	if t.start.IsZero() {
+		t.start = now
+		return
+	}
+	elapsed := now.Sub(t.start)
+	if elapsed < time.Second {
+		return
+	}
+	instant := float64(t.count) / elapsed.Seconds()
+	if t.rate == 0 {
+		t.rate = instant
+	} else {
+		t.rate = throughputAlpha*instant + (1-throughputAlpha)*t.rate
+	}
+	t.count = 0
+	t.start = now
+}
+
+// retryAfter estimates how long a backlog of depth takes to clear at rate
+// completions per second. An idle or stalled queue gets the maximum.
+func retryAfter(depth int, rate float64) string {
+	maxSec := config.RetryAfterMaxSec
+	if maxSec < 1 {
+		maxSec = 1
+	}
+	seconds := maxSec
+	if rate > 0 {
+		seconds = int(math.Ceil(float64(depth+1) / rate))
+	}
+	if seconds < 1 {
+		seconds = 1
+	}
+	if seconds > maxSec {
+		seconds = maxSec
+	}
+	return strconv.Itoa(seconds)
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestRetryAfterEstimate(t *testing.T) {
+	withConfig(t, func(c *Config) { c.RetryAfterMaxSec = 30 })
+	tests := []struct {
+		depth int
+		rate  float64
+		want  string
+	}{
+		{0, 10, "1"},    // one slot frees within the second
+		{49, 10, "5"},   // 50 ahead at 10/s
+		{999, 10, "30"}, // clamped to RETRY_AFTER_MAX_SEC
+		{5, 0, "30"},    // stalled queue gets the maximum
+	}
+	for _, tt := range tests {
+		if got := retryAfter(tt.depth, tt.rate); got != tt.want {
+			t.Errorf("retryAfter(%d, %g) = %s, want %s", tt.depth, tt.rate, got, tt.want)
+		}
+	}
+}
+
+func TestRetryAfterMaxBelowOne(t *testing.T) {
+	withConfig(t, func(c *Config) { c.RetryAfterMaxSec = 0 })
+	if got := retryAfter(100, 0); got != "1" {
+		t.Fatalf("retryAfter = %s, want 1", got)
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// dbBulkHandler inserts a JSON array of messages in one transaction. The
+// array is decoded element by element so an oversized payload is rejected
+// with 413 as soon as it passes BULK_MAX_ITEMS, without parsing the rest.
+// BULK_MAX_BYTES bounds the body as a whole, since a single element can be
+// arbitrarily large.
+func dbBulkHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	r.Body = http.MaxBytesReader(w, r.Body, config.BulkMaxBytes)
+	var body io.Reader = r.Body
+	if config.EnforceUTF8 {
+		body = &utf8Reader{r: r.Body}
+	}
+	dec := json.NewDecoder(body)
+
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('[') {
+		if writeBulkTooLarge(w, r, err) || writeBodyLengthMismatch(w, r, err) || writeInvalidUTF8(w, r, err) {
+			return
+		}
+		writeJSON(w, r, http.StatusBadRequest, map[string]string{
+			"error": "Invalid JSON payload. Expected an array of messages",
+		})
+		return
+	}
+
+	var batch []importLine
+	var errs []fieldError
+	var results []bulkResult // BULK_CONTINUE_ON_ERROR only
+	for i := 0; dec.More(); i++ {
+		if i >= config.BulkMaxItems {
+			log.Printf("[BULK] Rejected array larger than %d items from %s", config.BulkMaxItems, r.RemoteAddr)
+			writeJSON(w, r, http.StatusRequestEntityTooLarge, map[string]interface{}{
+				"error":     "Too many messages in one request",
+				"max_items": config.BulkMaxItems,
+			})
+			return
+		}
+
+		var msg Message
+		if err := dec.Decode(&msg); err != nil {
+			if writeBulkTooLarge(w, r, err) || writeBodyLengthMismatch(w, r, err) || writeInvalidUTF8(w, r, err) {
+				return
+			}
+			writeJSON(w, r, http.StatusBadRequest, map[string]string{
+				"error": fmt.Sprintf("Invalid JSON at index %d", i),
+			})
+			return
+		}
+		if msg.ContentType == "" {
+			msg.ContentType = defaultContentType
+		}
+		sanitizeMessage(&msg)
+		rowErrs := validateMessage(msg)
+		if config.BulkContinueOnError && len(rowErrs) > 0 {
+			results = append(results, bulkResult{Index: i, Status: http.StatusUnprocessableEntity, Errors: rowErrs})
+			continue
+		}
+		for _, e := range rowErrs {
+			errs = append(errs, fieldError{Field: fmt.Sprintf("[%d].%s", i, e.Field), Message: e.Message})
+		}
+		batch = append(batch, importLine{line: i, msg: msg})
+	}
+	if _, err := dec.Token(); err != nil {
+		if writeBulkTooLarge(w, r, err) || writeBodyLengthMismatch(w, r, err) || writeInvalidUTF8(w, r, err) {
+			return
+		}
+		writeJSON(w, r, http.StatusBadRequest, map[string]string{
+			"error": "Invalid JSON payload. Unterminated array",
+		})
+		return
+	}
+
+	if config.BulkContinueOnError {
+		bulkInsertEach(w, r, batch, results, start)
+		return
+	}
+
+	if len(errs) > 0 {
+		writeValidationErrors(w, r, errs)
+		return
+	}
+
+	if len(batch) > 0 {
+		dbStart := time.Now()
+		stored, err := insertImportBatch(r.Context(), batch)
+		observeDB(r, dbStart)
+		if err != nil {
+			noteWriteError(err)
+			writeJSON(w, r, http.StatusInternalServerError, internalError(r, fmt.Sprintf("Failed to insert %d messages", len(batch)), err))
+			return
+		}
+		messagesCache.invalidate()
+		auditWrite(r, "create", storedIDs(stored), int64(len(batch)), "")
+		for _, msg := range stored {
+			messageStored(msg, len(msg.Content))
+		}
+	}
+
+	log.Printf("[BULK] %d messages inserted in %v", len(batch), time.Since(start))
+
+	writeJSON(w, r, http.StatusCreated, map[string]interface{}{
+		"message":  "Messages saved successfully",
+		"inserted": len(batch),
+	})
+}
+
+// writeBulkTooLarge answers 413 when err comes from passing BULK_MAX_BYTES.
+func writeBulkTooLarge(w http.ResponseWriter, r *http.Request, err error) bool {
+	var tooLarge *http.MaxBytesError
+	if !errors.As(err, &tooLarge) {
+		return false
+	}
+	writeJSON(w, r, http.StatusRequestEntityTooLarge, map[string]interface{}{
+		"error":     "Request body too large",
+		"max_bytes": config.BulkMaxBytes,
+	})
+	return true
+}
+
+// bulkResult is the outcome of one array element in continue-on-error mode.
+type bulkResult struct {
+	Index  int          `json:"index"`
+	Status int          `json:"status"`
+	ID     int          `json:"id,omitempty"`
+	Error  string       `json:"error,omitempty"`
+	Errors []fieldError `json:"errors,omitempty"`
+}
+
+// bulkInsertEach inserts rows one by one so a bad row doesn't roll back the
+// others, and answers 207 with per-index results when anything failed.
+// invalid holds the rows already rejected by validation.
+func bulkInsertEach(w http.ResponseWriter, r *http.Request, batch []importLine, invalid []bulkResult, start time.Time) {
+	results := append([]bulkResult{}, invalid...)
+	inserted := 0
+	var ids []int64
+	var stored []Message
+
+	dbStart := time.Now()
+	for _, l := range batch {
+		id, createdAt, err := insertMessage(r.Context(), l.msg)
+		if err != nil {
+			results = append(results, bulkResult{Index: l.line, Status: http.StatusInternalServerError, Error: "Failed to insert message"})
+			continue
+		}
+		results = append(results, bulkResult{Index: l.line, Status: http.StatusCreated, ID: id})
+		ids = append(ids, int64(id))
+		msg := l.msg
+		msg.ID, msg.CreatedAt = id, createdAt
+		stored = append(stored, msg)
+		inserted++
+	}
+	observeDB(r, dbStart)
+	if inserted > 0 {
+		messagesCache.invalidate()
+		auditWrite(r, "create", ids, int64(inserted), "")
+		for _, msg := range stored {
+			messageStored(msg, len(msg.Content))
+		}
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Index < results[j].Index })
+
+	failed := len(results) - inserted
+	log.Printf("[BULK] %d messages inserted, %d failed in %v", inserted, failed, time.Since(start))
+
+	status := http.StatusCreated
+	if failed > 0 {
+		status = http.StatusMultiStatus
+	}
+	writeJSON(w, r, status, map[string]interface{}{
+		"inserted": inserted,
+		"failed":   failed,
+		"results":  results,
+	})
+}
+
+// dbBulkDeleteHandler deletes the messages listed in {"ids": [...]} with a
+// single statement. The list is capped at BULK_MAX_ITEMS.
+func dbBulkDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	// Generous per-id allowance; keeps a huge body from being decoded at all
+	r.Body = http.MaxBytesReader(w, r.Body, int64(config.BulkMaxItems)*32+1024)
+
+	var req struct {
+		IDs []int64 `json:"ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeJSON(w, r, http.StatusRequestEntityTooLarge, map[string]interface{}{
+				"error":     "Too many ids in one request",
+				"max_items": config.BulkMaxItems,
+			})
+			return
+		}
+		if writeBodyLengthMismatch(w, r, err) {
+			return
+		}
+		writeJSON(w, r, http.StatusBadRequest, map[string]string{
+			"error": "Invalid JSON payload. Expected: {\"ids\": [1, 2, 3]}",
+		})
+		return
+	}
+	if len(req.IDs) > config.BulkMaxItems {
+		writeJSON(w, r, http.StatusRequestEntityTooLarge, map[string]interface{}{
+			"error":     "Too many ids in one request",
+			"max_items": config.BulkMaxItems,
+		})
+		return
+	}
+	if len(req.IDs) == 0 {
+		writeJSON(w, r, http.StatusOK, map[string]interface{}{"deleted": 0})
+		return
+	}
+
+	dbStart := time.Now()
+	cond, args := dialect.idIn(req.IDs)
+	qctx, cancel := dbContext(r.Context())
+	defer cancel()
+	res, err := db.ExecContext(qctx, tagQuery(r.Context(), "DELETE FROM messages WHERE "+cond), args...)
+	observeDB(r, dbStart)
+	if err != nil && writeDeadlineExceeded(w, r) {
+		return
+	}
+	if err != nil {
+		noteWriteError(err)
+		writeJSON(w, r, http.StatusInternalServerError, internalError(r, "Failed to delete messages", err))
+		return
+	}
+	deleted, _ := res.RowsAffected()
+	if deleted > 0 {
+		messagesCache.invalidate()
+		// ids are the requested ones; count is what was actually removed
+		auditWrite(r, "delete", req.IDs, deleted, "")
+	}
+
+	log.Printf("[BULK] %d of %d requested messages deleted", deleted, len(req.IDs))
+	writeJSON(w, r, http.StatusOK, map[string]interface{}{"deleted": deleted})
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func postBulk(handler http.HandlerFunc, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/api/db/messages/bulk", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w
+}
+
+func decodeBody(t *testing.T, w *httptest.ResponseRecorder) map[string]interface{} {
+	t.Helper()
+	var body map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode %q: %v", w.Body.String(), err)
+	}
+	return body
+}
+
+func TestBulkInsertsArray(t *testing.T) {
+	withConfig(t, nil)
+	useTestDB(t)
+	audit := captureAudit(t)
+	events := subscribeEvents(t)
+
+	w := postBulk(dbBulkHandler, `[{"content":"one"},{"content":"two","title":"t"}]`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("status = %d, body %s", w.Code, w.Body.String())
+	}
+	if n := countMessages(t); n != 2 {
+		t.Fatalf("messages table has %d rows, want 2", n)
+	}
+	if got := drainEvents(events); len(got) != 2 || got[0].ID == 0 {
+		t.Fatalf("published events = %+v, want both stored messages", got)
+	}
+	if !strings.Contains(audit.String(), `"count":2`) {
+		t.Fatalf("audit entry missing:\n%s", audit.String())
+	}
+}
+
+func TestBulkValidationErrorsCarryIndex(t *testing.T) {
+	withConfig(t, nil)
+	useTestDB(t)
+
+	w := postBulk(dbBulkHandler, `[{"content":"ok"},{"content":""}]`)
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("status = %d, want 422", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), `"field":"[1].content"`) {
+		t.Fatalf("body %s lacks the [1].content field path", w.Body.String())
+	}
+	if n := countMessages(t); n != 0 {
+		t.Fatalf("%d rows inserted from an invalid array", n)
+	}
+}
+
+func TestBulkRejectsTooManyItems(t *testing.T) {
+	withConfig(t, func(c *Config) { c.BulkMaxItems = 2 })
+	useTestDB(t)
+
+	w := postBulk(dbBulkHandler, `[{"content":"1"},{"content":"2"},{"content":"3"}]`)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("status = %d, want 413", w.Code)
+	}
+	if got := decodeBody(t, w)["max_items"]; got != float64(2) {
+		t.Fatalf("max_items = %v, want 2", got)
+	}
+}
+
+func TestBulkRejectsOversizedBody(t *testing.T) {
+	withConfig(t, func(c *Config) { c.BulkMaxBytes = 64 })
+	useTestDB(t)
+
+	// One element is enough to pass the byte limit
+	w := postBulk(dbBulkHandler, `[{"content":"`+strings.Repeat("x", 200)+`"}]`)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("status = %d, want 413 (%s)", w.Code, w.Body.String())
+	}
+	if got := decodeBody(t, w)["max_bytes"]; got != float64(64) {
+		t.Fatalf("max_bytes = %v, want 64", got)
+	}
+}
+
+func TestBulkMaxItemsZeroMeansDefault(t *testing.T) {
+	t.Setenv("BULK_MAX_ITEMS", "0")
+	withConfig(t, nil)
+	if config.BulkMaxItems != 1000 {
+		t.Fatalf("BulkMaxItems = %d, want the 1000 default", config.BulkMaxItems)
+	}
+}
+
+func TestBulkRejectsMalformedArrays(t *testing.T) {
+	withConfig(t, nil)
+	useTestDB(t)
+
+	for _, body := range []string{`{"content":"x"}`, `[{"content":"x"}`, `[{"content":}]`} {
+		if w := postBulk(dbBulkHandler, body); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", body, w.Code)
+		}
+	}
+}
+
+func TestBulkDelete(t *testing.T) {
+	withConfig(t, nil)
+	useTestDB(t)
+	postBulk(dbBulkHandler, `[{"content":"1"},{"content":"2"},{"content":"3"}]`)
+
+	r := httptest.NewRequest(http.MethodPost, "/api/db/messages/bulk-delete", strings.NewReader(`{"ids":[1,3,99]}`))
+	w := httptest.NewRecorder()
+	dbBulkDeleteHandler(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", w.Code, w.Body.String())
+	}
+	if got := decodeBody(t, w)["deleted"]; got != float64(2) {
+		t.Fatalf("deleted = %v, want 2", got)
+	}
+	if n := countMessages(t); n != 1 {
+		t.Fatalf("%d rows left, want 1", n)
+	}
+}
+
+func TestBulkDeleteHonoursTotalDeadline(t *testing.T) {
+	withConfig(t, func(c *Config) { c.TotalDeadlineMs = 1 })
+	fake := useFakeDB(t, nil)
+
+	handler := deadlineMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(5 * time.Millisecond) // the budget runs out before the DELETE
+		dbBulkDeleteHandler(w, r)
+	})
+	w := postBulk(handler, `{"ids":[1,2]}`)
+	if w.Code != http.StatusGatewayTimeout {
+		t.Fatalf("status = %d, want 504", w.Code)
+	}
+	if n := fake.count("DELETE"); n != 0 {
+		t.Fatalf("DELETE sent %d time(s) after the deadline", n)
+	}
+}
+
+func TestBulkContinueOnErrorReportsMultiStatus(t *testing.T) {
+	withConfig(t, func(c *Config) { c.BulkContinueOnError = true })
+	useTestDB(t)
+	events := subscribeEvents(t)
+
+	w := postBulk(dbBulkHandler, `[{"content":"ok"},{"content":""},{"content":"also ok"}]`)
+	if w.Code != http.StatusMultiStatus {
+		t.Fatalf("status = %d, want 207 (%s)", w.Code, w.Body.String())
+	}
+	var resp struct {
+		Inserted int          `json:"inserted"`
+		Failed   int          `json:"failed"`
+		Results  []bulkResult `json:"results"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Inserted != 2 || resp.Failed != 1 || len(resp.Results) != 3 {
+		t.Fatalf("response = %+v, want 2 inserted and 1 failed", resp)
+	}
+	for i, want := range []int{http.StatusCreated, http.StatusUnprocessableEntity, http.StatusCreated} {
+		if got := resp.Results[i]; got.Index != i || got.Status != want {
+			t.Errorf("results[%d] = %+v, want index %d status %d", i, got, i, want)
+		}
+	}
+	if got := drainEvents(events); len(got) != 2 {
+		t.Fatalf("published %d events, want 2", len(got))
+	}
+}
+
+func TestBulkContinueOnErrorAllValidIs201(t *testing.T) {
+	withConfig(t, func(c *Config) { c.BulkContinueOnError = true })
+	useTestDB(t)
+
+	if w := postBulk(dbBulkHandler, `[{"content":"a"},{"content":"b"}]`); w.Code != http.StatusCreated {
+		t.Fatalf("status = %d, want 201", w.Code)
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// recentMessagesCache holds messages lists for MESSAGES_CACHE_MS so repeated
+// reads don't re-query Postgres for the same rows. Entries are keyed by the
+// normalized query string; any write drops them all.
+type recentMessagesCache struct {
+	mu         sync.Mutex
+	entries    map[string]*messagesCacheEntry
+	generation uint64 // bumped on every invalidation
+	refreshing map[string]bool
+	uses       uint64 // ticks on every hit or store, for LRU eviction
+}
+
+type messagesCacheEntry struct {
+	messages []Message
+	storedAt time.Time
+	lastUsed uint64
+}
+
+var messagesCache = &recentMessagesCache{
+	entries:    make(map[string]*messagesCacheEntry),
+	refreshing: make(map[string]bool),
+}
+
+// get returns the cached list for key if it is younger than maxAge.
+func (c *recentMessagesCache) get(key string, maxAge time.Duration) ([]Message, uint64, time.Duration, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, c.generation, 0, false
+	}
+	age := clock.Now().Sub(e.storedAt)
+	if age < maxAge {
+		c.uses++
+		e.lastUsed = c.uses
+		return e.messages, c.generation, age, true
+	}
+	return nil, c.generation, age, false
+}
+
+// set stores a freshly queried list unless a write invalidated the cache
+// while the query was running, which would make the list stale. Query
+// strings come from clients, so past MESSAGES_CACHE_MAX_KEYS the least
+// recently used entry makes room: one-off queries age out instead of
+// locking the lists people actually read out of the cache.
+func (c *recentMessagesCache) set(key string, messages []Message, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if generation != c.generation {
+		return
+	}
+	if _, exists := c.entries[key]; !exists && config.MessagesCacheMaxKeys > 0 && len(c.entries) >= config.MessagesCacheMaxKeys {
+		c.evictOldest()
+	}
+	c.uses++
+	c.entries[key] = &messagesCacheEntry{messages: messages, storedAt: clock.Now(), lastUsed: c.uses}
+}
+
+// evictOldest drops the least recently used entry. Must be called with c.mu
+// held; a linear scan is fine at MESSAGES_CACHE_MAX_KEYS sizes.
+func (c *recentMessagesCache) evictOldest() {
+	oldest, found := "", false
+	var oldestUse uint64
+	for key, e := range c.entries {
+		if !found || e.lastUsed < oldestUse {
+			oldest, oldestUse, found = key, e.lastUsed, true
+		}
+	}
+	delete(c.entries, oldest)
+	messagesCacheEvictions.inc()
+}
+
+func (c *recentMessagesCache) invalidate() {
+	c.mu.Lock()
+	c.entries = make(map[string]*messagesCacheEntry)
+	c.generation++
+	c.mu.Unlock()
+}
+
+// messagesCacheKey normalizes the request's query string so the same
+// parameters in any order share an entry. Presentation-only parameters
+// (pretty, the fields projection) don't change the rows and are left out.
+func messagesCacheKey(r *http.Request) string {
+	q := r.URL.Query()
+	q.Del("pretty")
+	q.Del("fields")
+	for _, values := range q {
+		sort.Strings(values)
+	}
+	return q.Encode() // sorted by key
+}
+
+// cachedRecentMessages serves the messages list for key from the cache when
+// enabled, falling back to the database on a miss.
+func cachedRecentMessages(ctx context.Context, key string) ([]Message, error) {
+	if config.MessagesCacheMs <= 0 {
+		return queryRecentMessages(ctx)
+	}
+
+	ttl := time.Duration(config.MessagesCacheMs) * time.Millisecond
+	stale := time.Duration(config.CacheStaleMs) * time.Millisecond
+	messages, generation, age, ok := messagesCache.get(key, ttl+stale)
+	if ok {
+		if age < ttl {
+			messagesCacheHits.inc()
+		} else {
+			// Past the TTL but inside CACHE_STALE_MS: serve it now, refresh behind
+			messagesCacheStale.inc()
+			refreshMessagesCache(key, generation)
+		}
+		return unexpired(messages, time.Now()), nil
+	}
+
+	messagesCacheMisses.inc()
+	messages, err := queryRecentMessages(ctx)
+	if err != nil {
+		return nil, err
+	}
+	messagesCache.set(key, messages, generation)
+	return messages, nil
+}
+
+// refreshMessagesCache reloads one entry in the background; concurrent
+// stale reads of the same key share a single refresh.
+func refreshMessagesCache(key string, generation uint64) {
+	messagesCache.mu.Lock()
+	if messagesCache.refreshing[key] {
+		messagesCache.mu.Unlock()
+		return
+	}
+	messagesCache.refreshing[key] = true
+	messagesCache.mu.Unlock()
+
+	go func() {
+		defer func() {
+			messagesCache.mu.Lock()
+			delete(messagesCache.refreshing, key)
+			messagesCache.mu.Unlock()
+		}()
+		messages, err := queryRecentMessages(context.Background())
+		if err != nil {
+			log.Printf("[CACHE] Background refresh failed: %v", err)
+			return
+		}
+		messagesCache.set(key, messages, generation)
+	}()
+}
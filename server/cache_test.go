@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// cachedKeys lists the query strings the messages cache holds.
+func cachedKeys() map[string]bool {
+	messagesCache.mu.Lock()
+	defer messagesCache.mu.Unlock()
+	keys := make(map[string]bool, len(messagesCache.entries))
+	for key := range messagesCache.entries {
+		keys[key] = true
+	}
+	return keys
+}
+
+func cacheKeyOf(target string) string {
+	return messagesCacheKey(httptest.NewRequest(http.MethodGet, target, nil))
+}
+
+func TestMessagesCacheKeyNormalizes(t *testing.T) {
+	if a, b := cacheKeyOf("/api/db/messages?b=2&a=1&pretty=true"), cacheKeyOf("/api/db/messages?a=1&b=2&fields=id"); a != b {
+		t.Fatalf("keys differ: %q vs %q", a, b)
+	}
+	if cacheKeyOf("/api/db/messages?a=1") == cacheKeyOf("/api/db/messages?a=2") {
+		t.Fatal("different queries share a key")
+	}
+}
+
+func TestMessagesCacheEntryPerQueryInvalidatedByWrite(t *testing.T) {
+	withConfig(t, func(c *Config) { c.MessagesCacheMs = 60000 })
+	useTestDB(t)
+	ctx := context.Background()
+
+	for _, key := range []string{"a=1", "a=2"} {
+		if _, err := cachedRecentMessages(ctx, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if keys := cachedKeys(); len(keys) != 2 || !keys["a=1"] || !keys["a=2"] {
+		t.Fatalf("cached keys = %v, want one entry per query", keys)
+	}
+
+	if w := postMessage("/api/db/messages", `{"content":"new"}`); w.Code != http.StatusCreated {
+		t.Fatalf("POST status = %d", w.Code)
+	}
+	if keys := cachedKeys(); len(keys) != 0 {
+		t.Fatalf("cached keys after a write = %v, want none", keys)
+	}
+	for _, key := range []string{"a=1", "a=2"} {
+		if messages, _ := cachedRecentMessages(ctx, key); len(messages) != 1 {
+			t.Fatalf("%s after the write: %d messages, want 1", key, len(messages))
+		}
+	}
+}
+
+func TestMessagesCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	withConfig(t, func(c *Config) {
+		c.MessagesCacheMs = 60000
+		c.MessagesCacheMaxKeys = 2
+	})
+	useTestDB(t)
+	ctx := context.Background()
+
+	cachedRecentMessages(ctx, "")
+	cachedRecentMessages(ctx, "a=1")
+	cachedRecentMessages(ctx, "") // the default list stays in use
+	cachedRecentMessages(ctx, "a=2")
+
+	if keys := cachedKeys(); len(keys) != 2 || !keys[""] || !keys["a=2"] {
+		t.Fatalf("cached keys = %v, want the default list and the newest query", keys)
+	}
+}
+
+func TestMessagesCacheHitExpiryAndInvalidation(t *testing.T) {
+	withConfig(t, func(c *Config) { c.MessagesCacheMs = 1000 })
+	useTestDB(t)
+	clk := useMockClock(t)
+	ctx := context.Background()
+
+	list := func() int {
+		t.Helper()
+		messages, err := cachedRecentMessages(ctx, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(messages)
+	}
+	hits, misses := atomic.LoadInt64(&messagesCacheHits.value), atomic.LoadInt64(&messagesCacheMisses.value)
+	counts := func(wantHits, wantMisses int64) {
+		t.Helper()
+		if h := atomic.LoadInt64(&messagesCacheHits.value) - hits; h != wantHits {
+			t.Fatalf("hits = %d, want %d", h, wantHits)
+		}
+		if m := atomic.LoadInt64(&messagesCacheMisses.value) - misses; m != wantMisses {
+			t.Fatalf("misses = %d, want %d", m, wantMisses)
+		}
+	}
+
+	list()
+	counts(0, 1)
+
+	// A row written behind the cache's back stays hidden until the TTL passes
+	if _, err := db.Exec("INSERT INTO messages (content) VALUES ('direct')"); err != nil {
+		t.Fatal(err)
+	}
+	clk.Advance(999 * time.Millisecond)
+	if n := list(); n != 0 {
+		t.Fatalf("hit inside the TTL returned %d messages, want the cached 0", n)
+	}
+	counts(1, 1)
+
+	clk.Advance(time.Millisecond)
+	if n := list(); n != 1 {
+		t.Fatalf("after the TTL: %d messages, want 1", n)
+	}
+	counts(1, 2)
+
+	// A POST drops the entry at once, no waiting for the TTL
+	if w := postMessage("/api/db/messages", `{"content":"posted"}`); w.Code != http.StatusCreated {
+		t.Fatalf("POST status = %d", w.Code)
+	}
+	if n := list(); n != 2 {
+		t.Fatalf("after the POST: %d messages, want 2", n)
+	}
+	counts(1, 3)
+}
+
+func TestMessagesCacheServesStaleWhileRefreshing(t *testing.T) {
+	withConfig(t, func(c *Config) {
+		c.MessagesCacheMs = 1000
+		c.CacheStaleMs = 5000
+	})
+	useTestDB(t)
+	clk := useMockClock(t)
+	ctx := context.Background()
+
+	if messages, _ := cachedRecentMessages(ctx, ""); len(messages) != 0 {
+		t.Fatalf("%d messages in an empty table", len(messages))
+	}
+	if _, err := db.Exec("INSERT INTO messages (content) VALUES ('fresh')"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Past the TTL, inside CACHE_STALE_MS: the old list comes back at once
+	clk.Advance(1500 * time.Millisecond)
+	stale, misses := atomic.LoadInt64(&messagesCacheStale.value), atomic.LoadInt64(&messagesCacheMisses.value)
+	if messages, _ := cachedRecentMessages(ctx, ""); len(messages) != 0 {
+		t.Fatalf("stale read returned %d messages, want the cached 0", len(messages))
+	}
+	if n := atomic.LoadInt64(&messagesCacheStale.value) - stale; n != 1 {
+		t.Fatalf("messages_cache_stale_total grew by %d, want 1", n)
+	}
+	if n := atomic.LoadInt64(&messagesCacheMisses.value) - misses; n != 0 {
+		t.Fatalf("stale read counted %d misses, want 0", n)
+	}
+
+	// ...and the entry is reloaded behind it
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if messages, _, _, ok := messagesCache.get("", time.Second); ok && len(messages) == 1 {
+			break
+		}
+		time.Sleep(2 * time.Millisecond)
+	}
+	if messages, _ := cachedRecentMessages(ctx, ""); len(messages) != 1 {
+		t.Fatalf("after the refresh: %d messages, want 1", len(messages))
+	}
+
+	// Past TTL + CACHE_STALE_MS the entry is too old to serve at all
+	clk.Advance(6 * time.Second)
+	misses = atomic.LoadInt64(&messagesCacheMisses.value)
+	cachedRecentMessages(ctx, "")
+	if n := atomic.LoadInt64(&messagesCacheMisses.value) - misses; n != 1 {
+		t.Fatalf("read past the stale window counted %d misses, want 1", n)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Per-client rate limiting: instead of one global bucket, each client key
+// gets its own bucket, sized by PER_IP_RATE/PER_IP_BURST or, when unset, by
+// the global settings. Idle buckets are dropped periodically so the map
+// doesn't grow without bound.
+
+const clientLimiterIdleTTL = 3 * time.Minute
+
+type clientLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+type clientLimiterStore struct {
+	mu      sync.Mutex
+	clients map[string]*clientLimiter
+}
+
+var clientLimiters = &clientLimiterStore{clients: make(map[string]*clientLimiter)}
+
+func (s *clientLimiterStore) get(key string) *rate.Limiter {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.clients[key]
+	if !ok {
+		ratePerSecond, burst := clientBucketSettings()
+		c = &clientLimiter{limiter: rate.NewLimiter(rate.Limit(ratePerSecond), burst)}
+		s.clients[key] = c
+	}
+	c.lastSeen = clock.Now()
+	return c.limiter
+}
+
+func (s *clientLimiterStore) cleanup(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, c := range s.clients {
+		if now.Sub(c.lastSeen) > clientLimiterIdleTTL {
+			delete(s.clients, key)
+		}
+	}
+}
+
+func startClientLimiterCleanup() {
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			clientLimiters.cleanup(now)
+		}
+	}()
+}
+
+// clientBucketSettings returns the rate and burst of a per-client bucket,
+// independent of the global limiter when PER_IP_RATE/PER_IP_BURST are set.
+func clientBucketSettings() (float64, int) {
+	ratePerSecond := float64(config.RateLimitRequests) / float64(config.RateLimitPeriod)
+	if config.PerIPRate > 0 {
+		ratePerSecond = config.PerIPRate
+	}
+	burst := config.RateLimitRequests
+	if config.PerIPBurst > 0 {
+		burst = config.PerIPBurst
+	}
+	return ratePerSecond, burst
+}
+
+// perClientRateLimiting reports whether requests get per-client buckets.
+// Either PER_IP_RATE or PER_IP_BURST turns them on; the one left unset
+// falls back to the global value.
+func perClientRateLimiting() bool {
+	return config.RateLimitKeyHeader != "" || config.PerIPRate > 0 || config.PerIPBurst > 0
+}
+
+// rateLimitPolicy renders the X-RateLimit-Policy value: bucket name, quota
+// (q), window in seconds (w) and, when PER_IP_RATE/PER_IP_BURST size the
+// client buckets apart from the global one, their burst.
+func rateLimitPolicy(policy string) string {
+	if policy == "global" || config.PerIPRate <= 0 && config.PerIPBurst <= 0 {
+		return fmt.Sprintf("%s;q=%d;w=%d", policy, config.RateLimitRequests, config.RateLimitPeriod)
+	}
+	_, burst := clientBucketSettings()
+	if config.PerIPRate > 0 {
+		return fmt.Sprintf("%s;q=%g;w=1;burst=%d", policy, config.PerIPRate, burst)
+	}
+	return fmt.Sprintf("%s;q=%d;w=%d;burst=%d", policy, config.RateLimitRequests, config.RateLimitPeriod, burst)
+}
+
+// effectiveRateLimit is the quota and window behind rateLimitPolicy, as
+// numbers for JSON bodies.
+func effectiveRateLimit(policy string) (float64, int) {
+	if policy != "global" && config.PerIPRate > 0 {
+		return config.PerIPRate, 1
+	}
+	return float64(config.RateLimitRequests), config.RateLimitPeriod
+}
+
+// limiterFor returns the bucket that applies to this request and the name
+// of its policy: "global", "key" (RATE_LIMIT_KEY_HEADER value) or "ip"
+// (anonymous clients).
+func limiterFor(r *http.Request) (*rate.Limiter, string) {
+	if !perClientRateLimiting() {
+		return limiter, "global"
+	}
+	key, policy := clientKeyAndPolicy(r)
+	return clientLimiters.get(key), policy
+}
+
+// clientKey identifies the client by RATE_LIMIT_KEY_HEADER, falling back to
+// the remote IP when the header is absent. Prefixes keep a header value
+// from colliding with an IP.
+func clientKey(r *http.Request) string {
+	key, _ := clientKeyAndPolicy(r)
+	return key
+}
+
+func clientKeyAndPolicy(r *http.Request) (string, string) {
+	if config.RateLimitKeyHeader != "" {
+		if v := r.Header.Get(config.RateLimitKeyHeader); v != "" {
+			return "key:" + v, "key"
+		}
+	}
+	return "ip:" + clientIP(r), "ip"
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// useClientLimiters gives the test its own per-client bucket store.
+func useClientLimiters(t *testing.T) {
+	t.Helper()
+	prev := clientLimiters
+	clientLimiters = &clientLimiterStore{clients: make(map[string]*clientLimiter)}
+	t.Cleanup(func() { clientLimiters = prev })
+}
+
+func requestFrom(ip string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/api/db/messages", nil)
+	r.RemoteAddr = ip + ":40000"
+	return r
+}
+
+func TestPerIPBurstAloneEnablesClientBuckets(t *testing.T) {
+	withConfig(t, func(c *Config) {
+		c.RateLimitRequests = 1
+		c.RateLimitPeriod = 60
+		c.RateLimitMode = "reject"
+		c.PerIPRate = 0
+		c.PerIPBurst = 3
+	})
+	useMockClock(t)
+	useClientLimiters(t)
+
+	if !perClientRateLimiting() {
+		t.Fatal("PER_IP_BURST alone did not switch to per-client buckets")
+	}
+
+	handler := rateLimitMiddleware(okHandler)
+	for _, ip := range []string{"10.0.0.1", "10.0.0.2"} {
+		for i := 0; i < 3; i++ {
+			w := httptest.NewRecorder()
+			handler(w, requestFrom(ip))
+			if w.Code != http.StatusOK {
+				t.Fatalf("%s request %d: status = %d, want 200 within the burst", ip, i+1, w.Code)
+			}
+			if got := w.Header().Get("X-RateLimit-Policy"); got != "ip;q=1;w=60;burst=3" {
+				t.Fatalf("X-RateLimit-Policy = %q", got)
+			}
+		}
+		w := httptest.NewRecorder()
+		handler(w, requestFrom(ip))
+		if w.Code != http.StatusTooManyRequests {
+			t.Fatalf("%s request 4: status = %d, want 429 past the burst", ip, w.Code)
+		}
+	}
+}
+
+func TestClientBucketSettingsFallBackToGlobal(t *testing.T) {
+	withConfig(t, func(c *Config) {
+		c.RateLimitRequests = 100
+		c.RateLimitPeriod = 10
+		c.PerIPRate = 0
+		c.PerIPBurst = 5
+	})
+	if r, burst := clientBucketSettings(); r != 10 || burst != 5 {
+		t.Fatalf("clientBucketSettings() = %v, %d; want global rate 10 and burst 5", r, burst)
+	}
+
+	config.PerIPRate = 2
+	config.PerIPBurst = 0
+	if r, burst := clientBucketSettings(); r != 2 || burst != 100 {
+		t.Fatalf("clientBucketSettings() = %v, %d; want rate 2 and global burst 100", r, burst)
+	}
+}
+
+func TestRateLimitPolicyHeader(t *testing.T) {
+	tests := []struct {
+		name   string
+		edit   func(*Config)
+		apiKey string
+		want   string
+	}{
+		{"global", func(c *Config) {}, "", "global;q=10;w=1"},
+		{"key header", func(c *Config) { c.RateLimitKeyHeader = "X-API-Key" }, "tenant-a", "key;q=10;w=1"},
+		{"key header absent", func(c *Config) { c.RateLimitKeyHeader = "X-API-Key" }, "", "ip;q=10;w=1"},
+		{"per-ip rate", func(c *Config) { c.PerIPRate = 2 }, "", "ip;q=2;w=1;burst=10"},
+		{"per-ip rate and burst", func(c *Config) { c.PerIPRate = 2; c.PerIPBurst = 5 }, "", "ip;q=2;w=1;burst=5"},
+		{"per-ip burst only", func(c *Config) { c.PerIPBurst = 5 }, "", "ip;q=10;w=1;burst=5"},
+		{"key with per-ip rate", func(c *Config) { c.RateLimitKeyHeader = "X-API-Key"; c.PerIPRate = 0.5 }, "tenant-a", "key;q=0.5;w=1;burst=10"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withConfig(t, func(c *Config) {
+				c.RateLimitRequests = 10
+				c.RateLimitPeriod = 1
+				c.PerIPRate = 0
+				c.PerIPBurst = 0
+				tt.edit(c)
+			})
+			useMockClock(t)
+			useLimiter(t, rate.NewLimiter(10, 10))
+			useClientLimiters(t)
+
+			r := requestFrom("10.0.0.1")
+			if tt.apiKey != "" {
+				r.Header.Set("X-API-Key", tt.apiKey)
+			}
+			w := httptest.NewRecorder()
+			rateLimitMiddleware(okHandler)(w, r)
+			if got := w.Header().Get("X-RateLimit-Policy"); got != tt.want {
+				t.Fatalf("X-RateLimit-Policy = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKeyHeaderGivesEachKeyItsBucket(t *testing.T) {
+	withConfig(t, func(c *Config) {
+		c.RateLimitRequests = 2
+		c.RateLimitPeriod = 60
+		c.RateLimitMode = "reject"
+		c.RateLimitKeyHeader = "X-API-Key"
+	})
+	useMockClock(t)
+	useClientLimiters(t)
+	handler := rateLimitMiddleware(okHandler)
+	send := func(key string) int {
+		r := requestFrom("10.0.0.1") // every key from the same address
+		if key != "" {
+			r.Header.Set("X-API-Key", key)
+		}
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w.Code
+	}
+
+	for i := 0; i < 2; i++ {
+		if code := send("tenant-a"); code != http.StatusOK {
+			t.Fatalf("tenant-a request %d = %d, want 200", i+1, code)
+		}
+	}
+	if code := send("tenant-a"); code != http.StatusTooManyRequests {
+		t.Fatalf("tenant-a past its bucket = %d, want 429", code)
+	}
+	// Same IP, different key: a bucket of its own
+	if code := send("tenant-b"); code != http.StatusOK {
+		t.Fatalf("tenant-b = %d, want 200", code)
+	}
+	// No key falls back to the IP bucket, also separate
+	if code := send(""); code != http.StatusOK {
+		t.Fatalf("keyless request = %d, want 200", code)
+	}
+	if n := len(clientLimiters.clients); n != 3 {
+		t.Fatalf("%d buckets, want 3 (tenant-a, tenant-b, ip)", n)
+	}
+}
+
+func TestClientLimiterCleanupUsesClock(t *testing.T) {
+	clk := useMockClock(t)
+	useClientLimiters(t)
+
+	clientLimiters.get("key:old")
+	clk.Advance(clientLimiterIdleTTL)
+	clientLimiters.get("key:recent")
+	clk.Advance(time.Second)
+
+	clientLimiters.cleanup(clk.Now())
+	if _, ok := clientLimiters.clients["key:old"]; ok {
+		t.Fatal("idle bucket survived cleanup")
+	}
+	if _, ok := clientLimiters.clients["key:recent"]; !ok {
+		t.Fatal("recently used bucket was dropped")
+	}
+}
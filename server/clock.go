@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// Clock is the time source for throttling and rate limiting, so timing
+// logic can run against a manual clock instead of real sleeps.
+type Clock interface {
+	Now() time.Time
+	// Sleep waits for d or until ctx is done, reporting whether the full
+	// delay elapsed.
+	Sleep(ctx context.Context, d time.Duration) bool
+}
+
+// clock defaults to the system clock; tests swap in a mockClock.
+var clock Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) Sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
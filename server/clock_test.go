@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// mockClock only moves when told to: Sleep advances it instantly by the
+// requested delay, and Advance moves it by hand.
+type mockClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	slept  time.Duration
+	sleeps int
+}
+
+func newMockClock(start time.Time) *mockClock {
+	return &mockClock{now: start}
+}
+
+func (c *mockClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *mockClock) Sleep(ctx context.Context, d time.Duration) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.slept += d
+	c.sleeps++
+	c.mu.Unlock()
+	return true
+}
+
+func (c *mockClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.mu.Unlock()
+}
+
+// Slept reports the total delay requested through Sleep.
+func (c *mockClock) Slept() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.slept
+}
+
+// useMockClock swaps the package clock for the duration of the test.
+func useMockClock(t *testing.T) *mockClock {
+	t.Helper()
+	c := newMockClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	prev := clock
+	clock = c
+	t.Cleanup(func() { clock = prev })
+	return c
+}
+
+func TestMockClockSleepHonoursContext(t *testing.T) {
+	c := newMockClock(time.Unix(0, 0))
+	ctx, cancel := context.WithCancel(context.Background())
+	if !c.Sleep(ctx, time.Second) {
+		t.Fatal("Sleep with live context returned false")
+	}
+	cancel()
+	if c.Sleep(ctx, time.Second) {
+		t.Fatal("Sleep with cancelled context returned true")
+	}
+	if got := c.Slept(); got != time.Second {
+		t.Fatalf("Slept() = %v, want 1s", got)
+	}
+	c.Advance(time.Minute)
+	if got := c.Now(); !got.Equal(time.Unix(61, 0)) {
+		t.Fatalf("Now() = %v, want 61s after epoch", got)
+	}
+}
+
+func TestRealClockSleepReturnsOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	start := time.Now()
+	if (realClock{}).Sleep(ctx, 10*time.Second) {
+		t.Fatal("Sleep reported the full delay after cancellation")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Sleep returned after %v, want right after the cancel", elapsed)
+	}
+	if !(realClock{}).Sleep(context.Background(), time.Millisecond) {
+		t.Fatal("uncancelled Sleep reported an interruption")
+	}
+}
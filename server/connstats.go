@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync/atomic"
+)
+
+// Connection reuse tracking: how many requests arrive on a fresh
+// connection versus one kept alive from a previous request. A low reuse
+// ratio at high TPS usually means clients are not using keep-alive.
+
+type connInfo struct {
+	requests int64
+}
+
+type connInfoKey struct{}
+
+var (
+	connActive int64
+
+	connOpened           = newCounter("http_connections_opened_total", "TCP connections accepted by the server.")
+	requestsOnNewConn    = newCounter("http_requests_new_connection_total", "Requests that were the first on their connection.")
+	requestsOnReusedConn = newCounter("http_requests_reused_connection_total", "Requests served on a kept-alive connection.")
+
+	_ = newGaugeFunc("http_connections_active", "Connections currently open.", func() float64 {
+		return float64(atomic.LoadInt64(&connActive))
+	})
+	_ = newGaugeFunc("http_connection_reuse_ratio", "Share of requests served on reused connections.", connReuseRatio)
+)
+
+// trackConnState is installed as http.Server.ConnState.
+func trackConnState(c net.Conn, state http.ConnState) {
+	switch state {
+	case http.StateNew:
+		connOpened.inc()
+		atomic.AddInt64(&connActive, 1)
+	case http.StateHijacked, http.StateClosed:
+		atomic.AddInt64(&connActive, -1)
+	}
+}
+
+// connContext is installed as http.Server.ConnContext so each request can
+// find the per-connection counter.
+func connContext(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, connInfoKey{}, &connInfo{})
+}
+
+func connReuseMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if info, ok := r.Context().Value(connInfoKey{}).(*connInfo); ok {
+			if atomic.AddInt64(&info.requests, 1) == 1 {
+				requestsOnNewConn.inc()
+			} else {
+				requestsOnReusedConn.inc()
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func connReuseRatio() float64 {
+	reused := atomic.LoadInt64(&requestsOnReusedConn.value)
+	total := reused + atomic.LoadInt64(&requestsOnNewConn.value)
+	if total == 0 {
+		return 0
+	}
+	return float64(reused) / float64(total)
+}
+
+func connStatsSnapshot() map[string]interface{} {
+	return map[string]interface{}{
+		"opened":          atomic.LoadInt64(&connOpened.value),
+		"active":          atomic.LoadInt64(&connActive),
+		"requests_new":    atomic.LoadInt64(&requestsOnNewConn.value),
+		"requests_reused": atomic.LoadInt64(&requestsOnReusedConn.value),
+		"reuse_ratio":     connReuseRatio(),
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestConnReuseCounters(t *testing.T) {
+	srv := httptest.NewUnstartedServer(connReuseMiddleware(http.HandlerFunc(okHandler)))
+	srv.Config.ConnState = trackConnState
+	srv.Config.ConnContext = connContext
+	srv.Start()
+	defer srv.Close()
+
+	opened, active := atomic.LoadInt64(&connOpened.value), atomic.LoadInt64(&connActive)
+	fresh, reused := atomic.LoadInt64(&requestsOnNewConn.value), atomic.LoadInt64(&requestsOnReusedConn.value)
+	get := func(client *http.Client) {
+		t.Helper()
+		resp, err := client.Get(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+
+	// Three requests over one kept-alive connection
+	keepAlive := &http.Client{Transport: &http.Transport{}}
+	for i := 0; i < 3; i++ {
+		get(keepAlive)
+	}
+	// Two more that each open their own
+	oneShot := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	for i := 0; i < 2; i++ {
+		get(oneShot)
+	}
+
+	if n := atomic.LoadInt64(&connOpened.value) - opened; n != 3 {
+		t.Errorf("connections opened = %d, want 3", n)
+	}
+	if n := atomic.LoadInt64(&requestsOnNewConn.value) - fresh; n != 3 {
+		t.Errorf("requests on a new connection = %d, want 3", n)
+	}
+	if n := atomic.LoadInt64(&requestsOnReusedConn.value) - reused; n != 2 {
+		t.Errorf("requests on a reused connection = %d, want 2", n)
+	}
+
+	// Closing the idle connection brings the active gauge back down
+	keepAlive.CloseIdleConnections()
+	deadline := time.Now().Add(2 * time.Second)
+	for atomic.LoadInt64(&connActive) != active && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if n := atomic.LoadInt64(&connActive); n != active {
+		t.Fatalf("active connections = %d after closing them all, want %d", n, active)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Content-Length mismatches (STRICT_CONTENT_LENGTH). net/http trusts the
+// declared length: an over-declared body leaves the read waiting until the
+// client hangs up or ReadTimeout fires, an under-declared one is cut short
+// and the leftover bytes get parsed as the next request. Either way the
+// handler used to answer with a vague "invalid JSON" or "failed to read".
+
+// bodyLengthError is returned by the request body when fewer bytes than
+// Content-Length arrived.
+type bodyLengthError struct {
+	declared int64
+	received int64
+	timedOut bool // the client stopped sending instead of closing
+}
+
+func (e *bodyLengthError) Error() string {
+	if e.timedOut {
+		return fmt.Sprintf("Content-Length declared %d bytes but only %d arrived before the read timed out", e.declared, e.received)
+	}
+	return fmt.Sprintf("Content-Length declared %d bytes but the body ended after %d", e.declared, e.received)
+}
+
+type lengthCheckedBody struct {
+	io.ReadCloser
+	w        http.ResponseWriter
+	declared int64
+	received int64
+	eof      bool
+}
+
+type lengthCheckedKey struct{}
+
+func (b *lengthCheckedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.received += int64(n)
+	switch {
+	case err == io.EOF:
+		b.eof = true
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return n, &bodyLengthError{declared: b.declared, received: b.received}
+	case err != nil && isTimeout(err) && b.received < b.declared:
+		// The write deadline is about to pass too; leave room for the 400
+		http.NewResponseController(b.w).SetWriteDeadline(time.Now().Add(time.Second))
+		return n, &bodyLengthError{declared: b.declared, received: b.received, timedOut: true}
+	}
+	return n, err
+}
+
+// contentLengthMiddleware tracks how much of a declared-length body was
+// read so handlers can tell a mismatch apart from a malformed payload.
+func contentLengthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !config.StrictContentLength || r.ContentLength <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		body := &lengthCheckedBody{ReadCloser: r.Body, w: w, declared: r.ContentLength}
+		r.Body = body
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), lengthCheckedKey{}, body)))
+	})
+}
+
+// writeBodyLengthMismatch answers 400 when err, returned while reading or
+// decoding the body, comes from a Content-Length mismatch, and reports
+// whether it did. An under-declared length is only visible as a JSON
+// document cut off exactly at the declared length.
+func writeBodyLengthMismatch(w http.ResponseWriter, r *http.Request, err error) bool {
+	if err == nil {
+		return false
+	}
+	var mismatch *bodyLengthError
+	body, _ := r.Context().Value(lengthCheckedKey{}).(*lengthCheckedBody)
+
+	var message string
+	switch {
+	case errors.As(err, &mismatch):
+		bodyLengthMismatches.inc("over")
+		message = mismatch.Error()
+	case body != nil && body.eof && body.received == body.declared && isTruncatedJSON(err):
+		bodyLengthMismatches.inc("under")
+		message = fmt.Sprintf("Body ended at the declared Content-Length (%d bytes) in the middle of the JSON document; Content-Length may be too small", body.declared)
+	default:
+		return false
+	}
+
+	log.Printf("[CONTENT-LENGTH] %s %s from %s: %s", r.Method, r.URL.Path, r.RemoteAddr, message)
+	// Whatever follows the declared length can't be trusted as a request
+	w.Header().Set("Connection", "close")
+	writeJSON(w, r, http.StatusBadRequest, map[string]string{
+		"error": message,
+	})
+	return true
+}
+
+func isTruncatedJSON(err error) bool {
+	var syntaxErr *json.SyntaxError
+	return errors.Is(err, io.ErrUnexpectedEOF) ||
+		(errors.As(err, &syntaxErr) && syntaxErr.Error() == "unexpected end of JSON input")
+}
+
+var bodyLengthMismatches = newCounterVec("content_length_mismatches_total", "Requests answered 400 because the body didn't match Content-Length.", "kind")
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// rawPost sends a request with a hand-written Content-Length, closing the
+// write side after body, and returns the parsed response.
+func rawPost(t *testing.T, srv *httptest.Server, contentLength, body string) (*http.Response, string) {
+	t.Helper()
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	io.WriteString(conn, "POST /api/db/messages HTTP/1.1\r\nHost: test\r\nContent-Type: application/json\r\nContent-Length: "+contentLength+"\r\n\r\n"+body)
+	conn.(*net.TCPConn).CloseWrite()
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	return resp, string(data)
+}
+
+func strictContentLengthServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	withConfig(t, func(c *Config) { c.StrictContentLength = true })
+	srv := httptest.NewServer(contentLengthMiddleware(http.HandlerFunc(dbPostHandler)))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestStrictContentLengthOverDeclared(t *testing.T) {
+	srv := strictContentLengthServer(t)
+
+	resp, body := rawPost(t, srv, "100", `{"content":"hi"}`)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("status = %d, want 400 (%s)", resp.StatusCode, body)
+	}
+	if !strings.Contains(body, "declared 100 bytes") {
+		t.Fatalf("body %s does not explain the mismatch", body)
+	}
+	if !resp.Close {
+		t.Fatal("connection left open after a length mismatch")
+	}
+}
+
+func TestStrictContentLengthUnderDeclared(t *testing.T) {
+	srv := strictContentLengthServer(t)
+
+	resp, body := rawPost(t, srv, "10", `{"content":"hello"}`)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("status = %d, want 400 (%s)", resp.StatusCode, body)
+	}
+	if !strings.Contains(body, "Content-Length may be too small") {
+		t.Fatalf("body %s does not explain the mismatch", body)
+	}
+}
+
+func TestStrictContentLengthOffByDefault(t *testing.T) {
+	withConfig(t, nil)
+	if config.StrictContentLength {
+		t.Fatal("STRICT_CONTENT_LENGTH is on by default")
+	}
+	srv := httptest.NewServer(contentLengthMiddleware(http.HandlerFunc(dbPostHandler)))
+	defer srv.Close()
+
+	// Without the check the truncated body is just invalid JSON
+	resp, body := rawPost(t, srv, "10", `{"content":"hello"}`)
+	if resp.StatusCode != http.StatusBadRequest || !strings.Contains(body, "Invalid JSON payload") {
+		t.Fatalf("got %d %s, want the generic invalid JSON 400", resp.StatusCode, body)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sync/semaphore"
+)
+
+// Per-endpoint caps on concurrent database work, so one noisy route can't
+// hold the whole connection pool. Requests past the cap are rejected rather
+// than queued: waiting would only move the pile-up in front of the pool.
+
+var endpointSemaphores = map[string]*semaphore.Weighted{}
+
+// initEndpointSemaphores builds one semaphore per DB_ENDPOINT_LIMITS entry.
+// It must run before the server starts; the map is read-only afterwards.
+func initEndpointSemaphores(limits map[string]int) {
+	for path, n := range limits {
+		endpointSemaphores[path] = semaphore.NewWeighted(int64(n))
+		log.Printf("[CONFIG] DB concurrency cap: %s limited to %d", path, n)
+	}
+}
+
+func dbLimitMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sem, ok := endpointSemaphores[r.URL.Path]
+		if !ok {
+			next(w, r)
+			return
+		}
+		if !sem.TryAcquire(1) {
+			dbLimitRejections.inc(routeLabel(r))
+			w.Header().Set("Retry-After", "1")
+			writeJSON(w, r, http.StatusServiceUnavailable, map[string]string{
+				"error": "Too many concurrent database requests for this endpoint",
+			})
+			return
+		}
+		defer sem.Release(1)
+		next(w, r)
+	}
+}
+
+// parseEndpointLimits parses "/path=n,/path=n".
+func parseEndpointLimits(value string) map[string]int {
+	result := make(map[string]int)
+	for _, entry := range parseList(value) {
+		path, n, ok := strings.Cut(entry, "=")
+		limit, err := strconv.Atoi(strings.TrimSpace(n))
+		if !ok || err != nil || limit <= 0 {
+			log.Printf("[CONFIG] Ignoring invalid DB endpoint limit %q", entry)
+			continue
+		}
+		result[strings.TrimSpace(path)] = limit
+	}
+	return result
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"golang.org/x/sync/semaphore"
+)
+
+// useEndpointLimits replaces the DB_ENDPOINT_LIMITS semaphores for the test.
+func useEndpointLimits(t *testing.T, limits map[string]int) {
+	t.Helper()
+	prev := endpointSemaphores
+	endpointSemaphores = map[string]*semaphore.Weighted{}
+	initEndpointSemaphores(limits)
+	t.Cleanup(func() { endpointSemaphores = prev })
+}
+
+func TestDBEndpointLimitRejectsOverCap(t *testing.T) {
+	withConfig(t, nil)
+	useEndpointLimits(t, map[string]int{"/api/get": 1})
+	srv := useRouter(t)
+	before := vecValue(dbLimitRejections, "/api/get")
+
+	resp, err := http.Get(srv.URL + "/api/get")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("request under the cap: status = %d, want 200", resp.StatusCode)
+	}
+
+	// An in-flight request holds the only slot
+	endpointSemaphores["/api/get"].TryAcquire(1)
+	defer endpointSemaphores["/api/get"].Release(1)
+	resp, err = http.Get(srv.URL + "/api/get")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Retry-After") != "1" {
+		t.Fatalf("request over the cap: status = %d, Retry-After %q; want 503 and 1", resp.StatusCode, resp.Header.Get("Retry-After"))
+	}
+	if got := vecValue(dbLimitRejections, "/api/get"); got != before+1 {
+		t.Fatalf("db_endpoint_limit_rejections_total{path=\"/api/get\"} went from %d to %d, want +1", before, got)
+	}
+}
+
+func TestDBEndpointLimitSparesStreams(t *testing.T) {
+	withConfig(t, nil)
+	useEndpointLimits(t, map[string]int{"/api/db/messages/stream": 1})
+	srv := useRouter(t)
+
+	openStream(t, srv)
+	openStream(t, srv)
+}
+
+func TestParseEndpointLimits(t *testing.T) {
+	got := parseEndpointLimits("/api/db/messages=50, /api/db/messages/import=2,/bad=0,/worse=x,nothing")
+	if len(got) != 2 || got["/api/db/messages"] != 50 || got["/api/db/messages/import"] != 2 {
+		t.Fatalf("parseEndpointLimits = %v", got)
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Dead-letter handling for message writes: an insert that still fails after
+// DB_WRITE_RETRIES with a transient error is recorded in failed_writes so it
+// can be replayed once the database recovers; permanent errors are not
+// worth keeping. failed_writes lives in the same database, so when that
+// database is down the dead-letter write fails as well and the response
+// says the message was not queued. A row is replayed at most
+// REPLAY_MAX_ATTEMPTS times, then stays in failed_writes for a human.
+
+const replayBatchSize = 1000
+
+// insertMessage writes one message, retrying transient failures.
+func insertMessage(ctx context.Context, msg Message) (int, time.Time, error) {
+	var id int
+	var createdAt time.Time
+	var err error
+
+	qctx, cancel := dbContext(ctx)
+	defer cancel()
+
+	for attempt := 0; attempt <= config.DBWriteRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(config.DBWriteRetryDelayMs) * time.Millisecond)
+		}
+		err = db.QueryRowContext(qctx,
+			tagQuery(ctx, "INSERT INTO messages (content, content_type, title, author, expires_at) VALUES ($1, $2, $3, $4, $5) RETURNING id, created_at"),
+			msg.Content, msg.ContentType, msg.Title, msg.Author, msg.ExpiresAt,
+		).Scan(&id, &createdAt)
+		if err == nil {
+			return id, createdAt, nil
+		}
+		log.Printf("[DB] Insert attempt %d/%d failed: %v", attempt+1, config.DBWriteRetries+1, err)
+		if noteWriteError(err) {
+			// Retrying against a read-only database is pointless
+			break
+		}
+		if !isTransientWriteError(err) {
+			// Constraint and data errors fail the same way every time
+			break
+		}
+		if qctx.Err() != nil {
+			// TOTAL_DEADLINE_MS ran out, a retry can't finish in time
+			break
+		}
+	}
+	return 0, time.Time{}, err
+}
+
+// isTransientWriteError reports failures a retry can get past: broken
+// connections, serialization failures, deadlocks and a full server.
+func isTransientWriteError(err error) bool {
+	if isConnectionError(err) {
+		return true
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code {
+		case "40001", "40P01", "53300": // serialization_failure, deadlock_detected, too_many_connections
+			return true
+		}
+	}
+	return false
+}
+
+func recordFailedWrite(msg Message, cause error) error {
+	_, err := db.Exec(
+		"INSERT INTO failed_writes (content, content_type, title, author, expires_at, error) VALUES ($1, $2, $3, $4, $5, $6)",
+		msg.Content, msg.ContentType, msg.Title, msg.Author, msg.ExpiresAt, cause.Error(),
+	)
+	if err != nil {
+		log.Printf("[DEADLETTER] Could not record failed write: %v", err)
+	}
+	return err
+}
+
+// replayFailedHandler re-attempts recorded writes and removes the ones that
+// succeed. Each row is moved in its own transaction so a partial replay
+// never duplicates or loses a message.
+func replayFailedHandler(w http.ResponseWriter, r *http.Request) {
+	rows, err := db.Query("SELECT id, content, content_type, title, author, expires_at, attempts FROM failed_writes WHERE attempts < $1 ORDER BY id LIMIT $2",
+		config.ReplayMaxAttempts, replayBatchSize)
+	if err != nil {
+		writeJSON(w, r, http.StatusInternalServerError, internalError(r, "Failed to read failed writes", err))
+		return
+	}
+
+	type failedWrite struct {
+		id       int
+		attempts int
+		msg      Message
+	}
+	var pending []failedWrite
+	for rows.Next() {
+		var fw failedWrite
+		var expiresAt sql.NullTime
+		if err := rows.Scan(&fw.id, &fw.msg.Content, &fw.msg.ContentType, &fw.msg.Title, &fw.msg.Author, &expiresAt, &fw.attempts); err != nil {
+			continue
+		}
+		if expiresAt.Valid {
+			fw.msg.ExpiresAt = &expiresAt.Time
+		}
+		pending = append(pending, fw)
+	}
+	rows.Close()
+
+	replayed, failed := 0, 0
+	var stored []Message
+	for _, fw := range pending {
+		msg, err := replayFailedWrite(fw.id, fw.msg)
+		if err != nil {
+			log.Printf("[DEADLETTER] Replay of failed write %d failed: %v", fw.id, err)
+			attempts := fw.attempts + 1
+			if !isTransientWriteError(err) {
+				// No later replay will get past it either
+				attempts = config.ReplayMaxAttempts
+			}
+			if attempts >= config.ReplayMaxAttempts {
+				log.Printf("[DEADLETTER] Giving up on failed write %d after %d attempts", fw.id, attempts)
+			}
+			if _, uerr := db.Exec("UPDATE failed_writes SET attempts = $2, error = $3 WHERE id = $1", fw.id, attempts, err.Error()); uerr != nil {
+				log.Printf("[DEADLETTER] Could not update attempts of failed write %d: %v", fw.id, uerr)
+			}
+			failed++
+			continue
+		}
+		replayed++
+		stored = append(stored, msg)
+	}
+	if replayed > 0 {
+		messagesCache.invalidate()
+		auditWrite(r, "create", storedIDs(stored), int64(replayed), "")
+		for _, msg := range stored {
+			messageStored(msg, len(msg.Content))
+		}
+	}
+
+	remaining, exhausted := -1, -1 // unknown
+	if err := db.QueryRow("SELECT COUNT(*) FROM failed_writes WHERE attempts < $1", config.ReplayMaxAttempts).Scan(&remaining); err != nil {
+		log.Printf("[DEADLETTER] Could not count remaining failed writes: %v", err)
+	}
+	if err := db.QueryRow("SELECT COUNT(*) FROM failed_writes WHERE attempts >= $1", config.ReplayMaxAttempts).Scan(&exhausted); err != nil {
+		log.Printf("[DEADLETTER] Could not count exhausted failed writes: %v", err)
+	}
+
+	log.Printf("[DEADLETTER] Replay finished: %d replayed, %d failed, %d remaining, %d given up", replayed, failed, remaining, exhausted)
+	writeJSON(w, r, http.StatusOK, map[string]interface{}{
+		"replayed":  replayed,
+		"failed":    failed,
+		"remaining": remaining,
+		"exhausted": exhausted,
+	})
+}
+
+// replayFailedWrite moves one failed write into messages and returns the
+// stored message.
+func replayFailedWrite(id int, msg Message) (Message, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return msg, err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRow("INSERT INTO messages (content, content_type, title, author, expires_at) VALUES ($1, $2, $3, $4, $5) RETURNING id, created_at",
+		msg.Content, msg.ContentType, msg.Title, msg.Author, msg.ExpiresAt).Scan(&msg.ID, &msg.CreatedAt)
+	if err != nil {
+		return msg, err
+	}
+	if _, err := tx.Exec("DELETE FROM failed_writes WHERE id = $1", id); err != nil {
+		return msg, err
+	}
+	return msg, tx.Commit()
+}
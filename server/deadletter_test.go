@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+func TestIsTransientWriteError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{&pq.Error{Code: "40001"}, true},
+		{&pq.Error{Code: "40P01"}, true},
+		{&pq.Error{Code: "53300"}, true},
+		{&pq.Error{Code: "08006"}, true},
+		{fmt.Errorf("wrapped: %w", io.ErrUnexpectedEOF), true},
+		{&pq.Error{Code: "23505"}, false}, // unique_violation
+		{&pq.Error{Code: "22001"}, false}, // string_data_right_truncation
+		{errors.New("no such table: messages"), false},
+	}
+	for _, tt := range tests {
+		if got := isTransientWriteError(tt.err); got != tt.want {
+			t.Errorf("isTransientWriteError(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func retryConfig(c *Config) {
+	c.DBWriteRetries = 2
+	c.DBWriteRetryDelayMs = 0
+}
+
+func TestInsertMessageRetriesTransientErrors(t *testing.T) {
+	withConfig(t, retryConfig)
+	fake := useFakeDB(t, &pq.Error{Code: "40001", Message: "could not serialize access"})
+
+	if _, _, err := insertMessage(httptest.NewRequest(http.MethodPost, "/", nil).Context(), Message{Content: "x"}); err == nil {
+		t.Fatal("insert succeeded against a failing database")
+	}
+	if n := fake.count("INSERT INTO messages"); n != 3 {
+		t.Fatalf("%d attempts, want 3 (1 + DB_WRITE_RETRIES)", n)
+	}
+}
+
+func TestInsertMessageDoesNotRetryPermanentErrors(t *testing.T) {
+	withConfig(t, retryConfig)
+	fake := useFakeDB(t, &pq.Error{Code: "23505", Message: "duplicate key"})
+
+	insertMessage(httptest.NewRequest(http.MethodPost, "/", nil).Context(), Message{Content: "x"})
+	if n := fake.count("INSERT INTO messages"); n != 1 {
+		t.Fatalf("%d attempts, want 1", n)
+	}
+}
+
+func TestTransientFailureIsDeadLettered(t *testing.T) {
+	withConfig(t, retryConfig)
+	fake := useFakeDB(t, &pq.Error{Code: "53300", Message: "too many connections"})
+
+	w := postMessage("/api/db/messages", `{"content":"keep me"}`)
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want 500", w.Code)
+	}
+	if n := fake.count("INSERT INTO failed_writes"); n != 1 {
+		t.Fatalf("%d dead-letter inserts, want 1", n)
+	}
+	// failed_writes shares the failing database, so the dead-letter write fails too
+	body := decodeBody(t, w)
+	if body["queued_for_replay"] != false || !strings.Contains(fmt.Sprint(body["replay_error"]), "same database") {
+		t.Fatalf("body = %v, want queued_for_replay false with replay_error", body)
+	}
+}
+
+func TestPermanentFailureIsNotDeadLettered(t *testing.T) {
+	withConfig(t, retryConfig)
+	useTestDB(t)
+	if _, err := db.Exec("DROP TABLE messages"); err != nil {
+		t.Fatal(err)
+	}
+
+	w := postMessage("/api/db/messages", `{"content":"bad forever"}`)
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want 500", w.Code)
+	}
+	body := decodeBody(t, w)
+	if body["queued_for_replay"] != false || !strings.Contains(fmt.Sprint(body["replay_error"]), "permanent") {
+		t.Fatalf("body = %v, want queued_for_replay false for a permanent error", body)
+	}
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM failed_writes").Scan(&n); err != nil || n != 0 {
+		t.Fatalf("failed_writes has %d rows (err %v), want 0", n, err)
+	}
+}
+
+type replayResult struct{ Replayed, Failed, Remaining, Exhausted int }
+
+func runReplay(t *testing.T) replayResult {
+	t.Helper()
+	w := httptest.NewRecorder()
+	replayFailedHandler(w, httptest.NewRequest(http.MethodPost, "/admin/replay-failed", nil))
+	var resp replayResult
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestReplayMovesFailedWrites(t *testing.T) {
+	withConfig(t, nil)
+	useTestDB(t)
+	events := subscribeEvents(t)
+	for _, content := range []string{"first", "second"} {
+		if err := recordFailedWrite(Message{Content: content, ContentType: defaultContentType}, errors.New("db down")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	resp := runReplay(t)
+	if resp.Replayed != 2 || resp.Failed != 0 || resp.Remaining != 0 {
+		t.Fatalf("response = %+v, want 2 replayed and none remaining", resp)
+	}
+	if n := countMessages(t); n != 2 {
+		t.Fatalf("%d messages after replay, want 2", n)
+	}
+	if got := drainEvents(events); len(got) != 2 || got[0].Content != "first" || got[0].ID == 0 {
+		t.Fatalf("published events = %+v, want both replayed messages", got)
+	}
+}
+
+func TestReplaySkipsExhaustedWrites(t *testing.T) {
+	withConfig(t, func(c *Config) { c.ReplayMaxAttempts = 3 })
+	useTestDB(t)
+	for _, content := range []string{"given up", "retry me"} {
+		if err := recordFailedWrite(Message{Content: content, ContentType: defaultContentType}, errors.New("db down")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.Exec("UPDATE failed_writes SET attempts = 3 WHERE content = 'given up'"); err != nil {
+		t.Fatal(err)
+	}
+
+	resp := runReplay(t)
+	if resp.Replayed != 1 || resp.Remaining != 0 || resp.Exhausted != 1 {
+		t.Fatalf("response = %+v, want 1 replayed and 1 exhausted", resp)
+	}
+	if messageContent(t, 1) != "retry me" {
+		t.Fatal("the exhausted write was replayed")
+	}
+}
+
+func TestReplayGivesUpOnPermanentErrors(t *testing.T) {
+	withConfig(t, nil)
+	useTestDB(t)
+	if err := recordFailedWrite(Message{Content: "x", ContentType: defaultContentType}, errors.New("db down")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("DROP TABLE messages"); err != nil {
+		t.Fatal(err)
+	}
+
+	if resp := runReplay(t); resp.Failed != 1 || resp.Exhausted != 1 || resp.Remaining != 0 {
+		t.Fatalf("first replay = %+v, want the row exhausted at once", resp)
+	}
+	if resp := runReplay(t); resp.Failed != 0 || resp.Exhausted != 1 {
+		t.Fatalf("second replay = %+v, want the row left alone", resp)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+)
+
+// End-to-end request budget (TOTAL_DEADLINE_MS): one deadline set at entry
+// covers the throttle delay, rate-limit waits, handler work and DB calls.
+
+type deadlineKey struct{}
+
+// deadlineMiddleware attaches the TOTAL_DEADLINE_MS deadline to the request
+// context and answers 504 when it expires before anything was written.
+func deadlineMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if config.TotalDeadlineMs <= 0 {
+			next(w, r)
+			return
+		}
+
+		deadline := time.Now().Add(time.Duration(config.TotalDeadlineMs) * time.Millisecond)
+		ctx, cancel := context.WithDeadline(r.Context(), deadline)
+		defer cancel()
+		ctx = context.WithValue(ctx, deadlineKey{}, deadline)
+		r = r.WithContext(ctx)
+
+		rec := newStatusRecorder(w)
+		next(rec, r)
+
+		// Throttle and rate-limit waits return silently when the context
+		// ends; the response is still ours to write
+		if !rec.wroteHeader {
+			writeDeadlineExceeded(rec, r)
+		}
+	}
+}
+
+// deadlineExceeded reports whether the request's TOTAL_DEADLINE_MS budget
+// has run out.
+func deadlineExceeded(r *http.Request) bool {
+	_, ok := r.Context().Value(deadlineKey{}).(time.Time)
+	return ok && errors.Is(r.Context().Err(), context.DeadlineExceeded)
+}
+
+// writeDeadlineExceeded sends the 504 if the budget ran out, and reports
+// whether it did so the caller can stop.
+func writeDeadlineExceeded(w http.ResponseWriter, r *http.Request) bool {
+	if !deadlineExceeded(r) {
+		return false
+	}
+	totalDeadlineExceeded.inc()
+	log.Printf("[DEADLINE] %s %s exceeded TOTAL_DEADLINE_MS (%dms)", r.Method, r.URL.Path, config.TotalDeadlineMs)
+	writeJSON(w, r, http.StatusGatewayTimeout, map[string]string{
+		"error": "Request exceeded its total deadline",
+	})
+	return true
+}
+
+// dbContext is the context for a DB call made on behalf of ctx: it keeps
+// the request's total deadline but not its cancellation, so a client
+// disconnecting doesn't abort a write that is already underway.
+func dbContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	base := context.WithoutCancel(ctx)
+	if deadline, ok := ctx.Value(deadlineKey{}).(time.Time); ok {
+		return context.WithDeadline(base, deadline)
+	}
+	return base, func() {}
+}
+
+var totalDeadlineExceeded = newCounter("total_deadline_exceeded_total", "Requests answered 504 because TOTAL_DEADLINE_MS ran out.")
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTotalDeadlineAnswers504DuringThrottle(t *testing.T) {
+	withConfig(t, func(c *Config) {
+		c.ThrottleMinMs = 2000
+		c.ThrottleMaxMs = 2000
+		c.TotalDeadlineMs = 50
+	})
+	before := atomic.LoadInt64(&totalDeadlineExceeded.value)
+
+	handlerRan := false
+	handler := deadlineMiddleware(throttleMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		handlerRan = true
+		okHandler(w, r)
+	}))
+	start := time.Now()
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodGet, "/api/get", nil))
+
+	if w.Code != http.StatusGatewayTimeout {
+		t.Fatalf("status = %d, want 504", w.Code)
+	}
+	if handlerRan {
+		t.Fatal("handler ran although the deadline expired during the throttle delay")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("took %v, the throttle delay was not cut short", elapsed)
+	}
+	if atomic.LoadInt64(&totalDeadlineExceeded.value) != before+1 {
+		t.Fatal("total_deadline_exceeded_total was not incremented")
+	}
+}
+
+func TestTotalDeadlineLeavesResponsesAlone(t *testing.T) {
+	withConfig(t, func(c *Config) { c.TotalDeadlineMs = 1000 })
+
+	w := httptest.NewRecorder()
+	deadlineMiddleware(okHandler)(w, httptest.NewRequest(http.MethodGet, "/api/get", nil))
+	if w.Code != http.StatusOK || w.Body.String() != "ok" {
+		t.Fatalf("got %d %q, want the handler's 200", w.Code, w.Body.String())
+	}
+}
+
+func TestTotalDeadlineSparesStreams(t *testing.T) {
+	withConfig(t, func(c *Config) { c.TotalDeadlineMs = 50 })
+	srv := useRouter(t)
+
+	resp := openStream(t, srv)
+	time.Sleep(150 * time.Millisecond)
+	messageEvents.publish(Message{ID: 7, Content: "still here"})
+
+	reader := bufio.NewReader(resp.Body)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("stream ended past TOTAL_DEADLINE_MS: %v", err)
+		}
+		if strings.HasPrefix(line, "data: ") {
+			if !strings.Contains(line, "still here") {
+				t.Fatalf("unexpected event %q", line)
+			}
+			return
+		}
+	}
+}
+
+func TestTotalDeadlineSparesImports(t *testing.T) {
+	withConfig(t, func(c *Config) {
+		c.TotalDeadlineMs = 50
+		c.ImportBatchSize = 1
+	})
+	useTestDB(t)
+	srv := useRouter(t)
+
+	body, upload := io.Pipe()
+	go func() {
+		io.WriteString(upload, `{"content":"first"}`+"\n")
+		time.Sleep(150 * time.Millisecond)
+		io.WriteString(upload, `{"content":"second"}`+"\n")
+		upload.Close()
+	}()
+	resp, err := http.Post(srv.URL+"/api/db/messages/import", "application/x-ndjson", body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var res importResult
+	json.NewDecoder(resp.Body).Decode(&res)
+	if resp.StatusCode != http.StatusOK || res.Inserted != 2 {
+		t.Fatalf("status %d, inserted %d; want 200 and 2", resp.StatusCode, res.Inserted)
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// deprecated adds Deprecation and Sunset (RFC 8594) headers to routes
+// listed in DEPRECATED_ROUTES, so clients learn a route is going away
+// before it does.
+func deprecated(pattern string, next http.HandlerFunc) http.HandlerFunc {
+	sunset, ok := config.DeprecatedRoutes[pattern]
+	if !ok {
+		return next
+	}
+	sunsetHeader := sunset.UTC().Format(http.TimeFormat)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Sunset", sunsetHeader)
+		next(w, r)
+	}
+}
+
+// parseDeprecatedRoutes parses "/api/get=2027-06-30,/api/post=2027-06-30T00:00:00Z"
+// into route -> sunset time. Dates without a time mean midnight UTC.
+func parseDeprecatedRoutes(value string) map[string]time.Time {
+	result := make(map[string]time.Time)
+	for _, entry := range parseList(value) {
+		route, date, ok := strings.Cut(entry, "=")
+		date = strings.TrimSpace(date)
+		sunset, err := time.Parse(time.RFC3339, date)
+		if err != nil {
+			sunset, err = time.Parse("2006-01-02", date)
+		}
+		if !ok || err != nil {
+			log.Printf("[CONFIG] Ignoring invalid deprecated route entry %q", entry)
+			continue
+		}
+		result[strings.TrimSpace(route)] = sunset
+	}
+	return result
+}
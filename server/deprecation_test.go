@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseDeprecatedRoutes(t *testing.T) {
+	got := parseDeprecatedRoutes("/api/get=2027-06-30, /api/post=2027-06-30T12:00:00Z, /bad=tomorrow, noequals")
+
+	if want := time.Date(2027, 6, 30, 0, 0, 0, 0, time.UTC); !got["/api/get"].Equal(want) {
+		t.Errorf("/api/get sunset = %v, want %v", got["/api/get"], want)
+	}
+	if want := time.Date(2027, 6, 30, 12, 0, 0, 0, time.UTC); !got["/api/post"].Equal(want) {
+		t.Errorf("/api/post sunset = %v, want %v", got["/api/post"], want)
+	}
+	if len(got) != 2 {
+		t.Errorf("parsed %d routes, want 2 (invalid entries skipped): %v", len(got), got)
+	}
+}
+
+func TestDeprecatedRouteHeaders(t *testing.T) {
+	withConfig(t, func(c *Config) { c.DeprecatedRoutes = parseDeprecatedRoutes("/api/get=2027-06-30") })
+
+	w := httptest.NewRecorder()
+	deprecated("/api/get", okHandler)(w, httptest.NewRequest(http.MethodGet, "/api/get", nil))
+	if w.Header().Get("Deprecation") != "true" || w.Header().Get("Sunset") != "Wed, 30 Jun 2027 00:00:00 GMT" {
+		t.Fatalf("headers = %v", w.Header())
+	}
+
+	w = httptest.NewRecorder()
+	deprecated("/api/post", okHandler)(w, httptest.NewRequest(http.MethodPost, "/api/post", nil))
+	if w.Header().Get("Deprecation") != "" {
+		t.Fatal("route not listed got a Deprecation header")
+	}
+}
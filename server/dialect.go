@@ -0,0 +1,197 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	_ "modernc.org/sqlite"
+)
+
+// sqlDialect covers what differs between the database backends selected by
+// DB_DRIVER. Queries that are plain SQL in both stay where they are; only
+// the spots that need backend-specific syntax go through here.
+type sqlDialect interface {
+	name() string
+	open(config Config) (*sql.DB, error)
+	migrate(conn *sql.DB) error
+	// now is the SQL expression for the current time.
+	now() string
+	// idIn is a WHERE condition matching id against ids, with its args.
+	idIn(ids []int64) (string, []interface{})
+	// chunkTable is the DDL creating the stream upload staging table and,
+	// if the backend doesn't drop it on commit, the statement that does.
+	chunkTable() (create, drop string)
+}
+
+var dialect sqlDialect = postgresDialect{}
+
+// dialectFor maps DB_DRIVER to its dialect.
+func dialectFor(driver string) (sqlDialect, error) {
+	switch driver {
+	case "postgres":
+		return postgresDialect{}, nil
+	case "sqlite":
+		return sqliteDialect{}, nil
+	}
+	return nil, fmt.Errorf("unknown DB_DRIVER %q (accepted: postgres, sqlite)", driver)
+}
+
+type postgresDialect struct{}
+
+func (postgresDialect) name() string { return "postgres" }
+
+func (postgresDialect) open(config Config) (*sql.DB, error) {
+	if err := validateDBParams(config); err != nil {
+		log.Printf("[DB] Invalid connection settings: %v", err)
+		return nil, err
+	}
+
+	connStr := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
+		connValue(config.DBHost), config.DBPort, connValue(config.DBUser), connValue(config.DBPassword), connValue(config.DBName))
+
+	log.Printf("[DB] Connecting to PostgreSQL at %s:%s...", config.DBHost, config.DBPort)
+
+	conn, err := sql.Open("postgres", connStr)
+	if err != nil {
+		return nil, err
+	}
+
+	// Configurar pool de conexões para ALTA performance (10k+ TPS)
+	conn.SetMaxOpenConns(200) // 200 conexões simultâneas
+	conn.SetMaxIdleConns(200) // Manter todas idle ativas
+	conn.SetConnMaxLifetime(5 * time.Minute)
+	conn.SetConnMaxIdleTime(1 * time.Minute)
+	log.Printf("[DB] Connection pool configured: MaxOpen=200, MaxIdle=200, MaxLifetime=5m, IdleTime=1m")
+	return conn, nil
+}
+
+func (postgresDialect) migrate(conn *sql.DB) error { return runMigrations(conn) }
+
+func (postgresDialect) now() string { return "now()" }
+
+func (postgresDialect) idIn(ids []int64) (string, []interface{}) {
+	return "id = ANY($1)", []interface{}{pq.Array(ids)}
+}
+
+func (postgresDialect) chunkTable() (string, string) {
+	return "CREATE TEMP TABLE upload_chunks (seq INT, data TEXT) ON COMMIT DROP", ""
+}
+
+// sqliteDialect is a lightweight backend for local development
+// (DB_DRIVER=sqlite, DB_SQLITE_PATH). It is not meant for load tests:
+// SQLite serializes writers, so the pool is a single connection.
+type sqliteDialect struct{}
+
+func (sqliteDialect) name() string { return "sqlite" }
+
+func (sqliteDialect) open(config Config) (*sql.DB, error) {
+	log.Printf("[DB] Opening SQLite database %s...", config.DBSQLitePath)
+
+	// _time_format=sqlite stores times as text that sorts chronologically
+	dsn := "file:" + config.DBSQLitePath + "?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_time_format=sqlite"
+	conn, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetMaxOpenConns(1)
+	return conn, nil
+}
+
+// sqliteSchema is the current schema in one go: a dev database has no
+// history worth migrating, so it is created (or left alone) as a whole.
+var sqliteSchema = []string{
+	`CREATE TABLE IF NOT EXISTS messages (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		content TEXT NOT NULL,
+		content_type TEXT NOT NULL DEFAULT 'text/plain',
+		title TEXT NOT NULL DEFAULT '',
+		author TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		expires_at TIMESTAMP
+	)`,
+	`CREATE INDEX IF NOT EXISTS messages_expires_at_idx ON messages (expires_at) WHERE expires_at IS NOT NULL`,
+	`CREATE TABLE IF NOT EXISTS failed_writes (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		content TEXT NOT NULL,
+		content_type TEXT NOT NULL DEFAULT 'text/plain',
+		title TEXT NOT NULL DEFAULT '',
+		author TEXT NOT NULL DEFAULT '',
+		error TEXT NOT NULL,
+		attempts INT NOT NULL DEFAULT 1,
+		failed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		expires_at TIMESTAMP
+	)`,
+	`CREATE TABLE IF NOT EXISTS api_quotas (
+		client_key TEXT NOT NULL,
+		period_start TIMESTAMP NOT NULL,
+		used INT NOT NULL,
+		PRIMARY KEY (client_key, period_start)
+	)`,
+}
+
+// sqliteAddedColumns brings dev databases created before a column existed
+// up to sqliteSchema. SQLite has no ADD COLUMN IF NOT EXISTS, so a
+// "duplicate column" error means the column is already there.
+var sqliteAddedColumns = []string{
+	`ALTER TABLE messages ADD COLUMN title TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE messages ADD COLUMN author TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE failed_writes ADD COLUMN title TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE failed_writes ADD COLUMN author TEXT NOT NULL DEFAULT ''`,
+}
+
+func (sqliteDialect) migrate(conn *sql.DB) error {
+	for _, stmt := range sqliteSchema {
+		if _, err := conn.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	for _, stmt := range sqliteAddedColumns {
+		if _, err := conn.Exec(stmt); err != nil && !strings.Contains(err.Error(), "duplicate column") {
+			return err
+		}
+	}
+	return nil
+}
+
+// CURRENT_TIMESTAMP is UTC, so time values bound by the app must be UTC too
+// for text comparisons to hold.
+func (sqliteDialect) now() string { return "CURRENT_TIMESTAMP" }
+
+func (sqliteDialect) idIn(ids []int64) (string, []interface{}) {
+	if len(ids) == 0 {
+		return "1 = 0", nil
+	}
+	placeholders := make([]string, len(ids))
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		placeholders[i] = fmt.Sprintf("$%d", i+1)
+		args[i] = id
+	}
+	return "id IN (" + strings.Join(placeholders, ", ") + ")", args
+}
+
+// SQLite has no ON COMMIT DROP; the table is dropped explicitly inside the
+// transaction, and a rollback undoes its creation anyway.
+func (sqliteDialect) chunkTable() (string, string) {
+	return "CREATE TEMP TABLE upload_chunks (seq INT, data TEXT)", "DROP TABLE upload_chunks"
+}
+
+// disablePostgresOnlyFeatures turns off settings that rely on Postgres
+// features when another backend is selected.
+func disablePostgresOnlyFeatures(config *Config) {
+	if config.DBDriver == "postgres" {
+		return
+	}
+	if config.NotifyChannel != "" {
+		log.Printf("[CONFIG] NOTIFY_CHANNEL needs Postgres, disabled for DB_DRIVER=%s", config.DBDriver)
+		config.NotifyChannel = ""
+	}
+	if config.AutoMaintenance {
+		log.Printf("[CONFIG] AUTO_MAINTENANCE (VACUUM ANALYZE) needs Postgres, disabled for DB_DRIVER=%s", config.DBDriver)
+		config.AutoMaintenance = false
+	}
+}
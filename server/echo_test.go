@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func postEcho(t *testing.T, body string) map[string]interface{} {
+	t.Helper()
+	w := httptest.NewRecorder()
+	postHandler(w, httptest.NewRequest(http.MethodPost, "/api/post", strings.NewReader(body)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", w.Code, w.Body.String())
+	}
+	return decodeBody(t, w)
+}
+
+func TestEchoTruncatesOnRuneBoundary(t *testing.T) {
+	// {"content":" is 12 bytes and each é is 2, so byte 21 is mid-rune
+	withConfig(t, func(c *Config) { c.EchoMaxBytes = 21 })
+
+	resp := postEcho(t, `{"content":"`+strings.Repeat("é", 20)+`"}`)
+	got, _ := resp["received"].(string)
+	if resp["truncated"] != true {
+		t.Fatal("long payload not marked truncated")
+	}
+	if !utf8.ValidString(got) || strings.ContainsRune(got, utf8.RuneError) {
+		t.Fatalf("truncated echo %q is not clean UTF-8", got)
+	}
+	if want := `{"content":"` + strings.Repeat("é", 4); got != want {
+		t.Fatalf("received = %q, want %q", got, want)
+	}
+}
+
+func TestEchoShortPayloadUntouched(t *testing.T) {
+	withConfig(t, func(c *Config) { c.EchoMaxBytes = 100 })
+
+	resp := postEcho(t, `{"content":"hi"}`)
+	if _, truncated := resp["truncated"]; truncated {
+		t.Fatal("short payload marked truncated")
+	}
+	if received, ok := resp["received"].(map[string]interface{}); !ok || received["content"] != "hi" {
+		t.Fatalf("received = %v, want the payload as sent", resp["received"])
+	}
+}
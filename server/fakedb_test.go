@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeDB is a database/sql backend that fails every statement with err and
+// records what was sent, for failure paths SQLite can't produce (Postgres
+// error codes such as read_only_sql_transaction).
+type fakeDB struct {
+	mu      sync.Mutex
+	err     error
+	errN    int // when > 0, only the first errN statements fail
+	queries []string
+	args    [][]driver.NamedValue // per statement, aligned with queries
+}
+
+// useFakeDB points db at a fakeDB for the test.
+func useFakeDB(t *testing.T, err error) *fakeDB {
+	t.Helper()
+	f := &fakeDB{err: err}
+	prev := db
+	db = sql.OpenDB(f)
+	t.Cleanup(func() {
+		db.Close()
+		db = prev
+	})
+	return f
+}
+
+// count reports how many statements contained substr.
+func (f *fakeDB) count(substr string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for _, q := range f.queries {
+		if strings.Contains(q, substr) {
+			n++
+		}
+	}
+	return n
+}
+
+// argsOf returns the arguments of the last statement containing substr.
+func (f *fakeDB) argsOf(substr string) []interface{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := len(f.queries) - 1; i >= 0; i-- {
+		if strings.Contains(f.queries[i], substr) {
+			values := make([]interface{}, len(f.args[i]))
+			for j, a := range f.args[i] {
+				values[j] = a.Value
+			}
+			return values
+		}
+	}
+	return nil
+}
+
+func (f *fakeDB) record(query string, args ...driver.NamedValue) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.queries = append(f.queries, query)
+	f.args = append(f.args, args)
+	if f.errN > 0 && len(f.queries) > f.errN {
+		return nil
+	}
+	return f.err
+}
+
+func (f *fakeDB) Connect(context.Context) (driver.Conn, error) { return fakeConn{f}, nil }
+func (f *fakeDB) Driver() driver.Driver                        { return fakeDriver{f} }
+
+type fakeDriver struct{ f *fakeDB }
+
+func (d fakeDriver) Open(string) (driver.Conn, error) { return fakeConn{d.f}, nil }
+
+type fakeConn struct{ f *fakeDB }
+
+func (c fakeConn) Prepare(query string) (driver.Stmt, error) { return nil, c.f.record(query) }
+func (c fakeConn) Close() error                              { return nil }
+func (c fakeConn) Begin() (driver.Tx, error)                 { return nil, c.f.record("BEGIN") }
+
+func (c fakeConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	return nil, c.f.record(query, args...)
+}
+
+func (c fakeConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	return nil, c.f.record(query, args...)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// messageFields is the allow-list for ?fields= on the messages list, in
+// output order.
+var messageFields = []string{"id", "content", "content_type", "title", "author", "created_at", "expires_at"}
+
+// parseFieldsParam returns the requested fields, nil when the parameter is
+// absent (full objects), or the first unknown name.
+func parseFieldsParam(r *http.Request) ([]string, string) {
+	raw := r.URL.Query().Get("fields")
+	if raw == "" {
+		return nil, ""
+	}
+	known := make(map[string]bool, len(messageFields))
+	for _, f := range messageFields {
+		known[f] = true
+	}
+	var fields []string
+	seen := make(map[string]bool)
+	for _, f := range strings.Split(raw, ",") {
+		f = strings.TrimSpace(f)
+		if !known[f] {
+			return nil, f
+		}
+		if !seen[f] {
+			seen[f] = true
+			fields = append(fields, f)
+		}
+	}
+	return fields, ""
+}
+
+// projectMessages keeps only fields of each message. Projection happens
+// after the query so every field selection shares the cached rows.
+func projectMessages(messages []Message, fields []string) []map[string]interface{} {
+	out := make([]map[string]interface{}, len(messages))
+	for i, m := range messages {
+		row := make(map[string]interface{}, len(fields))
+		for _, f := range fields {
+			switch f {
+			case "id":
+				row[f] = m.ID
+			case "content":
+				row[f] = m.Content
+			case "content_type":
+				row[f] = m.ContentType
+			case "title":
+				row[f] = m.Title
+			case "author":
+				row[f] = m.Author
+			case "created_at":
+				row[f] = m.CreatedAt
+			case "expires_at":
+				row[f] = m.ExpiresAt
+			}
+		}
+		out[i] = row
+	}
+	return out
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestParseFieldsParam(t *testing.T) {
+	tests := []struct {
+		query       string
+		want        []string
+		wantUnknown string
+	}{
+		{"", nil, ""},
+		{"fields=id", []string{"id"}, ""},
+		{"fields=title,%20id,title", []string{"title", "id"}, ""},
+		{"fields=id,password", nil, "password"},
+		{"fields=id,", nil, ""},
+	}
+	for _, tt := range tests {
+		got, unknown := parseFieldsParam(httptest.NewRequest(http.MethodGet, "/api/db/messages?"+tt.query, nil))
+		if !reflect.DeepEqual(got, tt.want) || unknown != tt.wantUnknown {
+			t.Errorf("%q: got %v, %q; want %v, %q", tt.query, got, unknown, tt.want, tt.wantUnknown)
+		}
+	}
+}
+
+func TestMessagesListFieldProjection(t *testing.T) {
+	withConfig(t, nil)
+	useTestDB(t)
+	postBulk(dbBulkHandler, `[{"content":"body","title":"hello","author":"me"}]`)
+
+	w := httptest.NewRecorder()
+	dbGetHandler(w, httptest.NewRequest(http.MethodGet, "/api/db/messages?fields=id,title", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		Messages []map[string]interface{} `json:"messages"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Messages) != 1 {
+		t.Fatalf("%d messages, want 1", len(resp.Messages))
+	}
+	if m := resp.Messages[0]; len(m) != 2 || m["title"] != "hello" || m["id"] == nil {
+		t.Fatalf("message = %v, want only id and title", m)
+	}
+}
+
+func TestMessagesListUnknownField(t *testing.T) {
+	withConfig(t, nil)
+	useTestDB(t)
+
+	w := httptest.NewRecorder()
+	dbGetHandler(w, httptest.NewRequest(http.MethodGet, "/api/db/messages?fields=secret", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want 400", w.Code)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// gzipMiddleware compresses responses for clients that accept gzip. The
+// first GZIP_MIN_BYTES are buffered so small responses, where compression
+// costs more CPU than it saves bandwidth, are sent as-is.
+func gzipMiddleware(next http.Handler) http.Handler {
+	level := config.GzipLevel
+	if level < gzip.HuffmanOnly || level > gzip.BestCompression {
+		log.Printf("[CONFIG] Invalid GZIP_LEVEL %d (accepted: %d to %d), using default",
+			level, gzip.HuffmanOnly, gzip.BestCompression)
+		level = gzip.DefaultCompression
+	}
+	pool := &sync.Pool{New: func() interface{} {
+		gz, _ := gzip.NewWriterLevel(io.Discard, level)
+		return gz
+	}}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead || !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		gw := &gzipResponseWriter{ResponseWriter: w, pool: pool, minBytes: config.GzipMinBytes, status: http.StatusOK}
+		defer gw.finish()
+		w.Header().Add("Vary", "Accept-Encoding")
+		next.ServeHTTP(gw, r)
+	})
+}
+
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	pool     *sync.Pool
+	minBytes int
+
+	status  int
+	buf     []byte
+	decided bool // whether compression was chosen or ruled out
+	gz      *gzip.Writer
+}
+
+func (g *gzipResponseWriter) WriteHeader(status int) {
+	if !g.decided {
+		g.status = status
+	}
+}
+
+func (g *gzipResponseWriter) Write(p []byte) (int, error) {
+	if g.decided {
+		if g.gz != nil {
+			return g.gz.Write(p)
+		}
+		return g.ResponseWriter.Write(p)
+	}
+
+	g.buf = append(g.buf, p...)
+	if len(g.buf) >= g.minBytes {
+		if err := g.decide(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// decide commits to compressed or plain output and flushes the buffer.
+func (g *gzipResponseWriter) decide(compress bool) error {
+	g.decided = true
+
+	h := g.Header()
+	if h.Get("Content-Encoding") != "" || g.status == http.StatusNoContent || g.status == http.StatusNotModified {
+		compress = false
+	}
+
+	if compress {
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", "gzip")
+		g.ResponseWriter.WriteHeader(g.status)
+		g.gz = g.pool.Get().(*gzip.Writer)
+		g.gz.Reset(g.ResponseWriter)
+		_, err := g.gz.Write(g.buf)
+		g.buf = nil
+		return err
+	}
+
+	g.ResponseWriter.WriteHeader(g.status)
+	_, err := g.ResponseWriter.Write(g.buf)
+	g.buf = nil
+	return err
+}
+
+func (g *gzipResponseWriter) finish() {
+	if !g.decided {
+		g.decide(false)
+	}
+	if g.gz != nil {
+		g.gz.Close()
+		g.pool.Put(g.gz)
+		g.gz = nil
+	}
+}
+
+// Flush sends buffered bytes right away, compressing them if the threshold
+// was already reached.
+func (g *gzipResponseWriter) Flush() {
+	if !g.decided {
+		g.decide(len(g.buf) >= g.minBytes)
+	}
+	if g.gz != nil {
+		g.gz.Flush()
+	}
+	http.NewResponseController(g.ResponseWriter).Flush()
+}
+
+func (g *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return g.ResponseWriter
+}
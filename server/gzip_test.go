@@ -0,0 +1,65 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func gzipGet(t *testing.T, body string, acceptGzip bool) *httptest.ResponseRecorder {
+	t.Helper()
+	handler := gzipMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		io.WriteString(w, body)
+	}))
+	r := httptest.NewRequest(http.MethodGet, "/api/get", nil)
+	if acceptGzip {
+		r.Header.Set("Accept-Encoding", "gzip, deflate")
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+func TestGzipCompressesAboveThreshold(t *testing.T) {
+	withConfig(t, func(c *Config) { c.GzipMinBytes = 64 })
+	body := strings.Repeat("throttle ", 50)
+
+	w := gzipGet(t, body, true)
+	if w.Header().Get("Content-Encoding") != "gzip" || w.Header().Get("Vary") != "Accept-Encoding" {
+		t.Fatalf("headers = %v, want gzip with Vary", w.Header())
+	}
+	gz, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := io.ReadAll(gz)
+	if string(got) != body {
+		t.Fatalf("decompressed body differs: %q", got)
+	}
+}
+
+func TestGzipSkipsSmallAndUnacceptedResponses(t *testing.T) {
+	withConfig(t, func(c *Config) { c.GzipMinBytes = 64 })
+
+	if w := gzipGet(t, "short", true); w.Header().Get("Content-Encoding") != "" || w.Body.String() != "short" {
+		t.Fatalf("small response was altered: %v %q", w.Header(), w.Body.String())
+	}
+	body := strings.Repeat("x", 200)
+	if w := gzipGet(t, body, false); w.Header().Get("Content-Encoding") != "" || w.Body.String() != body {
+		t.Fatal("response compressed for a client without Accept-Encoding: gzip")
+	}
+}
+
+func TestGzipInvalidLevelFallsBack(t *testing.T) {
+	withConfig(t, func(c *Config) {
+		c.GzipLevel = 42
+		c.GzipMinBytes = 1
+	})
+	if w := gzipGet(t, "payload", true); w.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatal("an invalid GZIP_LEVEL should fall back to the default, not disable compression")
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+func protoHandler(w http.ResponseWriter, r *http.Request) {
+	io.WriteString(w, r.Proto)
+}
+
+func TestH2CServesHTTP2WithoutTLS(t *testing.T) {
+	srv := httptest.NewServer(withH2C(http.HandlerFunc(protoHandler), time.Minute))
+	defer srv.Close()
+
+	client := &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		},
+	}}
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+
+	if resp.ProtoMajor != 2 {
+		t.Fatalf("response proto = %s, want HTTP/2", resp.Proto)
+	}
+	if string(body) != "HTTP/2.0" {
+		t.Fatalf("handler saw %q, want HTTP/2.0", body)
+	}
+}
+
+func TestH2CStillServesHTTP1(t *testing.T) {
+	srv := httptest.NewServer(withH2C(http.HandlerFunc(protoHandler), time.Minute))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+
+	if resp.ProtoMajor != 1 || string(body) != "HTTP/1.1" {
+		t.Fatalf("got %s / %q, want HTTP/1.1", resp.Proto, body)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequiredHeaders(t *testing.T) {
+	withConfig(t, func(c *Config) { c.RequiredHeaders = []string{"X-Tenant-ID", "X-Client-Version"} })
+	handler := requiredHeadersMiddleware(okHandler)
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		missing string
+	}{
+		{"none", nil, "X-Tenant-ID"},
+		{"first only", map[string]string{"X-Tenant-ID": "acme"}, "X-Client-Version"},
+		{"empty value", map[string]string{"X-Tenant-ID": "", "X-Client-Version": "2"}, "X-Tenant-ID"},
+		{"all present", map[string]string{"X-Tenant-ID": "acme", "X-Client-Version": "2"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/get", nil)
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			handler(w, r)
+
+			if tt.missing == "" {
+				if w.Code != http.StatusOK {
+					t.Fatalf("status = %d, want 200 (%s)", w.Code, w.Body.String())
+				}
+				return
+			}
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("status = %d, want 400", w.Code)
+			}
+			if got := decodeBody(t, w)["header"]; got != tt.missing {
+				t.Fatalf("header = %v, want %s", got, tt.missing)
+			}
+		})
+	}
+}
+
+func TestRequiredHeadersUnset(t *testing.T) {
+	withConfig(t, func(c *Config) { c.RequiredHeaders = nil })
+	w := httptest.NewRecorder()
+	requiredHeadersMiddleware(okHandler)(w, httptest.NewRequest(http.MethodGet, "/api/get", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d with no REQUIRED_HEADERS", w.Code)
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"log"
+	"math"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/semaphore"
+)
+
+// dbHealthResult is the outcome of one DB health check (ping + query).
+type dbHealthResult struct {
+	status    string // "connected" or "disconnected"
+	err       string
+	query     map[string]interface{}
+	checkedAt time.Time
+}
+
+// healthCache keeps the last DB health result so frequent orchestrator
+// probes don't each hit the database.
+type healthCache struct {
+	mu     sync.Mutex
+	result *dbHealthResult
+}
+
+var dbHealthCache healthCache
+
+// healthCheckSem caps concurrent DB checks when MAX_CONCURRENT_HEALTH_CHECKS
+// is set; nil keeps the one-check-at-a-time behaviour of cachedDBHealth.
+var healthCheckSem *semaphore.Weighted
+
+// cachedDBHealth returns the last result while it is younger than
+// HEALTH_CACHE_MS, otherwise runs a fresh check. Probes arriving during a
+// check wait on the lock and share its result.
+func cachedDBHealth(ctx context.Context) (dbHealthResult, bool) {
+	if healthCheckSem != nil {
+		return limitedDBHealth(ctx)
+	}
+
+	dbHealthCache.mu.Lock()
+	defer dbHealthCache.mu.Unlock()
+
+	ttl := time.Duration(config.HealthCacheMs) * time.Millisecond
+	if res := dbHealthCache.result; res != nil && ttl > 0 && time.Since(res.checkedAt) < ttl {
+		return *res, true
+	}
+
+	// The result is shared, so one prober disconnecting must not fail it
+	res := checkDBHealth(context.WithoutCancel(ctx))
+	dbHealthCache.result = &res
+	return res, false
+}
+
+// limitedDBHealth runs up to MAX_CONCURRENT_HEALTH_CHECKS checks in
+// parallel. A probe that finds every slot busy gets the last result, stale
+// or not, instead of piling up behind a slow database.
+func limitedDBHealth(ctx context.Context) (dbHealthResult, bool) {
+	ttl := time.Duration(config.HealthCacheMs) * time.Millisecond
+	dbHealthCache.mu.Lock()
+	last := dbHealthCache.result
+	dbHealthCache.mu.Unlock()
+	if last != nil && ttl > 0 && time.Since(last.checkedAt) < ttl {
+		return *last, true
+	}
+
+	if !healthCheckSem.TryAcquire(1) {
+		if last != nil {
+			healthChecksCapped.inc()
+			return *last, true
+		}
+		// Nothing to fall back on before the first check completes
+		if err := healthCheckSem.Acquire(ctx, 1); err != nil {
+			return dbHealthResult{status: "disconnected", err: err.Error(), checkedAt: time.Now()}, false
+		}
+	}
+	defer healthCheckSem.Release(1)
+
+	res := checkDBHealth(context.WithoutCancel(ctx))
+	dbHealthCache.mu.Lock()
+	if dbHealthCache.result == nil || res.checkedAt.After(dbHealthCache.result.checkedAt) {
+		dbHealthCache.result = &res
+	}
+	dbHealthCache.mu.Unlock()
+	return res, false
+}
+
+var healthChecksCapped = newCounter("health_checks_capped_total", "Health probes answered with the last result because MAX_CONCURRENT_HEALTH_CHECKS was reached.")
+
+func checkDBHealth(ctx context.Context) dbHealthResult {
+	res := dbHealthResult{status: "connected", checkedAt: time.Now()}
+
+	pingStart := time.Now()
+	if err := db.PingContext(ctx); err != nil {
+		res.status = "disconnected"
+		res.err = err.Error()
+		log.Printf("[HEALTH] Database ping failed in %v: %v", time.Since(pingStart), err)
+	} else {
+		log.Printf("[HEALTH] Database ping successful in %v", time.Since(pingStart))
+	}
+
+	// Ping só valida a conexão; a query confirma que o banco executa comandos
+	res.query = runHealthQuery(ctx)
+	if res.err == "" && res.query["status"] != "ok" {
+		res.status = "disconnected"
+		res.err = res.query["error"].(string)
+	}
+	return res
+}
+
+// runHealthQuery executes HEALTH_CHECK_QUERY under HEALTH_CHECK_TIMEOUT_MS
+// and reports its outcome and latency.
+func runHealthQuery(ctx context.Context) map[string]interface{} {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(config.HealthCheckTimeoutMs)*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	rows, err := db.QueryContext(ctx, config.HealthCheckQuery)
+	if err == nil {
+		for rows.Next() {
+		}
+		err = rows.Err()
+		rows.Close()
+	}
+	latency := time.Since(start)
+
+	result := map[string]interface{}{
+		"query":      config.HealthCheckQuery,
+		"status":     "ok",
+		"latency_ms": float64(latency.Microseconds()) / 1000,
+	}
+	if err != nil {
+		result["status"] = "failed"
+		result["error"] = err.Error()
+		log.Printf("[HEALTH] Health check query failed in %v: %v", latency, err)
+	}
+	return result
+}
+
+// detailedDiagnostics backs /health?detailed=true: connection pool stats,
+// latency percentiles over a burst of sample queries and runtime figures.
+// Too costly for every probe, so it only runs when asked for, with the
+// admin token.
+func detailedDiagnostics(ctx context.Context) map[string]interface{} {
+	stats := db.Stats()
+	pool := map[string]interface{}{
+		"max_open":            stats.MaxOpenConnections,
+		"open":                stats.OpenConnections,
+		"in_use":              stats.InUse,
+		"idle":                stats.Idle,
+		"wait_count":          stats.WaitCount,
+		"wait_duration_ms":    stats.WaitDuration.Milliseconds(),
+		"max_idle_closed":     stats.MaxIdleClosed,
+		"max_lifetime_closed": stats.MaxLifetimeClosed,
+	}
+
+	samples := config.HealthDetailedSamples
+	if samples <= 0 {
+		samples = 10
+	}
+	var latencies []time.Duration
+	failed := 0
+	for i := 0; i < samples; i++ {
+		qctx, cancel := context.WithTimeout(ctx, time.Duration(config.HealthCheckTimeoutMs)*time.Millisecond)
+		start := time.Now()
+		err := db.QueryRowContext(qctx, "SELECT 1").Scan(new(int))
+		cancel()
+		if err != nil {
+			failed++
+			continue
+		}
+		latencies = append(latencies, time.Since(start))
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+
+	latency := map[string]interface{}{
+		"samples": samples,
+		"failed":  failed,
+	}
+	if len(latencies) > 0 {
+		latency["p50_ms"] = percentileMs(latencies, 0.50)
+		latency["p95_ms"] = percentileMs(latencies, 0.95)
+		latency["p99_ms"] = percentileMs(latencies, 0.99)
+		latency["max_ms"] = percentileMs(latencies, 1)
+	}
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	return map[string]interface{}{
+		"pool":          pool,
+		"query_latency": latency,
+		"runtime": map[string]interface{}{
+			"goroutines":       runtime.NumGoroutine(),
+			"heap_alloc_bytes": mem.HeapAlloc,
+			"num_gc":           mem.NumGC,
+		},
+	}
+}
+
+// percentileMs picks the nearest-rank percentile p (0-1] from a sorted slice.
+func percentileMs(sorted []time.Duration, p float64) float64 {
+	i := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return float64(sorted[i].Microseconds()) / 1000
+}
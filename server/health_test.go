@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/sync/semaphore"
+)
+
+// resetHealthCache drops cached DB health results around the test.
+func resetHealthCache(t *testing.T) {
+	t.Helper()
+	dbHealthCache.result = nil
+	t.Cleanup(func() { dbHealthCache.result = nil })
+}
+
+func getHealth(target, auth string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, target, nil)
+	if auth != "" {
+		r.Header.Set("Authorization", auth)
+	}
+	w := httptest.NewRecorder()
+	healthHandler(w, r)
+	return w
+}
+
+func TestHealthReportsDatabase(t *testing.T) {
+	withConfig(t, nil)
+	useTestDB(t)
+	resetHealthCache(t)
+
+	w := getHealth("/health", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", w.Code, w.Body.String())
+	}
+	body := decodeBody(t, w)
+	if status := body["database"].(map[string]interface{})["status"]; status != "connected" {
+		t.Fatalf("database status = %v, want connected", status)
+	}
+	if _, ok := body["diagnostics"]; ok {
+		t.Fatal("plain /health included diagnostics")
+	}
+}
+
+func TestDetailedHealthRequiresAdmin(t *testing.T) {
+	withConfig(t, func(c *Config) {
+		c.AdminToken = "secret"
+		c.HealthDetailedSamples = 3
+	})
+	useTestDB(t)
+	resetHealthCache(t)
+
+	if w := getHealth("/health?detailed=true", ""); w.Code != http.StatusUnauthorized {
+		t.Fatalf("without token: status = %d, want 401", w.Code)
+	}
+
+	w := getHealth("/health?detailed=true", "Bearer secret")
+	if w.Code != http.StatusOK {
+		t.Fatalf("with token: status = %d, body %s", w.Code, w.Body.String())
+	}
+	diag, ok := decodeBody(t, w)["diagnostics"].(map[string]interface{})
+	if !ok {
+		t.Fatal("diagnostics missing")
+	}
+	if samples := diag["query_latency"].(map[string]interface{})["samples"]; samples != float64(3) {
+		t.Fatalf("samples = %v, want 3", samples)
+	}
+}
+
+func TestDetailedHealthClosedWithoutAdminToken(t *testing.T) {
+	withConfig(t, func(c *Config) { c.AdminToken = "" })
+	useTestDB(t)
+	resetHealthCache(t)
+
+	if w := getHealth("/health?detailed=true", ""); w.Code != http.StatusForbidden {
+		t.Fatalf("status = %d, want 403", w.Code)
+	}
+}
+
+func TestHealthCheckQuery(t *testing.T) {
+	tests := []struct {
+		query      string
+		wantCode   int
+		wantStatus string
+	}{
+		{"SELECT 1", http.StatusOK, "ok"},
+		{"SELECT count(*) FROM messages", http.StatusOK, "ok"},
+		{"SELECT * FROM no_such_table", http.StatusServiceUnavailable, "failed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			withConfig(t, func(c *Config) {
+				c.HealthCheckQuery = tt.query
+				c.HealthCheckTimeoutMs = 1000
+			})
+			useTestDB(t)
+			resetHealthCache(t)
+
+			w := getHealth("/health", "")
+			if w.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d (%s)", w.Code, tt.wantCode, w.Body.String())
+			}
+			database := decodeBody(t, w)["database"].(map[string]interface{})
+			query := database["query"].(map[string]interface{})
+			if query["query"] != tt.query || query["status"] != tt.wantStatus {
+				t.Fatalf("query = %v, want %q %s", query, tt.query, tt.wantStatus)
+			}
+			if tt.wantStatus == "failed" && (query["error"] == nil || database["error"] == nil) {
+				t.Fatalf("failed query reported without its error: %v", database)
+			}
+		})
+	}
+}
+
+func TestHealthCacheSharesOneCheck(t *testing.T) {
+	withConfig(t, func(c *Config) {
+		c.HealthCacheMs = 60000
+		c.HealthCheckTimeoutMs = 1000
+	})
+	useTestDB(t)
+	resetHealthCache(t)
+
+	const probes = 10
+	var wg sync.WaitGroup
+	results := make([]dbHealthResult, probes)
+	fresh := make([]bool, probes)
+	for i := 0; i < probes; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			res, cached := cachedDBHealth(context.Background())
+			results[i], fresh[i] = res, !cached
+		}(i)
+	}
+	wg.Wait()
+
+	checks := 0
+	for i := range results {
+		if fresh[i] {
+			checks++
+		}
+		if !results[i].checkedAt.Equal(results[0].checkedAt) {
+			t.Fatalf("probe %d got a result from %v, want the shared one from %v", i, results[i].checkedAt, results[0].checkedAt)
+		}
+	}
+	if checks != 1 {
+		t.Fatalf("%d fresh checks for %d concurrent probes, want 1", checks, probes)
+	}
+
+	// Once the result is older than HEALTH_CACHE_MS the next probe checks again
+	dbHealthCache.mu.Lock()
+	dbHealthCache.result.checkedAt = dbHealthCache.result.checkedAt.Add(-time.Minute)
+	aged := dbHealthCache.result.checkedAt
+	dbHealthCache.mu.Unlock()
+
+	res, cached := cachedDBHealth(context.Background())
+	if cached || !res.checkedAt.After(aged) {
+		t.Fatalf("after the window: cached = %v, checked at %v, want a new check", cached, res.checkedAt)
+	}
+}
+
+func TestHealthCacheDisabled(t *testing.T) {
+	withConfig(t, func(c *Config) {
+		c.HealthCacheMs = 0
+		c.HealthCheckTimeoutMs = 1000
+	})
+	useTestDB(t)
+	resetHealthCache(t)
+
+	for i := 0; i < 2; i++ {
+		if _, cached := cachedDBHealth(context.Background()); cached {
+			t.Fatalf("probe %d served from cache with HEALTH_CACHE_MS=0", i)
+		}
+	}
+}
+
+// useHealthCheckSem applies MAX_CONCURRENT_HEALTH_CHECKS=n for the test.
+func useHealthCheckSem(t *testing.T, n int64) *semaphore.Weighted {
+	t.Helper()
+	healthCheckSem = semaphore.NewWeighted(n)
+	t.Cleanup(func() { healthCheckSem = nil })
+	return healthCheckSem
+}
+
+func TestMaxConcurrentHealthChecks(t *testing.T) {
+	withConfig(t, func(c *Config) {
+		c.HealthCacheMs = 0
+		c.HealthCheckTimeoutMs = 1000
+	})
+	useTestDB(t)
+	resetHealthCache(t)
+	sem := useHealthCheckSem(t, 1)
+
+	first, cached := cachedDBHealth(context.Background())
+	if cached || first.status != "connected" {
+		t.Fatalf("first check: %+v cached=%v", first, cached)
+	}
+
+	// With the only slot taken by a check in progress, probes get the last
+	// result instead of queueing behind it
+	if !sem.TryAcquire(1) {
+		t.Fatal("slot still held after the first check")
+	}
+	capped := atomic.LoadInt64(&healthChecksCapped.value)
+	for i := 0; i < 3; i++ {
+		res, cached := cachedDBHealth(context.Background())
+		if !cached || !res.checkedAt.Equal(first.checkedAt) {
+			t.Fatalf("probe %d with the slot busy: cached=%v at %v, want the last result", i+1, cached, res.checkedAt)
+		}
+	}
+	if n := atomic.LoadInt64(&healthChecksCapped.value) - capped; n != 3 {
+		t.Fatalf("health_checks_capped_total grew by %d, want 3", n)
+	}
+
+	sem.Release(1)
+	if res, cached := cachedDBHealth(context.Background()); cached || !res.checkedAt.After(first.checkedAt) {
+		t.Fatalf("after the slot freed: cached=%v, want a new check", cached)
+	}
+}
+
+func TestMaxConcurrentHealthChecksBeforeFirstResult(t *testing.T) {
+	withConfig(t, func(c *Config) { c.HealthCheckTimeoutMs = 1000 })
+	useTestDB(t)
+	resetHealthCache(t)
+	sem := useHealthCheckSem(t, 1)
+	sem.TryAcquire(1)
+	defer sem.Release(1)
+
+	// Nothing to fall back on: the probe waits, and gives up with its context
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	res, cached := cachedDBHealth(ctx)
+	if cached || res.status != "disconnected" || res.err == "" {
+		t.Fatalf("probe with no slot and no result = %+v cached=%v, want disconnected", res, cached)
+	}
+}
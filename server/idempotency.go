@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Idempotency-Key support for message creation: the first response for a
+// key is remembered for IDEMPOTENCY_TTL_SEC and replayed to retries of the
+// same request instead of inserting a duplicate. Keys are scoped per client
+// so two clients can't collide, and expired entries are swept periodically.
+
+type idempotencyEntry struct {
+	done     bool // false while the first request is still running
+	status   int
+	body     []byte
+	storedAt time.Time
+}
+
+type idempotencyStore struct {
+	mu      sync.Mutex
+	entries map[string]*idempotencyEntry
+}
+
+var idempotencyKeys = &idempotencyStore{entries: make(map[string]*idempotencyEntry)}
+
+func idempotencyTTL() time.Duration {
+	return time.Duration(config.IdempotencyTTLSec) * time.Second
+}
+
+// begin returns the stored entry for key, or reserves key for the caller
+// and returns nil. An entry older than the TTL counts as absent.
+func (s *idempotencyStore) begin(key string, now time.Time) *idempotencyEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.entries[key]; ok && now.Sub(e.storedAt) < idempotencyTTL() {
+		return e
+	}
+	s.entries[key] = &idempotencyEntry{storedAt: now}
+	return nil
+}
+
+func (s *idempotencyStore) finish(key string, status int, body []byte, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[key] = &idempotencyEntry{done: true, status: status, body: body, storedAt: now}
+}
+
+func (s *idempotencyStore) release(key string) {
+	s.mu.Lock()
+	delete(s.entries, key)
+	s.mu.Unlock()
+}
+
+func (s *idempotencyStore) cleanup(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, e := range s.entries {
+		if now.Sub(e.storedAt) >= idempotencyTTL() {
+			delete(s.entries, key)
+		}
+	}
+}
+
+func startIdempotencyCleanup() {
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			idempotencyKeys.cleanup(now)
+		}
+	}()
+}
+
+// bodyCapture keeps a copy of the body written through it.
+type bodyCapture struct {
+	*statusRecorder
+	body bytes.Buffer
+}
+
+func (b *bodyCapture) Write(p []byte) (int, error) {
+	b.body.Write(p)
+	return b.statusRecorder.Write(p)
+}
+
+// idempotent wraps a create handler with Idempotency-Key handling. Server
+// errors are not remembered, so a retry after a 5xx runs again.
+func idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" || config.IdempotencyTTLSec <= 0 {
+			next(w, r)
+			return
+		}
+		if len(key) > 255 {
+			writeJSON(w, r, http.StatusBadRequest, map[string]string{
+				"error": "Idempotency-Key too long",
+			})
+			return
+		}
+		key = clientKey(r) + "|" + key
+
+		if e := idempotencyKeys.begin(key, time.Now()); e != nil {
+			if !e.done {
+				writeJSON(w, r, http.StatusConflict, map[string]string{
+					"error": "A request with this Idempotency-Key is still being processed",
+				})
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(e.status)
+			w.Write(e.body)
+			return
+		}
+
+		stored := false
+		defer func() {
+			// Also frees the key if the handler panicked
+			if !stored {
+				idempotencyKeys.release(key)
+			}
+		}()
+
+		capture := &bodyCapture{statusRecorder: newStatusRecorder(w)}
+		next(capture, r)
+
+		if capture.status < 500 {
+			idempotencyKeys.finish(key, capture.status, capture.body.Bytes(), time.Now())
+			stored = true
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useIdempotencyStore gives the test its own key store.
+func useIdempotencyStore(t *testing.T) {
+	t.Helper()
+	prev := idempotencyKeys
+	idempotencyKeys = &idempotencyStore{entries: make(map[string]*idempotencyEntry)}
+	t.Cleanup(func() { idempotencyKeys = prev })
+}
+
+func postWithKey(handler http.HandlerFunc, key, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/api/db/messages", strings.NewReader(body))
+	r.Header.Set("Idempotency-Key", key)
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w
+}
+
+func TestIdempotentRetryReplaysFirstResponse(t *testing.T) {
+	withConfig(t, func(c *Config) { c.IdempotencyTTLSec = 60 })
+	useTestDB(t)
+	useIdempotencyStore(t)
+
+	handler := idempotent(dbPostHandler)
+	first := postWithKey(handler, "k1", `{"content":"once"}`)
+	retry := postWithKey(handler, "k1", `{"content":"once"}`)
+
+	if first.Code != http.StatusCreated || retry.Code != http.StatusCreated {
+		t.Fatalf("statuses %d and %d, want 201 twice", first.Code, retry.Code)
+	}
+	if retry.Header().Get("Idempotent-Replayed") != "true" || retry.Body.String() != first.Body.String() {
+		t.Fatalf("retry not replayed: header %q body %s", retry.Header().Get("Idempotent-Replayed"), retry.Body.String())
+	}
+	if n := countMessages(t); n != 1 {
+		t.Fatalf("%d rows stored, want 1", n)
+	}
+
+	postWithKey(handler, "k2", `{"content":"once"}`)
+	if n := countMessages(t); n != 2 {
+		t.Fatalf("a new key did not insert (%d rows)", n)
+	}
+}
+
+func TestIdempotencyKeyExpiresAfterTTL(t *testing.T) {
+	withConfig(t, func(c *Config) { c.IdempotencyTTLSec = 60 })
+	useIdempotencyStore(t)
+
+	now := time.Now()
+	if idempotencyKeys.begin("k", now) != nil {
+		t.Fatal("unknown key reported as stored")
+	}
+	idempotencyKeys.finish("k", http.StatusCreated, []byte("{}"), now)
+	if e := idempotencyKeys.begin("k", now.Add(59*time.Second)); e == nil || !e.done {
+		t.Fatal("key forgotten before IDEMPOTENCY_TTL_SEC")
+	}
+	if idempotencyKeys.begin("k", now.Add(61*time.Second)) != nil {
+		t.Fatal("key still honoured after IDEMPOTENCY_TTL_SEC")
+	}
+
+	idempotencyKeys.finish("old", http.StatusCreated, nil, now)
+	idempotencyKeys.cleanup(now.Add(2 * time.Minute))
+	if _, ok := idempotencyKeys.entries["old"]; ok {
+		t.Fatal("cleanup kept an expired key")
+	}
+}
+
+func TestIdempotencyServerErrorsNotRemembered(t *testing.T) {
+	withConfig(t, func(c *Config) { c.IdempotencyTTLSec = 60 })
+	useIdempotencyStore(t)
+
+	calls := 0
+	handler := idempotent(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	postWithKey(handler, "k", "{}")
+	postWithKey(handler, "k", "{}")
+	if calls != 2 {
+		t.Fatalf("handler ran %d times, want 2 (a 5xx must not be replayed)", calls)
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+	"unicode/utf8"
+)
+
+// storedIDs lists the ids of messages returned by insertImportBatch.
+func storedIDs(stored []Message) []int64 {
+	ids := make([]int64, len(stored))
+	for i, msg := range stored {
+		ids[i] = int64(msg.ID)
+	}
+	return ids
+}
+
+// importLine is a validated NDJSON line waiting to be inserted.
+type importLine struct {
+	line int
+	msg  Message
+}
+
+type importFailure struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+// dbImportHandler reads an NDJSON stream line by line and inserts the
+// messages in batched transactions, without buffering the whole body.
+func dbImportHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+
+	// Large uploads can outlive the server-wide timeouts
+	rc := http.NewResponseController(w)
+	rc.SetReadDeadline(time.Time{})
+	rc.SetWriteDeadline(time.Time{})
+
+	// The scanner's limit is the larger of the buffer's capacity and max, so
+	// the buffer must not start out bigger than IMPORT_MAX_LINE_BYTES
+	initial := 64 * 1024
+	if config.ImportMaxLineBytes < initial {
+		initial = config.ImportMaxLineBytes
+	}
+	scanner := bufio.NewScanner(r.Body)
+	scanner.Buffer(make([]byte, 0, initial), config.ImportMaxLineBytes)
+
+	batchSize := config.ImportBatchSize
+	if batchSize <= 0 {
+		batchSize = 500
+	}
+
+	inserted := 0
+	failures := []importFailure{}
+	batch := make([]importLine, 0, batchSize)
+
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		dbStart := time.Now()
+		stored, err := insertImportBatch(r.Context(), batch)
+		observeDB(r, dbStart)
+		if err != nil {
+			noteWriteError(err)
+			log.Printf("[IMPORT] Batch of %d rows failed: %v", len(batch), err)
+			for _, l := range batch {
+				failures = append(failures, importFailure{Line: l.line, Error: "Failed to insert message"})
+			}
+		} else {
+			inserted += len(batch)
+			messagesCache.invalidate()
+			auditWrite(r, "create", storedIDs(stored), int64(len(batch)), "")
+			for _, msg := range stored {
+				messageStored(msg, len(msg.Content))
+			}
+		}
+		batch = batch[:0]
+	}
+
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		if config.EnforceUTF8 && !utf8.Valid(line) {
+			failures = append(failures, importFailure{Line: lineNum, Error: "content: invalid UTF-8"})
+			continue
+		}
+
+		var msg Message
+		if err := json.Unmarshal(line, &msg); err != nil {
+			failures = append(failures, importFailure{Line: lineNum, Error: "Invalid JSON"})
+			continue
+		}
+		if msg.ContentType == "" {
+			msg.ContentType = defaultContentType
+		}
+		sanitizeMessage(&msg)
+		if errs := validateMessage(msg); len(errs) > 0 {
+			failures = append(failures, importFailure{Line: lineNum, Error: summarizeFieldErrors(errs)})
+			continue
+		}
+
+		batch = append(batch, importLine{line: lineNum, msg: msg})
+		if len(batch) >= batchSize {
+			flush()
+		}
+	}
+	flush()
+
+	if err := scanner.Err(); err != nil {
+		// Scanner stops at the first oversized line or read error
+		failures = append(failures, importFailure{Line: lineNum + 1, Error: "Read error: " + err.Error()})
+	}
+
+	log.Printf("[IMPORT] %d inserted, %d failed in %v", inserted, len(failures), time.Since(start))
+
+	writeJSON(w, r, http.StatusOK, map[string]interface{}{
+		"message":  "Import completed",
+		"inserted": inserted,
+		"failed":   len(failures),
+		"failures": failures,
+	})
+}
+
+// insertImportBatch inserts batch in one transaction, tied to ctx so a
+// client that goes away rolls it back, and returns the stored messages.
+func insertImportBatch(ctx context.Context, batch []importLine) ([]Message, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, tagQuery(ctx, "INSERT INTO messages (content, content_type, title, author) VALUES ($1, $2, $3, $4) RETURNING id, created_at"))
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	stored := make([]Message, 0, len(batch))
+	for _, l := range batch {
+		msg := l.msg
+		if err := stmt.QueryRowContext(ctx, msg.Content, msg.ContentType, msg.Title, msg.Author).Scan(&msg.ID, &msg.CreatedAt); err != nil {
+			return nil, err
+		}
+		stored = append(stored, msg)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return stored, nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type importResult struct {
+	Inserted int             `json:"inserted"`
+	Failed   int             `json:"failed"`
+	Failures []importFailure `json:"failures"`
+}
+
+func runImport(t *testing.T, body string) importResult {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodPost, "/api/db/messages/import", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	dbImportHandler(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", w.Code, w.Body.String())
+	}
+	var res importResult
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	return res
+}
+
+func TestImportInsertsValidLines(t *testing.T) {
+	withConfig(t, func(c *Config) { c.ImportBatchSize = 2 })
+	useTestDB(t)
+	audit := captureAudit(t)
+	events := subscribeEvents(t)
+
+	body := `{"content":"first"}
+{"content":"second","title":"t","author":"a"}
+
+not json
+{"content":""}
+{"content":"third"}
+`
+	res := runImport(t, body)
+
+	if res.Inserted != 3 || res.Failed != 2 {
+		t.Fatalf("inserted %d, failed %d; want 3 and 2 (%+v)", res.Inserted, res.Failed, res.Failures)
+	}
+	if res.Failures[0].Line != 4 || res.Failures[0].Error != "Invalid JSON" {
+		t.Fatalf("first failure = %+v, want line 4 Invalid JSON", res.Failures[0])
+	}
+	if res.Failures[1].Line != 5 {
+		t.Fatalf("second failure = %+v, want line 5", res.Failures[1])
+	}
+	if n := countMessages(t); n != 3 {
+		t.Fatalf("messages table has %d rows, want 3", n)
+	}
+
+	// Each stored row goes through the shared post-write hook and the audit log
+	got := drainEvents(events)
+	if len(got) != 3 || got[0].ID == 0 || got[2].Content != "third" {
+		t.Fatalf("published events = %+v, want the 3 stored messages with ids", got)
+	}
+	if lines := strings.Count(audit.String(), `"action":"create"`); lines != 2 {
+		t.Fatalf("audit has %d create entries, want one per batch (2):\n%s", lines, audit.String())
+	}
+}
+
+func TestImportRejectsLinesOverMaxSize(t *testing.T) {
+	withConfig(t, func(c *Config) { c.ImportMaxLineBytes = 100 })
+	useTestDB(t)
+
+	long := `{"content":"` + strings.Repeat("x", 200) + `"}`
+	res := runImport(t, "{\"content\":\"short\"}\n"+long+"\n")
+
+	if res.Inserted != 1 || res.Failed != 1 {
+		t.Fatalf("inserted %d, failed %d; want 1 and 1", res.Inserted, res.Failed)
+	}
+	if f := res.Failures[0]; f.Line != 2 || !strings.HasPrefix(f.Error, "Read error") {
+		t.Fatalf("failure = %+v, want a read error on line 2", f)
+	}
+}
+
+func TestImportMaxLineBytesClamped(t *testing.T) {
+	for _, value := range []string{"0", "-1", "junk"} {
+		t.Setenv("IMPORT_MAX_LINE_BYTES", value)
+		if got := loadConfig().ImportMaxLineBytes; got != 1048576 {
+			t.Errorf("IMPORT_MAX_LINE_BYTES=%s: got %d, want the 1 MiB default", value, got)
+		}
+	}
+}
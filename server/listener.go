@@ -0,0 +1,127 @@
+package main
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Listener wrappers applied to the TCP listener before http.Server sees a
+// connection.
+
+// acceptRateListener limits how fast new TCP connections are accepted,
+// protecting against connection floods independently of request rate
+// limiting. Surplus connections are delayed, or dropped when the wait would
+// exceed maxWait.
+type acceptRateListener struct {
+	net.Listener
+	limiter *rate.Limiter
+	maxWait time.Duration // 0 = always wait
+}
+
+var (
+	connAcceptDelayed = newCounter("http_connections_accept_delayed_total", "Connections whose acceptance was delayed by CONN_ACCEPT_RATE.")
+	connAcceptDropped = newCounter("http_connections_accept_dropped_total", "Connections closed because the accept wait exceeded CONN_ACCEPT_MAX_WAIT_MS.")
+)
+
+func newAcceptRateListener(l net.Listener, perSecond float64, burst int, maxWait time.Duration) net.Listener {
+	if burst < 1 {
+		burst = 1
+	}
+	return &acceptRateListener{
+		Listener: l,
+		limiter:  rate.NewLimiter(rate.Limit(perSecond), burst),
+		maxWait:  maxWait,
+	}
+}
+
+func (l *acceptRateListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+
+		reservation := l.limiter.Reserve()
+		delay := reservation.Delay()
+		if delay == 0 {
+			return conn, nil
+		}
+		if l.maxWait > 0 && delay > l.maxWait {
+			reservation.Cancel()
+			conn.Close()
+			connAcceptDropped.inc()
+			continue
+		}
+
+		// Blocking here holds back the accept loop, which is the point
+		connAcceptDelayed.inc()
+		time.Sleep(delay)
+		return conn, nil
+	}
+}
+
+// perIPConnListener refuses connections from a source IP that already holds
+// maxPerIP open connections. The count drops when a connection is closed.
+type perIPConnListener struct {
+	net.Listener
+	maxPerIP int
+
+	mu    sync.Mutex
+	conns map[string]int
+}
+
+var connPerIPRefused = newCounter("http_connections_per_ip_refused_total", "Connections closed because the source IP reached MAX_CONNS_PER_IP.")
+
+func newPerIPConnListener(l net.Listener, maxPerIP int) net.Listener {
+	return &perIPConnListener{Listener: l, maxPerIP: maxPerIP, conns: make(map[string]int)}
+}
+
+func (l *perIPConnListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+
+		ip, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+		if err != nil {
+			ip = conn.RemoteAddr().String()
+		}
+
+		l.mu.Lock()
+		if l.conns[ip] >= l.maxPerIP {
+			l.mu.Unlock()
+			conn.Close()
+			connPerIPRefused.inc()
+			continue
+		}
+		l.conns[ip]++
+		l.mu.Unlock()
+
+		return &trackedConn{Conn: conn, release: func() { l.release(ip) }}, nil
+	}
+}
+
+func (l *perIPConnListener) release(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.conns[ip]--; l.conns[ip] <= 0 {
+		delete(l.conns, ip)
+	}
+}
+
+// trackedConn runs release exactly once, however many times Close is called.
+type trackedConn struct {
+	net.Conn
+	once    sync.Once
+	release func()
+}
+
+func (c *trackedConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(c.release)
+	return err
+}
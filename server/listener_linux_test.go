@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"net"
+	"syscall"
+	"testing"
+)
+
+// acceptedSockopt listens with tcpListenConfig, accepts one connection and
+// reads a socket option off the server side of it.
+func acceptedSockopt(t *testing.T, level, opt int) int {
+	t.Helper()
+	l, err := tcpListenConfig().Listen(context.Background(), "tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	dial(t, l, 1)
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	raw, err := conn.(*net.TCPConn).SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var value int
+	var sockErr error
+	if err := raw.Control(func(fd uintptr) {
+		value, sockErr = syscall.GetsockoptInt(int(fd), level, opt)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if sockErr != nil {
+		t.Fatal(sockErr)
+	}
+	return value
+}
+
+func TestKeepAlivePeriodReachesTheSocket(t *testing.T) {
+	withConfig(t, func(c *Config) {
+		c.TCPKeepAlive = true
+		c.TCPKeepAliveSec = 45
+	})
+	if on := acceptedSockopt(t, syscall.SOL_SOCKET, syscall.SO_KEEPALIVE); on == 0 {
+		t.Fatal("SO_KEEPALIVE off on an accepted connection")
+	}
+	if idle := acceptedSockopt(t, syscall.IPPROTO_TCP, syscall.TCP_KEEPIDLE); idle != 45 {
+		t.Fatalf("TCP_KEEPIDLE = %ds, want 45", idle)
+	}
+}
+
+func TestKeepAliveDisabledOnTheSocket(t *testing.T) {
+	withConfig(t, func(c *Config) { c.TCPKeepAlive = false })
+	if on := acceptedSockopt(t, syscall.SOL_SOCKET, syscall.SO_KEEPALIVE); on != 0 {
+		t.Fatal("SO_KEEPALIVE on with TCP_KEEPALIVE=false")
+	}
+}
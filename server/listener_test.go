@@ -0,0 +1,163 @@
+package main
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// localListener listens on a free loopback port for the test.
+func localListener(t *testing.T) net.Listener {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	return l
+}
+
+// dial opens n client connections to l, closed when the test ends.
+func dial(t *testing.T, l net.Listener, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		c, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { c.Close() })
+	}
+}
+
+func TestAcceptRateDelaysSurplusConnections(t *testing.T) {
+	l := newAcceptRateListener(localListener(t), 20, 1, 0) // one every 50ms
+	delayed := atomic.LoadInt64(&connAcceptDelayed.value)
+	dial(t, l, 3)
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		conn, err := l.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Close()
+	}
+	// The first is within the burst, the other two wait 50ms each
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Fatalf("3 accepts took %v at 20/s, want about 100ms", elapsed)
+	}
+	if n := atomic.LoadInt64(&connAcceptDelayed.value) - delayed; n != 2 {
+		t.Fatalf("http_connections_accept_delayed_total grew by %d, want 2", n)
+	}
+}
+
+func TestAcceptRateDropsPastMaxWait(t *testing.T) {
+	l := newAcceptRateListener(localListener(t), 1, 1, 10*time.Millisecond)
+	dropped := atomic.LoadInt64(&connAcceptDropped.value)
+	dial(t, l, 3)
+
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	// The next two would wait about a second, far past the 10ms allowed
+	done := make(chan error, 1)
+	go func() {
+		_, err := l.Accept()
+		done <- err
+	}()
+	deadline := time.Now().Add(2 * time.Second)
+	for atomic.LoadInt64(&connAcceptDropped.value)-dropped < 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if n := atomic.LoadInt64(&connAcceptDropped.value) - dropped; n != 2 {
+		t.Fatalf("http_connections_accept_dropped_total grew by %d, want 2", n)
+	}
+	l.Close()
+	if err := <-done; err == nil {
+		t.Fatal("Accept handed out a connection that should have been dropped")
+	}
+}
+
+func TestPerIPConnListenerRefusesPastLimit(t *testing.T) {
+	l := newPerIPConnListener(localListener(t), 2)
+	refused := atomic.LoadInt64(&connPerIPRefused.value)
+
+	accepted := make(chan net.Conn, 4)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				close(accepted)
+				return
+			}
+			accepted <- conn
+		}
+	}()
+	next := func() net.Conn {
+		t.Helper()
+		select {
+		case conn := <-accepted:
+			return conn
+		case <-time.After(2 * time.Second):
+			t.Fatal("no connection accepted")
+			return nil
+		}
+	}
+
+	dial(t, l, 2)
+	first, second := next(), next()
+
+	// The third from the same address is closed on arrival
+	dial(t, l, 1)
+	deadline := time.Now().Add(2 * time.Second)
+	for atomic.LoadInt64(&connPerIPRefused.value) == refused && time.Now().Before(deadline) {
+		time.Sleep(2 * time.Millisecond)
+	}
+	if n := atomic.LoadInt64(&connPerIPRefused.value) - refused; n != 1 {
+		t.Fatalf("http_connections_per_ip_refused_total grew by %d, want 1", n)
+	}
+	select {
+	case conn := <-accepted:
+		t.Fatalf("connection %v accepted past MAX_CONNS_PER_IP", conn.RemoteAddr())
+	default:
+	}
+
+	// Closing one frees exactly its slot, however often it is closed
+	first.Close()
+	first.Close()
+	pl := l.(*perIPConnListener)
+	pl.mu.Lock()
+	open := pl.conns["127.0.0.1"]
+	pl.mu.Unlock()
+	if open != 1 {
+		t.Fatalf("%d connections counted for 127.0.0.1 after closing one, want 1", open)
+	}
+	dial(t, l, 1)
+	next().Close()
+	second.Close()
+}
+
+func TestTCPListenConfigKeepAlive(t *testing.T) {
+	tests := []struct {
+		enabled bool
+		sec     int
+		want    time.Duration
+	}{
+		{false, 30, -1},
+		{true, 0, 0}, // Go's default period
+		{true, 45, 45 * time.Second},
+	}
+	for _, tt := range tests {
+		withConfig(t, func(c *Config) {
+			c.TCPKeepAlive = tt.enabled
+			c.TCPKeepAliveSec = tt.sec
+		})
+		if got := tcpListenConfig().KeepAlive; got != tt.want {
+			t.Errorf("TCP_KEEPALIVE=%v TCP_KEEPALIVE_SEC=%d: KeepAlive = %v, want %v", tt.enabled, tt.sec, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+// withInFlight pretends n requests are already inside the stack.
+func withInFlight(t *testing.T, n int64) {
+	t.Helper()
+	atomic.AddInt64(&inFlight, n)
+	t.Cleanup(func() { atomic.AddInt64(&inFlight, -n) })
+}
+
+func TestLoadShedDropsWritesBeforeReads(t *testing.T) {
+	withConfig(t, func(c *Config) {
+		c.ShedThreshold = 5
+		c.ShedReadThreshold = 10
+	})
+	handler := loadShedMiddleware(okHandler)
+	send := func(method string) int {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest(method, "/api/db/messages", nil))
+		return w.Code
+	}
+
+	// Between the two thresholds: writes are shed, reads still served
+	withInFlight(t, 7)
+	if code := send(http.MethodPost); code != http.StatusServiceUnavailable {
+		t.Fatalf("POST at 8 in flight = %d, want 503", code)
+	}
+	if code := send(http.MethodGet); code != http.StatusOK {
+		t.Fatalf("GET at 8 in flight = %d, want 200", code)
+	}
+
+	// Past the read threshold both are shed
+	withInFlight(t, 5)
+	if code := send(http.MethodGet); code != http.StatusServiceUnavailable {
+		t.Fatalf("GET at 13 in flight = %d, want 503", code)
+	}
+	if n := atomic.LoadInt64(&inFlight); n != 12 {
+		t.Fatalf("inFlight = %d after the requests, want them released", n)
+	}
+}
+
+func TestLoadShedDisabled(t *testing.T) {
+	withConfig(t, func(c *Config) { c.ShedThreshold = 0 })
+	withInFlight(t, 1000)
+
+	w := httptest.NewRecorder()
+	loadShedMiddleware(okHandler)(w, httptest.NewRequest(http.MethodPost, "/api/db/messages", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d with shedding off", w.Code)
+	}
+}
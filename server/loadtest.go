@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// Load-test bypass (LOAD_TEST_TOKEN): a request carrying the token in
+// X-Load-Test-Token skips the throttle and the rate limiter, so a load
+// test measures the raw throughput of the stack behind them. Quotas and
+// every other middleware still apply.
+
+const loadTestHeader = "X-Load-Test-Token"
+
+// Bypass logs are spaced out: a load test sends thousands of requests per
+// second and one line each would drown the log.
+const loadTestLogInterval = 10 * time.Second
+
+type loadTestKey struct{}
+
+var lastLoadTestLog int64 // unix nanos
+
+func loadTestMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := r.Header.Get(loadTestHeader)
+		if config.LoadTestToken == "" || token == "" {
+			next(w, r)
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(token), []byte(config.LoadTestToken)) != 1 {
+			writeJSON(w, r, http.StatusUnauthorized, map[string]string{
+				"error": "Invalid load test token",
+			})
+			return
+		}
+
+		loadTestBypasses.inc()
+		now := time.Now().UnixNano()
+		last := atomic.LoadInt64(&lastLoadTestLog)
+		if now-last >= int64(loadTestLogInterval) && atomic.CompareAndSwapInt64(&lastLoadTestLog, last, now) {
+			log.Printf("[LOADTEST] WARNING: throttle and rate limit BYPASSED for %s %s from %s (load_test_bypass_total=%d)",
+				r.Method, r.URL.Path, clientIP(r), atomic.LoadInt64(&loadTestBypasses.value))
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), loadTestKey{}, true)))
+	}
+}
+
+// bypassesLimits reports whether loadTestMiddleware accepted the request's
+// token.
+func bypassesLimits(r *http.Request) bool {
+	ok, _ := r.Context().Value(loadTestKey{}).(bool)
+	return ok
+}
+
+var loadTestBypasses = newCounter("load_test_bypass_total", "Requests that skipped throttle and rate limit with LOAD_TEST_TOKEN.")
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/time/rate"
+)
+
+func TestLoadTestTokenBypassesLimits(t *testing.T) {
+	withConfig(t, func(c *Config) {
+		c.LoadTestToken = "bench"
+		c.ThrottleMinMs = 1000
+		c.ThrottleMaxMs = 1000
+		c.RateLimitMode = "reject"
+	})
+	clk := useMockClock(t)
+	useLimiter(t, rate.NewLimiter(0, 0))
+
+	handler := loadTestMiddleware(throttleMiddleware(rateLimitMiddleware(okHandler)))
+	for i := 0; i < 5; i++ {
+		r := httptest.NewRequest(http.MethodGet, "/api/db/messages", nil)
+		r.Header.Set(loadTestHeader, "bench")
+		w := httptest.NewRecorder()
+		handler(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("request %d: status = %d, want 200", i+1, w.Code)
+		}
+	}
+	if clk.Slept() != 0 {
+		t.Fatalf("bypassed requests were throttled for %v", clk.Slept())
+	}
+
+	// Without the token the empty bucket rejects
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodGet, "/api/db/messages", nil))
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("request without token: status = %d, want 429", w.Code)
+	}
+}
+
+func TestLoadTestWrongTokenRejected(t *testing.T) {
+	withConfig(t, func(c *Config) { c.LoadTestToken = "bench" })
+
+	r := httptest.NewRequest(http.MethodGet, "/api/db/messages", nil)
+	r.Header.Set(loadTestHeader, "guess")
+	w := httptest.NewRecorder()
+	loadTestMiddleware(okHandler)(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want 401", w.Code)
+	}
+}
+
+func TestLoadTestHeaderIgnoredWhenDisabled(t *testing.T) {
+	withConfig(t, func(c *Config) { c.LoadTestToken = "" })
+
+	var bypassed bool
+	r := httptest.NewRequest(http.MethodGet, "/api/db/messages", nil)
+	r.Header.Set(loadTestHeader, "anything")
+	loadTestMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		bypassed = bypassesLimits(r)
+	})(httptest.NewRecorder(), r)
+	if bypassed {
+		t.Fatal("header honoured without LOAD_TEST_TOKEN configured")
+	}
+}
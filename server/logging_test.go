@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func accessLogged(t *testing.T, status, n int) int {
+	t.Helper()
+	logs := captureLog(t)
+	handler := loggingMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	})
+	for i := 0; i < n; i++ {
+		handler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/get", nil))
+	}
+	return strings.Count(logs.String(), "[ACCESS]")
+}
+
+func TestLogSampleRateRatio(t *testing.T) {
+	withConfig(t, func(c *Config) {
+		c.AccessLog = true
+		c.LogSampleRate = 0.25
+	})
+	// 2000 draws at 25%: 500 expected, the bounds are ~8 standard deviations out
+	if got := accessLogged(t, http.StatusOK, 2000); got < 350 || got > 650 {
+		t.Fatalf("%d of 2000 successes logged at LOG_SAMPLE_RATE=0.25, want about 500", got)
+	}
+}
+
+func TestLogSampleRateAlwaysLogsErrors(t *testing.T) {
+	withConfig(t, func(c *Config) {
+		c.AccessLog = true
+		c.LogSampleRate = 0
+	})
+	for _, status := range []int{http.StatusBadRequest, http.StatusTooManyRequests, http.StatusInternalServerError} {
+		if got := accessLogged(t, status, 20); got != 20 {
+			t.Errorf("status %d: %d of 20 logged, want all", status, got)
+		}
+	}
+	if got := accessLogged(t, http.StatusOK, 20); got != 0 {
+		t.Fatalf("%d successes logged at LOG_SAMPLE_RATE=0, want none", got)
+	}
+}
+
+func TestLogSampleRateFull(t *testing.T) {
+	withConfig(t, func(c *Config) {
+		c.AccessLog = true
+		c.LogSampleRate = 1
+	})
+	if got := accessLogged(t, http.StatusOK, 50); got != 50 {
+		t.Fatalf("%d of 50 logged at LOG_SAMPLE_RATE=1, want all", got)
+	}
+}
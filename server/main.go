@@ -1,17 +1,29 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"math/rand"
+	"net"
 	"net/http"
 	"os"
 	"runtime"
+	"sort"
 	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 
 	_ "github.com/lib/pq"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"golang.org/x/sync/semaphore"
 	"golang.org/x/time/rate"
 )
 
@@ -19,25 +31,293 @@ var (
 	db      *sql.DB
 	limiter *rate.Limiter
 	config  Config
+
+	inFlight int64 // requests currently inside combinedMiddleware
 )
 
 type Config struct {
-	Port              string
-	DBHost            string
-	DBPort            string
-	DBUser            string
-	DBPassword        string
-	DBName            string
-	RateLimitRequests int
-	RateLimitPeriod   int // seconds
-	ThrottleMinMs     int // minimum delay in milliseconds
-	ThrottleMaxMs     int // maximum delay in milliseconds
+	Port                string
+	DBDriver            string // "postgres" or "sqlite" (local development)
+	DBSQLitePath        string
+	DBHost              string
+	DBPort              string
+	DBUser              string
+	DBPassword          string
+	DBName              string
+	RateLimitRequests   int
+	RateLimitPeriod     int // seconds
+	ThrottleMinMs       int // minimum delay in milliseconds
+	ThrottleMaxMs       int // maximum delay in milliseconds
+	ThrottleTargetMs    int // total latency target; throttle sleeps only what the handler didn't use
+	ThrottleDBLatencyMs int // recent DB latency above which the throttle delay shrinks (0 = off)
+
+	// Bulk import
+	ImportBatchSize    int // rows per transaction on NDJSON import
+	ImportMaxLineBytes int // maximum bytes per NDJSON line
+
+	// Load shedding
+	ShedThreshold     int // in-flight requests above which writes are shed (0 = disabled)
+	ShedReadThreshold int // in-flight requests above which reads are also shed
+
+	// Health check
+	HealthCheckQuery      string
+	HealthCheckTimeoutMs  int
+	HealthDetailedSamples int // sample queries behind /health?detailed=true
+
+	// Echo
+	EchoMaxBytes int // maximum bytes of payload echoed by /api/post (0 = unlimited)
+
+	// Health check cache
+	HealthCacheMs             int // how long a DB health result is reused across probes
+	MaxConcurrentHealthChecks int // DB checks allowed at once; probes past it get the last result (0 = one at a time, others wait)
+
+	// HTTP/2
+	EnableHTTP2 bool // serve HTTP/2 over cleartext (h2c) alongside HTTP/1.1
+
+	// Latency SLOs
+	SLOThresholds map[string]time.Duration // per-path handler latency SLO (throttle delay excluded)
+
+	// Response formatting
+	PrettyJSON bool // indent every JSON response (also per request via ?pretty=true)
+
+	// Messages cache
+	MessagesCacheMs      int // TTL of each cached messages list (0 = disabled)
+	MessagesCacheMaxKeys int // distinct query strings cached at once
+
+	// Gateway contract
+	RequiredHeaders []string // headers every /api/* request must carry
+
+	// Request limits
+	MaxURILength   int // maximum request URI length in bytes (0 = unlimited)
+	MaxQueryParams int // maximum number of query parameters (0 = unlimited)
+
+	// Table maintenance
+	AutoMaintenance        bool
+	MaintenanceIntervalSec int // seconds between VACUUM ANALYZE runs
+
+	// Per-client rate limiting
+	RateLimitKeyHeader string // header identifying the client; enables per-client buckets
+
+	// Message validation
+	MessageMaxLength int // maximum content length in characters (0 = unlimited)
+
+	// Compression
+	EnableGzip   bool
+	GzipMinBytes int // responses smaller than this are sent uncompressed
+	GzipLevel    int // compress/gzip level, -2 (HuffmanOnly) to 9 (BestCompression)
+
+	// DB retries and dead-letter
+	DBWriteRetries      int // extra insert attempts before a write is dead-lettered
+	ReadRetries         int // extra attempts of a read after a connection-level error
+	DBWriteRetryDelayMs int
+	ReplayMaxAttempts   int // attempts of a failed write, the original included, before it is left in failed_writes for good
+
+	// Admin
+	AdminToken string // bearer token required by /admin/* (empty = admin routes answer 403)
+
+	// Access log
+	AccessLog     bool
+	LogSampleRate float64 // fraction of successful requests logged (errors always are)
+
+	// Read-only failover
+	ReadOnlyFailover  bool
+	ReadOnlyRecheckMs int // interval of the probe that detects write recovery
+
+	// Streamed ingestion
+	StreamContentThreshold int // text/plain bodies larger than this are streamed to the DB (0 = disabled)
+
+	// Content denylist
+	ContentDenyPatterns []string // regexes rejected by dbPostHandler, separated by ';'
+
+	// Connection accept throttling
+	ConnAcceptRate      float64 // new connections accepted per second (0 = unlimited)
+	ConnAcceptBurst     int
+	ConnAcceptMaxWaitMs int // surplus connections waiting longer are dropped (0 = never drop)
+
+	// Maintenance mode
+	MaintenanceMode          bool // initial state; togglable via /admin/maintenance
+	MaintenanceRetryAfterSec int
+
+	// Per-IP buckets
+	PerIPRate  float64 // requests per second for each client bucket (0 = use the global rate)
+	PerIPBurst int     // burst of each client bucket (0 = use the global burst)
+
+	// Profiling
+	EnablePprof bool
+	PprofAddr   string // listen address of the pprof server
+
+	// Degraded reads
+	DegradeReadsOnDBDown bool // serve an empty 200 list instead of 500 when the DB query fails
+
+	// Rate limiter warmup
+	RateLimitStartEmpty bool    // start the global bucket with no tokens instead of a full burst
+	SlowStartSec        int     // ramp the global limit up to full over this many seconds (0 = off)
+	SlowStartFraction   float64 // share of the full rate allowed right after startup
+
+	// Content types
+	AllowedContentTypes map[string]bool // allow-list for Message.ContentType
+
+	// Graceful shutdown
+	ShutdownTimeoutSec    int // max time to drain in-flight requests
+	ShutdownDrainDelaySec int // time /readyz reports 503 before the listener closes
+
+	// Middlewares por rota
+	RouteSkipMiddleware map[string][]string  // route -> middleware names to skip
+	RouteMethods        map[string][]string  // route -> allowed methods, anything else is 405
+	DeprecatedRoutes    map[string]time.Time // route -> sunset date announced via Deprecation/Sunset
+
+	// LISTEN/NOTIFY
+	NotifyChannel string // Postgres channel notified on each insert (empty = off)
+
+	// DB write queue
+	DBWriteQueueDepth int // buffered writes before 503 (0 = insert inline)
+	DBWriteWorkers    int // goroutines draining the queue
+
+	// Response envelope
+	ResponseEnvelope string // "bare" (payload as is) or "wrapped" ({data, meta, errors})
+
+	// Bulk insert
+	BulkMaxItems        int   // max array elements per bulk request
+	BulkMaxBytes        int64 // max body size of a bulk request, so one huge element can't be buffered
+	BulkContinueOnError bool  // insert valid rows and report per-row failures with 207
+
+	// Quotas
+	QuotaLimit  int            // requests per client per period (0 = off)
+	QuotaPeriod string         // "day" or "month", UTC
+	QuotaLimits map[string]int // per API key overrides
+
+	// Message expiry
+	ExpiryPurgeIntervalSec int // how often expired messages are deleted (0 = never purge)
+
+	// Shadow database
+	DBShadowURL string // Postgres URL that receives a copy of every insert
+
+	// Per-endpoint DB concurrency
+	DBEndpointLimits map[string]int // path -> max concurrent requests
+
+	// Rate limit wait mode
+	RateLimitMode      string // "reject" (429 at once) or "wait" (delay until a token frees up)
+	RateLimitMaxWaitMs int    // longest wait in wait mode before falling back to 429
+
+	// UTF-8 enforcement
+	EnforceUTF8 bool // reject content with invalid UTF-8 instead of replacing it
+
+	// JSON key casing
+	JSONCase string // "snake" (as declared) or "camel" (createdAt) for response keys
+
+	ErrorVerbosity string // "public" (generic message + error_id) or "debug" (adds the DB error)
+
+	// Root path
+	RootBehavior string // "catalog", "redirect_health" or "status_ok" for GET /
+
+	// Server-Sent Events
+	SSEHeartbeatSec    int // interval of keep-alive comments on idle streams
+	SSEWriteTimeoutSec int // per-write deadline on streams, replaces the server WriteTimeout
+
+	// Server-Timing
+	EnableServerTiming bool // emit Server-Timing with throttle/ratelimit/handler/db/total
+
+	// Idempotency keys
+	IdempotencyTTLSec int // how long an Idempotency-Key result is replayed (0 = ignore the header)
+
+	// Query tags
+	DBTagQueries bool // prefix statements with /* reqid=... endpoint=... method=... */
+
+	// Automatic indexes
+	DBAutoIndexes bool // create read indexes (e.g. messages.created_at) at startup
+
+	// Per-method throttle
+	ThrottleMultipliers map[string]float64      // method -> factor applied to the throttle delay (default 1.0)
+	ThrottleOverrides   map[string]throttleSpan // "ip:..." / "key:..." -> client-specific delay range
+
+	// Webhook
+	WebhookURL       string // receives a POST for every created message
+	WebhookTimeoutMs int    // per-attempt timeout
+	WebhookRetries   int    // extra attempts after a failed delivery
+
+	// Verbose 429
+	RateLimitVerboseBody bool // add policy, limit, period_seconds and retry_after_seconds to 429 bodies
+
+	// Stale-while-revalidate
+	CacheStaleMs int // how long past MESSAGES_CACHE_MS stale data is served while refreshing
+
+	// TLS
+	TLSCertFile   string // serve HTTPS when both cert and key are set
+	TLSKeyFile    string
+	TLSMinVersion string // "1.0" to "1.3"; unknown values abort startup
+
+	// Connections per IP
+	MaxConnsPerIP int // open TCP connections allowed per source IP (0 = unlimited)
+
+	// TCP keep-alive
+	TCPKeepAlive    bool // enable keep-alive probes on accepted connections
+	TCPKeepAliveSec int  // probe period (0 = Go default, 15s)
+
+	// Total request deadline
+	TotalDeadlineMs int // budget for throttle + handler + DB (0 = disabled)
+
+	// Response size guard
+	MaxResponseBytes int // abort responses larger than this (0 = no limit)
+
+	// Content sanitization
+	SanitizeContent string // "none", "escape" (HTML-escape) or "strip" (remove tags) on write
+
+	// QoS classes
+	MaxConcurrentRequests int            // concurrent API requests before queueing (0 = no limit)
+	QoSMaxQueue           int            // requests allowed to wait for a slot
+	QoSHeader             string         // trusted header carrying high/normal/low
+	QoSKeys               map[string]int // RATE_LIMIT_KEY_HEADER value -> class
+
+	// Audit log
+	EnableAuditLog bool   // one JSON line per successful write
+	AuditLogFile   string // append target; empty = stdout
+
+	// Stats summary log
+	StatsLogIntervalSec int // seconds between [STATS] summary lines (0 = off)
+
+	// Backpressure
+	RetryAfterMaxSec int // cap on the dynamic Retry-After of queue-full 503s
+
+	// Read replica
+	DBReplicaURL     string // Postgres URL reads go to (empty = primary only)
+	ReadYourWritesMs int    // after a write, the client reads from the primary for this long
+
+	// Request signing
+	RequireSignature      bool   // reject API requests without a valid X-Signature
+	SignatureSecret       string // shared HMAC-SHA256 secret
+	SignatureMaxSkewSec   int    // accepted distance between X-Timestamp and the server clock
+	SignatureMaxBodyBytes int64  // bodies are buffered to verify them
+
+	// Slow clients
+	WriteTimeoutSec int // server WriteTimeout; slow readers past it are cut off and counted
+
+	// Message schema
+	RequiredMessageFields map[string]bool // optional fields that must be present ("title", "author"); content is always required
+	MessageMetaMaxLength  int             // max characters of title and author (0 = unlimited)
+
+	// Load testing
+	LoadTestToken string // X-Load-Test-Token value that skips throttle and rate limit (empty = disabled)
+
+	// Exemplars
+	EnableExemplars bool // trace ids from traceparent as exemplars on http_request_duration_seconds (OpenMetrics scrapes only)
+
+	// Content-Length
+	StrictContentLength bool // 400 with a clear message when the body doesn't match Content-Length
+
+	// Size metrics
+	EnableSizeMetrics bool // per-endpoint request/response body size histograms
 }
 
 type Message struct {
-	ID        int       `json:"id,omitempty"`
-	Content   string    `json:"content"`
-	CreatedAt time.Time `json:"created_at,omitempty"`
+	ID          int        `json:"id,omitempty"`
+	Content     string     `json:"content"`
+	ContentType string     `json:"content_type"`
+	Title       string     `json:"title"`
+	Author      string     `json:"author"`
+	CreatedAt   time.Time  `json:"created_at,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"` // set via ?ttl_seconds=, nil = never expires
+
+	contentOmitted bool // streamed upload: Content was never held in memory
 }
 
 func loadConfig() Config {
@@ -45,19 +325,408 @@ func loadConfig() Config {
 	rateLimitPeriod, _ := strconv.Atoi(getEnv("RATE_LIMIT_PERIOD", "1"))
 	throttleMinMs, _ := strconv.Atoi(getEnv("THROTTLE_MIN_MS", "0"))
 	throttleMaxMs, _ := strconv.Atoi(getEnv("THROTTLE_MAX_MS", "0"))
+	throttleTargetMs, _ := strconv.Atoi(getEnv("THROTTLE_TARGET_MS", "0"))
+	throttleDBLatencyMs, _ := strconv.Atoi(getEnv("THROTTLE_DB_LATENCY_MS", "0"))
+	importBatchSize, _ := strconv.Atoi(getEnv("IMPORT_BATCH_SIZE", "500"))
+	importMaxLineBytes, _ := strconv.Atoi(getEnv("IMPORT_MAX_LINE_BYTES", "1048576"))
+	if importMaxLineBytes <= 0 {
+		importMaxLineBytes = 1048576
+	}
+	shedThreshold, _ := strconv.Atoi(getEnv("SHED_THRESHOLD", "0"))
+	shedReadThreshold, _ := strconv.Atoi(getEnv("SHED_READ_THRESHOLD", strconv.Itoa(shedThreshold*2)))
+	healthCheckTimeoutMs, _ := strconv.Atoi(getEnv("HEALTH_CHECK_TIMEOUT_MS", "2000"))
+	healthDetailedSamples, _ := strconv.Atoi(getEnv("HEALTH_DETAILED_SAMPLES", "10"))
+	echoMaxBytes, _ := strconv.Atoi(getEnv("ECHO_MAX_BYTES", "0"))
+	healthCacheMs, _ := strconv.Atoi(getEnv("HEALTH_CACHE_MS", "1000"))
+	maxConcurrentHealthChecks, _ := strconv.Atoi(getEnv("MAX_CONCURRENT_HEALTH_CHECKS", "0"))
+	messagesCacheMs, _ := strconv.Atoi(getEnv("MESSAGES_CACHE_MS", "0"))
+	messagesCacheMaxKeys, _ := strconv.Atoi(getEnv("MESSAGES_CACHE_MAX_KEYS", "100"))
+	maxURILength, _ := strconv.Atoi(getEnv("MAX_URI_LENGTH", "0"))
+	maxQueryParams, _ := strconv.Atoi(getEnv("MAX_QUERY_PARAMS", "0"))
+	maintenanceIntervalSec, _ := strconv.Atoi(getEnv("MAINTENANCE_INTERVAL_SEC", "3600"))
+	messageMaxLength, _ := strconv.Atoi(getEnv("MESSAGE_MAX_LENGTH", "0"))
+	gzipMinBytes, _ := strconv.Atoi(getEnv("GZIP_MIN_BYTES", "1024"))
+	gzipLevel, _ := strconv.Atoi(getEnv("GZIP_LEVEL", "-1"))
+	dbWriteRetries, _ := strconv.Atoi(getEnv("DB_WRITE_RETRIES", "2"))
+	readRetries, _ := strconv.Atoi(getEnv("READ_RETRIES", "1"))
+	dbWriteRetryDelayMs, _ := strconv.Atoi(getEnv("DB_WRITE_RETRY_DELAY_MS", "50"))
+	replayMaxAttempts, _ := strconv.Atoi(getEnv("REPLAY_MAX_ATTEMPTS", "5"))
+	if replayMaxAttempts <= 0 {
+		replayMaxAttempts = 5
+	}
+	logSampleRate, _ := strconv.ParseFloat(getEnv("LOG_SAMPLE_RATE", "1"), 64)
+	readOnlyRecheckMs, _ := strconv.Atoi(getEnv("READ_ONLY_RECHECK_MS", "5000"))
+	streamContentThreshold, _ := strconv.Atoi(getEnv("STREAM_CONTENT_THRESHOLD", "0"))
+	connAcceptRate, _ := strconv.ParseFloat(getEnv("CONN_ACCEPT_RATE", "0"), 64)
+	connAcceptBurst, _ := strconv.Atoi(getEnv("CONN_ACCEPT_BURST", strconv.Itoa(int(connAcceptRate))))
+	connAcceptMaxWaitMs, _ := strconv.Atoi(getEnv("CONN_ACCEPT_MAX_WAIT_MS", "0"))
+	maintenanceRetryAfterSec, _ := strconv.Atoi(getEnv("MAINTENANCE_RETRY_AFTER_SEC", "300"))
+	perIPRate, _ := strconv.ParseFloat(getEnv("PER_IP_RATE", "0"), 64)
+	perIPBurst, _ := strconv.Atoi(getEnv("PER_IP_BURST", "0"))
+	shutdownTimeoutSec, _ := strconv.Atoi(getEnv("SHUTDOWN_TIMEOUT_SEC", "30"))
+	shutdownDrainDelaySec, _ := strconv.Atoi(getEnv("SHUTDOWN_DRAIN_DELAY_SEC", "0"))
+	dbWriteQueueDepth, _ := strconv.Atoi(getEnv("DB_WRITE_QUEUE_DEPTH", "0"))
+	dbWriteWorkers, _ := strconv.Atoi(getEnv("DB_WRITE_WORKERS", "4"))
+	responseEnvelope := getEnv("RESPONSE_ENVELOPE", "bare")
+	if responseEnvelope != "bare" && responseEnvelope != "wrapped" {
+		log.Printf("[CONFIG] Unknown RESPONSE_ENVELOPE %q, using bare", responseEnvelope)
+		responseEnvelope = "bare"
+	}
+	bulkMaxItems, _ := strconv.Atoi(getEnv("BULK_MAX_ITEMS", "1000"))
+	if bulkMaxItems <= 0 {
+		bulkMaxItems = 1000
+	}
+	bulkMaxBytes, _ := strconv.ParseInt(getEnv("BULK_MAX_BYTES", "10485760"), 10, 64)
+	if bulkMaxBytes <= 0 {
+		bulkMaxBytes = 10485760
+	}
+	quotaLimit, _ := strconv.Atoi(getEnv("QUOTA_LIMIT", "0"))
+	quotaPeriod := getEnv("QUOTA_PERIOD", "day")
+	if quotaPeriod != "day" && quotaPeriod != "month" {
+		log.Printf("[CONFIG] Unknown QUOTA_PERIOD %q, using day", quotaPeriod)
+		quotaPeriod = "day"
+	}
+	slowStartSec, _ := strconv.Atoi(getEnv("SLOW_START_SEC", "0"))
+	slowStartFraction, _ := strconv.ParseFloat(getEnv("SLOW_START_FRACTION", "0.1"), 64)
+	expiryPurgeIntervalSec, _ := strconv.Atoi(getEnv("EXPIRY_PURGE_INTERVAL_SEC", "60"))
+	rateLimitMode := getEnv("RATE_LIMIT_MODE", "reject")
+	if rateLimitMode != "reject" && rateLimitMode != "wait" {
+		log.Printf("[CONFIG] Unknown RATE_LIMIT_MODE %q, using reject", rateLimitMode)
+		rateLimitMode = "reject"
+	}
+	rateLimitMaxWaitMs, _ := strconv.Atoi(getEnv("RATE_LIMIT_MAX_WAIT_MS", "1000"))
+	sanitizeMode := getEnv("SANITIZE_CONTENT", "none")
+	if sanitizeMode != "none" && sanitizeMode != "escape" && sanitizeMode != "strip" {
+		log.Printf("[CONFIG] Unknown SANITIZE_CONTENT %q, using none", sanitizeMode)
+		sanitizeMode = "none"
+	}
+
+	errorVerbosity := getEnv("ERROR_VERBOSITY", "public")
+	if errorVerbosity != "public" && errorVerbosity != "debug" {
+		log.Printf("[CONFIG] Unknown ERROR_VERBOSITY %q, using public", errorVerbosity)
+		errorVerbosity = "public"
+	}
+
+	jsonCase := getEnv("JSON_CASE", "snake")
+	if jsonCase != "snake" && jsonCase != "camel" {
+		log.Printf("[CONFIG] Unknown JSON_CASE %q, using snake", jsonCase)
+		jsonCase = "snake"
+	}
+	rootBehavior := getEnv("ROOT_BEHAVIOR", "catalog")
+	switch rootBehavior {
+	case "catalog", "redirect_health", "status_ok":
+	default:
+		log.Printf("[CONFIG] Unknown ROOT_BEHAVIOR %q, using catalog", rootBehavior)
+		rootBehavior = "catalog"
+	}
+	sseHeartbeatSec, _ := strconv.Atoi(getEnv("SSE_HEARTBEAT_SEC", "15"))
+	if sseHeartbeatSec <= 0 {
+		sseHeartbeatSec = 15
+	}
+	sseWriteTimeoutSec, _ := strconv.Atoi(getEnv("SSE_WRITE_TIMEOUT_SEC", "10"))
+	if sseWriteTimeoutSec <= 0 {
+		sseWriteTimeoutSec = 10
+	}
+	idempotencyTTLSec, _ := strconv.Atoi(getEnv("IDEMPOTENCY_TTL_SEC", "86400"))
+	webhookTimeoutMs, _ := strconv.Atoi(getEnv("WEBHOOK_TIMEOUT_MS", "5000"))
+	webhookRetries, _ := strconv.Atoi(getEnv("WEBHOOK_RETRIES", "3"))
+	cacheStaleMs, _ := strconv.Atoi(getEnv("CACHE_STALE_MS", "0"))
+	maxConnsPerIP, _ := strconv.Atoi(getEnv("MAX_CONNS_PER_IP", "0"))
+	tcpKeepAliveSec, _ := strconv.Atoi(getEnv("TCP_KEEPALIVE_SEC", "0"))
+	totalDeadlineMs, _ := strconv.Atoi(getEnv("TOTAL_DEADLINE_MS", "0"))
+	maxResponseBytes, _ := strconv.Atoi(getEnv("MAX_RESPONSE_BYTES", "0"))
+	maxConcurrentRequests, _ := strconv.Atoi(getEnv("MAX_CONCURRENT_REQUESTS", "0"))
+	qosMaxQueue, _ := strconv.Atoi(getEnv("QOS_MAX_QUEUE", "100"))
+	statsLogIntervalSec, _ := strconv.Atoi(getEnv("STATS_LOG_INTERVAL_SEC", "0"))
+	retryAfterMaxSec, _ := strconv.Atoi(getEnv("RETRY_AFTER_MAX_SEC", "30"))
+	readYourWritesMs, _ := strconv.Atoi(getEnv("READ_YOUR_WRITES_MS", "5000"))
+	signatureMaxSkewSec, _ := strconv.Atoi(getEnv("SIGNATURE_MAX_SKEW_SEC", "300"))
+	signatureMaxBodyBytes, _ := strconv.ParseInt(getEnv("SIGNATURE_MAX_BODY_BYTES", "10485760"), 10, 64)
+	writeTimeoutSec, _ := strconv.Atoi(getEnv("WRITE_TIMEOUT_SEC", "10"))
+	if writeTimeoutSec <= 0 {
+		writeTimeoutSec = 10
+	}
+	messageMetaMaxLength, _ := strconv.Atoi(getEnv("MESSAGE_META_MAX_LENGTH", "200"))
+	requiredMessageFields := parseSet(getEnv("REQUIRED_MESSAGE_FIELDS", "content"))
+	for field := range requiredMessageFields {
+		if field != "content" && field != "title" && field != "author" {
+			log.Printf("[CONFIG] Unknown REQUIRED_MESSAGE_FIELDS entry %q, ignoring", field)
+			delete(requiredMessageFields, field)
+		}
+	}
 
 	return Config{
-		Port:              getEnv("PORT", "8888"),
-		DBHost:            getEnv("DB_HOST", "postgres"),
-		DBPort:            getEnv("DB_PORT", "5432"),
-		DBUser:            getEnv("DB_USER", "postgres"),
-		DBPassword:        getEnv("DB_PASSWORD", "postgres"),
-		DBName:            getEnv("DB_NAME", "apidb"),
-		RateLimitRequests: rateLimitRequests,
-		RateLimitPeriod:   rateLimitPeriod,
-		ThrottleMinMs:     throttleMinMs,
-		ThrottleMaxMs:     throttleMaxMs,
+		Port:                getEnv("PORT", "8888"),
+		DBDriver:            getEnv("DB_DRIVER", "postgres"),
+		DBSQLitePath:        getEnv("DB_SQLITE_PATH", "dev.db"),
+		DBHost:              getEnv("DB_HOST", "postgres"),
+		DBPort:              getEnv("DB_PORT", "5432"),
+		DBUser:              getEnv("DB_USER", "postgres"),
+		DBPassword:          getEnv("DB_PASSWORD", "postgres"),
+		DBName:              getEnv("DB_NAME", "apidb"),
+		RateLimitRequests:   rateLimitRequests,
+		RateLimitPeriod:     rateLimitPeriod,
+		ThrottleMinMs:       throttleMinMs,
+		ThrottleMaxMs:       throttleMaxMs,
+		ThrottleTargetMs:    throttleTargetMs,
+		ThrottleDBLatencyMs: throttleDBLatencyMs,
+
+		ImportBatchSize:    importBatchSize,
+		ImportMaxLineBytes: importMaxLineBytes,
+
+		ShedThreshold:     shedThreshold,
+		ShedReadThreshold: shedReadThreshold,
+
+		HealthCheckQuery:      getEnv("HEALTH_CHECK_QUERY", "SELECT 1"),
+		HealthCheckTimeoutMs:  healthCheckTimeoutMs,
+		HealthDetailedSamples: healthDetailedSamples,
+
+		EchoMaxBytes: echoMaxBytes,
+
+		HealthCacheMs:             healthCacheMs,
+		MaxConcurrentHealthChecks: maxConcurrentHealthChecks,
+
+		EnableHTTP2: getEnv("ENABLE_HTTP2", "false") == "true",
+
+		SLOThresholds: parseDurationMap(getEnv("SLO_THRESHOLDS_MS", "")),
+
+		PrettyJSON: getEnv("PRETTY_JSON", "false") == "true",
+
+		MessagesCacheMs:      messagesCacheMs,
+		MessagesCacheMaxKeys: messagesCacheMaxKeys,
+
+		RequiredHeaders: parseList(getEnv("REQUIRED_HEADERS", "")),
+
+		MaxURILength:   maxURILength,
+		MaxQueryParams: maxQueryParams,
+
+		AutoMaintenance:        getEnv("AUTO_MAINTENANCE", "false") == "true",
+		MaintenanceIntervalSec: maintenanceIntervalSec,
+
+		RateLimitKeyHeader: getEnv("RATE_LIMIT_KEY_HEADER", ""),
+
+		MessageMaxLength: messageMaxLength,
+
+		EnableGzip:   getEnv("ENABLE_GZIP", "false") == "true",
+		GzipMinBytes: gzipMinBytes,
+		GzipLevel:    gzipLevel,
+
+		DBWriteRetries:      dbWriteRetries,
+		ReadRetries:         readRetries,
+		DBWriteRetryDelayMs: dbWriteRetryDelayMs,
+		ReplayMaxAttempts:   replayMaxAttempts,
+
+		AdminToken: getEnv("ADMIN_TOKEN", ""),
+
+		AccessLog:     getEnv("ACCESS_LOG", "false") == "true",
+		LogSampleRate: logSampleRate,
+
+		ReadOnlyFailover:  getEnv("READ_ONLY_FAILOVER", "false") == "true",
+		ReadOnlyRecheckMs: readOnlyRecheckMs,
+
+		StreamContentThreshold: streamContentThreshold,
+
+		ContentDenyPatterns: parseDenyPatterns(getEnv("CONTENT_DENY_PATTERNS", "")),
+
+		ConnAcceptRate:      connAcceptRate,
+		ConnAcceptBurst:     connAcceptBurst,
+		ConnAcceptMaxWaitMs: connAcceptMaxWaitMs,
+
+		MaintenanceMode:          getEnv("MAINTENANCE_MODE", "false") == "true",
+		MaintenanceRetryAfterSec: maintenanceRetryAfterSec,
+
+		PerIPRate:  perIPRate,
+		PerIPBurst: perIPBurst,
+
+		EnablePprof: getEnv("ENABLE_PPROF", "false") == "true",
+		PprofAddr:   getEnv("PPROF_ADDR", "localhost:6060"),
+
+		DegradeReadsOnDBDown: getEnv("DEGRADE_READS_ON_DB_DOWN", "false") == "true",
+
+		RateLimitStartEmpty: getEnv("RATE_LIMIT_START_EMPTY", "false") == "true",
+		SlowStartSec:        slowStartSec,
+		SlowStartFraction:   slowStartFraction,
+
+		AllowedContentTypes: parseSet(getEnv("MESSAGE_CONTENT_TYPES", "text/plain,text/markdown,application/json")),
+
+		ShutdownTimeoutSec:    shutdownTimeoutSec,
+		ShutdownDrainDelaySec: shutdownDrainDelaySec,
+
+		RouteSkipMiddleware: parseRouteSkips(getEnv("ROUTE_SKIP_MIDDLEWARE", "")),
+		RouteMethods:        parseRouteMethods(getEnv("ROUTE_METHODS", "")),
+		DeprecatedRoutes:    parseDeprecatedRoutes(getEnv("DEPRECATED_ROUTES", "")),
+
+		NotifyChannel: getEnv("NOTIFY_CHANNEL", ""),
+
+		DBWriteQueueDepth: dbWriteQueueDepth,
+		DBWriteWorkers:    dbWriteWorkers,
+
+		ResponseEnvelope: responseEnvelope,
+
+		BulkMaxItems:        bulkMaxItems,
+		BulkMaxBytes:        bulkMaxBytes,
+		BulkContinueOnError: getEnv("BULK_CONTINUE_ON_ERROR", "false") == "true",
+
+		QuotaLimit:  quotaLimit,
+		QuotaPeriod: quotaPeriod,
+		QuotaLimits: parseQuotaLimits(getEnv("QUOTA_LIMITS", "")),
+
+		ExpiryPurgeIntervalSec: expiryPurgeIntervalSec,
+
+		DBShadowURL: getEnv("DB_SHADOW_URL", ""),
+
+		DBEndpointLimits: parseEndpointLimits(getEnv("DB_ENDPOINT_LIMITS", "")),
+
+		RateLimitMode:      rateLimitMode,
+		RateLimitMaxWaitMs: rateLimitMaxWaitMs,
+
+		EnforceUTF8: getEnv("ENFORCE_UTF8", "false") == "true",
+
+		JSONCase:       jsonCase,
+		ErrorVerbosity: errorVerbosity,
+
+		RootBehavior: rootBehavior,
+
+		SSEHeartbeatSec:    sseHeartbeatSec,
+		SSEWriteTimeoutSec: sseWriteTimeoutSec,
+
+		EnableServerTiming: getEnv("ENABLE_SERVER_TIMING", "false") == "true",
+
+		IdempotencyTTLSec: idempotencyTTLSec,
+
+		DBTagQueries: getEnv("DB_QUERY_COMMENTS", getEnv("DB_TAG_QUERIES", "false")) == "true",
+
+		DBAutoIndexes: getEnv("DB_AUTO_INDEXES", "false") == "true",
+
+		ThrottleMultipliers: parseMethodMultipliers(),
+		ThrottleOverrides:   parseThrottleOverrides(getEnv("THROTTLE_CLIENT_OVERRIDES", "")),
+
+		WebhookURL:       getEnv("WEBHOOK_URL", ""),
+		WebhookTimeoutMs: webhookTimeoutMs,
+		WebhookRetries:   webhookRetries,
+
+		RateLimitVerboseBody: getEnv("RATE_LIMIT_VERBOSE_BODY", "false") == "true",
+
+		CacheStaleMs: cacheStaleMs,
+
+		TLSCertFile:   getEnv("TLS_CERT_FILE", ""),
+		TLSKeyFile:    getEnv("TLS_KEY_FILE", ""),
+		TLSMinVersion: getEnv("TLS_MIN_VERSION", "1.2"),
+
+		MaxConnsPerIP: maxConnsPerIP,
+
+		TCPKeepAlive:    getEnv("TCP_KEEPALIVE", "true") == "true",
+		TCPKeepAliveSec: tcpKeepAliveSec,
+
+		TotalDeadlineMs: totalDeadlineMs,
+
+		MaxResponseBytes: maxResponseBytes,
+
+		SanitizeContent: sanitizeMode,
+
+		MaxConcurrentRequests: maxConcurrentRequests,
+		QoSMaxQueue:           qosMaxQueue,
+		QoSHeader:             getEnv("QOS_HEADER", ""),
+		QoSKeys:               parseQoSKeys(getEnv("QOS_KEYS", "")),
+
+		EnableAuditLog: getEnv("ENABLE_AUDIT_LOG", "false") == "true",
+		AuditLogFile:   getEnv("AUDIT_LOG_FILE", ""),
+
+		StatsLogIntervalSec: statsLogIntervalSec,
+
+		RetryAfterMaxSec: retryAfterMaxSec,
+
+		DBReplicaURL:     getEnv("DB_REPLICA_URL", ""),
+		ReadYourWritesMs: readYourWritesMs,
+
+		RequireSignature:      getEnv("REQUIRE_SIGNATURE", "false") == "true",
+		SignatureSecret:       getEnv("SIGNATURE_SECRET", ""),
+		SignatureMaxSkewSec:   signatureMaxSkewSec,
+		SignatureMaxBodyBytes: signatureMaxBodyBytes,
+
+		WriteTimeoutSec: writeTimeoutSec,
+
+		RequiredMessageFields: requiredMessageFields,
+		MessageMetaMaxLength:  messageMetaMaxLength,
+
+		LoadTestToken: getEnv("LOAD_TEST_TOKEN", ""),
+
+		EnableExemplars: getEnv("ENABLE_EXEMPLARS", "false") == "true",
+
+		StrictContentLength: getEnv("STRICT_CONTENT_LENGTH", "false") == "true",
+
+		EnableSizeMetrics: getEnv("ENABLE_SIZE_METRICS", "false") == "true",
+	}
+}
+
+// parseList splits a comma-separated value, dropping empty entries.
+func parseList(value string) []string {
+	var result []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+func parseSet(value string) map[string]bool {
+	set := make(map[string]bool)
+	for _, item := range parseList(value) {
+		set[item] = true
+	}
+	return set
+}
+
+// parseDenyPatterns splits on ';' since commas are common inside regexes
+// (e.g. `{2,5}`).
+func parseDenyPatterns(value string) []string {
+	var result []string
+	for _, p := range strings.Split(value, ";") {
+		if p = strings.TrimSpace(p); p != "" {
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+// parseDurationMap parses "key=ms,key=ms" into millisecond durations,
+// skipping malformed entries.
+func parseDurationMap(value string) map[string]time.Duration {
+	result := make(map[string]time.Duration)
+	for _, entry := range strings.Split(value, ",") {
+		key, ms, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok {
+			continue
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(ms))
+		if err != nil || n <= 0 {
+			log.Printf("[CONFIG] Ignoring invalid duration entry %q", entry)
+			continue
+		}
+		result[strings.TrimSpace(key)] = time.Duration(n) * time.Millisecond
 	}
+	return result
+}
+
+// parseMethodMultipliers reads THROTTLE_<METHOD>_MULTIPLIER for the usual
+// methods, keeping only valid non-negative values.
+func parseMethodMultipliers() map[string]float64 {
+	result := make(map[string]float64)
+	for _, method := range []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete} {
+		key := "THROTTLE_" + method + "_MULTIPLIER"
+		value := getEnv(key, "")
+		if value == "" {
+			continue
+		}
+		m, err := strconv.ParseFloat(value, 64)
+		if err != nil || m < 0 {
+			log.Printf("[CONFIG] Ignoring invalid %s=%q", key, value)
+			continue
+		}
+		result[method] = m
+	}
+	return result
 }
 
 func getEnv(key, defaultValue string) string {
@@ -67,25 +736,44 @@ func getEnv(key, defaultValue string) string {
 	return defaultValue
 }
 
-func initDB(config Config) error {
-	connStr := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
-		config.DBHost, config.DBPort, config.DBUser, config.DBPassword, config.DBName)
+// validateDBParams catches malformed DB_* settings up front; otherwise
+// sql.Open accepts them and the mistake only surfaces as a Ping error after
+// the whole retry loop.
+func validateDBParams(config Config) error {
+	if strings.TrimSpace(config.DBHost) == "" {
+		return fmt.Errorf("DB_HOST is empty")
+	}
+	port, err := strconv.Atoi(config.DBPort)
+	if err != nil || port < 1 || port > 65535 {
+		return fmt.Errorf("DB_PORT %q is not a valid port number", config.DBPort)
+	}
+	if config.DBUser == "" {
+		return fmt.Errorf("DB_USER is empty")
+	}
+	if config.DBName == "" {
+		return fmt.Errorf("DB_NAME is empty")
+	}
+	return nil
+}
 
-	log.Printf("[DB] Connecting to PostgreSQL at %s:%s...", config.DBHost, config.DBPort)
+// connValue quotes a value for a key=value connection string, so spaces or
+// quotes (e.g. in a password) can't break the string apart.
+func connValue(v string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(v) + "'"
+}
 
+func initDB(config Config) error {
 	var err error
-	db, err = sql.Open("postgres", connStr)
+	dialect, err = dialectFor(config.DBDriver)
 	if err != nil {
-		log.Printf("[DB] Error opening connection: %v", err)
 		return err
 	}
 
-	// Configurar pool de conexões para ALTA performance (10k+ TPS)
-	db.SetMaxOpenConns(200) // 200 conexões simultâneas
-	db.SetMaxIdleConns(200) // Manter todas idle ativas
-	db.SetConnMaxLifetime(5 * time.Minute)
-	db.SetConnMaxIdleTime(1 * time.Minute)
-	log.Printf("[DB] Connection pool configured: MaxOpen=200, MaxIdle=200, MaxLifetime=5m, IdleTime=1m")
+	db, err = dialect.open(config)
+	if err != nil {
+		log.Printf("[DB] Error opening %s connection: %v", dialect.name(), err)
+		return err
+	}
 
 	// Wait for database to be ready
 	maxRetries := 30
@@ -104,119 +792,459 @@ func initDB(config Config) error {
 		return err
 	}
 
-	// Create table if not exists
-	log.Printf("[DB] Creating tables if not exist...")
-	_, err = db.Exec(`
-		CREATE TABLE IF NOT EXISTS messages (
-			id SERIAL PRIMARY KEY,
-			content TEXT NOT NULL,
-			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-		)
-	`)
-
-	if err != nil {
+	// Create tables and apply schema changes
+	log.Printf("[DB] Running migrations...")
+	if err := dialect.migrate(db); err != nil {
 		log.Printf("[DB] Error creating tables: %v", err)
 		return err
 	}
 
+	if config.DBAutoIndexes {
+		if err := createReadIndexes(db); err != nil {
+			return err
+		}
+	}
+
 	log.Printf("[DB] Tables ready")
 	return nil
 }
 
 func throttleMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		if operationalRoutes[r.URL.Path] || bypassesLimits(r) {
+			next(w, r)
+			return
+		}
+
+		span, overridden := throttleOverrideFor(r)
+		if config.ThrottleTargetMs > 0 && !overridden {
+			throttleToTarget(next, w, r)
+			return
+		}
+		if !overridden {
+			span = throttleSpan{minMs: config.ThrottleMinMs, maxMs: config.ThrottleMaxMs}
+		}
+
 		// Apply artificial delay (throttling)
-		if config.ThrottleMaxMs > 0 {
+		if span.maxMs > 0 {
 			var delay int
-			if config.ThrottleMinMs == config.ThrottleMaxMs {
-				delay = config.ThrottleMinMs
+			if span.minMs == span.maxMs {
+				delay = span.minMs
 			} else {
 				// Random delay between min and max
-				delay = config.ThrottleMinMs + (int(time.Now().UnixNano()) % (config.ThrottleMaxMs - config.ThrottleMinMs + 1))
+				delay = span.minMs + (int(clock.Now().UnixNano()) % (span.maxMs - span.minMs + 1))
+			}
+			scaled := adaptThrottleToDB(time.Duration(float64(delay) * throttleMultiplier(r.Method) * float64(time.Millisecond)))
+			throttleStart := clock.Now()
+			if !clock.Sleep(r.Context(), scaled) {
+				// Client went away during the delay - nothing left to serve
+				return
+			}
+			if t := timingFrom(r.Context()); t != nil {
+				t.throttle = clock.Now().Sub(throttleStart)
 			}
-			time.Sleep(time.Duration(delay) * time.Millisecond)
 		}
 		next(w, r)
 	}
 }
 
+// throttleSpan is a delay range in milliseconds.
+type throttleSpan struct {
+	minMs, maxMs int
+}
+
+// throttleOverrideFor returns the THROTTLE_CLIENT_OVERRIDES range for the
+// client, looked up by API key first and then by IP. An override replaces
+// both the global range and THROTTLE_TARGET_MS for that client.
+func throttleOverrideFor(r *http.Request) (throttleSpan, bool) {
+	if len(config.ThrottleOverrides) == 0 {
+		return throttleSpan{}, false
+	}
+	if span, ok := config.ThrottleOverrides[clientKey(r)]; ok {
+		return span, true
+	}
+	span, ok := config.ThrottleOverrides["ip:"+clientIP(r)]
+	return span, ok
+}
+
+// parseThrottleOverrides parses "ip:10.0.0.5=500-1000,key:batch=200", where
+// a single number is a fixed delay.
+func parseThrottleOverrides(value string) map[string]throttleSpan {
+	result := make(map[string]throttleSpan)
+	for _, entry := range parseList(value) {
+		client, spec, ok := strings.Cut(entry, "=")
+		lo, hi, isRange := strings.Cut(strings.TrimSpace(spec), "-")
+		if !isRange {
+			hi = lo
+		}
+		minMs, errMin := strconv.Atoi(strings.TrimSpace(lo))
+		maxMs, errMax := strconv.Atoi(strings.TrimSpace(hi))
+		client = strings.TrimSpace(client)
+		if !ok || errMin != nil || errMax != nil || minMs < 0 || maxMs < minMs ||
+			!(strings.HasPrefix(client, "ip:") || strings.HasPrefix(client, "key:")) {
+			log.Printf("[CONFIG] Ignoring invalid throttle override %q", entry)
+			continue
+		}
+		result[client] = throttleSpan{minMs: minMs, maxMs: maxMs}
+	}
+	return result
+}
+
+// throttleMultiplier scales the throttle delay per HTTP method
+// (THROTTLE_<METHOD>_MULTIPLIER), e.g. to slow writes more than reads.
+func throttleMultiplier(method string) float64 {
+	if m, ok := config.ThrottleMultipliers[method]; ok {
+		return m
+	}
+	return 1
+}
+
+// adaptThrottleToDB takes whatever recent DB latency exceeds
+// THROTTLE_DB_LATENCY_MS off the delay, so a slow database plus the
+// throttle doesn't add up to more than either alone would.
+func adaptThrottleToDB(delay time.Duration) time.Duration {
+	if config.ThrottleDBLatencyMs <= 0 {
+		return delay
+	}
+	excess := recentDBLatency() - time.Duration(config.ThrottleDBLatencyMs)*time.Millisecond
+	if excess <= 0 {
+		return delay
+	}
+	if excess >= delay {
+		return 0
+	}
+	return delay - excess
+}
+
+// throttleToTarget runs the handler first and holds its response until
+// THROTTLE_TARGET_MS has elapsed, so observed latency is the target rather
+// than target + handler time. A handler slower than the target isn't delayed.
+func throttleToTarget(next http.HandlerFunc, w http.ResponseWriter, r *http.Request) {
+	start := clock.Now()
+	target := time.Duration(float64(config.ThrottleTargetMs) * throttleMultiplier(r.Method) * float64(time.Millisecond))
+
+	// The response is held until the handler returns, so the padding
+	// happens outside the handler and is only accounted as throttle time.
+	// Streamed responses can't be held and go out unpadded.
+	buf := newBufferedResponse(w)
+	next(buf, r)
+	if !buf.streaming {
+		if wait := target - clock.Now().Sub(start); wait > 0 {
+			clock.Sleep(r.Context(), wait)
+			if t := timingFrom(r.Context()); t != nil {
+				t.throttle = wait
+			}
+		}
+	}
+	buf.flush()
+}
+
 func rateLimitMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if !limiter.Allow() {
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusTooManyRequests)
-			json.NewEncoder(w).Encode(map[string]string{
+		if operationalRoutes[r.URL.Path] || bypassesLimits(r) {
+			next(w, r)
+			return
+		}
+
+		now := clock.Now()
+		rateLimitRequests.inc()
+		rateLimitWindow.observe(now)
+		bucket, policy := limiterFor(r)
+		// Ex: "ip;q=10;w=1" - bucket avaliado, requests (q) por janela de w segundos
+		w.Header().Set("X-RateLimit-Policy", rateLimitPolicy(policy))
+
+		reservation := bucket.ReserveN(now, 1)
+		delay := reservation.DelayFrom(now)
+		if delay > 0 && delay <= rateLimitMaxWait() {
+			// Wait mode: hold the request until its token is available
+			if !clock.Sleep(r.Context(), delay) {
+				reservation.CancelAt(clock.Now())
+				return
+			}
+			w.Header().Set("X-RateLimit-Waited-Ms", strconv.FormatInt(delay.Milliseconds(), 10))
+			delay = 0
+		}
+		if t := timingFrom(r.Context()); t != nil {
+			t.rateLimit = clock.Now().Sub(now)
+		}
+		if delay > 0 {
+			reservation.CancelAt(now)
+			rateLimitRejections.inc(methodLabel(r), routeLabel(r))
+			retryAfter := retryAfterSeconds(delay)
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+			body := map[string]interface{}{
 				"error": "Rate limit exceeded. Too many requests.",
-			})
+			}
+			if config.RateLimitVerboseBody {
+				limit, period := effectiveRateLimit(policy)
+				body["policy"] = policy
+				body["limit"] = limit
+				body["period_seconds"] = period
+				body["retry_after_seconds"] = retryAfter
+			}
+			writeJSON(w, r, http.StatusTooManyRequests, body)
 			return
 		}
 		next(w, r)
 	}
 }
 
-func loggingMiddleware(next http.HandlerFunc) http.HandlerFunc {
+// rateLimitMaxWait is how long a request may wait for a token before being
+// rejected; zero outside RATE_LIMIT_MODE=wait.
+func rateLimitMaxWait() time.Duration {
+	if config.RateLimitMode != "wait" {
+		return 0
+	}
+	return time.Duration(config.RateLimitMaxWaitMs) * time.Millisecond
+}
+
+// retryAfterSeconds rounds a limiter delay up to whole seconds for the
+// Retry-After header. A reservation that can never succeed (burst 0) reports
+// the full rate-limit period.
+func retryAfterSeconds(delay time.Duration) int {
+	if delay == rate.InfDuration {
+		return config.RateLimitPeriod
+	}
+	seconds := int((delay + time.Second - 1) / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	return seconds
+}
+
+// loadShedMiddleware rejects writes once in-flight requests exceed
+// SHED_THRESHOLD, and reads only past the higher SHED_READ_THRESHOLD,
+// so read endpoints keep serving while the server is degraded.
+func loadShedMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// OTIMIZAÇÃO: Logs desabilitados para alta performance
-		// Descomentar apenas para debug (impacta TPS significativamente)
+		current := atomic.AddInt64(&inFlight, 1)
+		defer atomic.AddInt64(&inFlight, -1)
 
-		// start := time.Now()
-		// log.Printf("[REQUEST] %s %s from %s", r.Method, r.URL.Path, r.RemoteAddr)
+		if config.ShedThreshold > 0 {
+			threshold := config.ShedThreshold
+			if isReadMethod(r.Method) {
+				threshold = config.ShedReadThreshold
+			}
+			if current > int64(threshold) {
+				writeJSON(w, r, http.StatusServiceUnavailable, map[string]string{
+					"error": "Server is under high load. Please retry later.",
+				})
+				return
+			}
+		}
+		next(w, r)
+	}
+}
 
+// requiredHeadersMiddleware enforces the gateway contract: each header in
+// REQUIRED_HEADERS must be present, otherwise 400 naming the missing one.
+func requiredHeadersMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		for _, header := range config.RequiredHeaders {
+			if r.Header.Get(header) == "" {
+				writeJSON(w, r, http.StatusBadRequest, map[string]string{
+					"error":  "Missing required header: " + header,
+					"header": header,
+				})
+				return
+			}
+		}
 		next(w, r)
+	}
+}
+
+// uriLengthMiddleware wraps the whole mux so over-long URIs are refused
+// with 414 before any routing or handler work.
+func uriLengthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if config.MaxURILength > 0 && len(r.RequestURI) > config.MaxURILength {
+			writeJSON(w, nil, http.StatusRequestURITooLong, map[string]interface{}{
+				"error":      "Request URI too long",
+				"max_length": config.MaxURILength,
+			})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
 
-		// duration := time.Since(start)
-		// log.Printf("[RESPONSE] %s %s completed in %v", r.Method, r.URL.Path, duration)
+// queryParamsMiddleware refuses query strings with more than
+// MAX_QUERY_PARAMS parameters. Pairs are counted on the raw query so an
+// oversized one is never parsed.
+func queryParamsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if config.MaxQueryParams > 0 && countQueryParams(r.URL.RawQuery) > config.MaxQueryParams {
+			writeJSON(w, nil, http.StatusBadRequest, map[string]interface{}{
+				"error":      "Too many query parameters",
+				"max_params": config.MaxQueryParams,
+			})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func countQueryParams(rawQuery string) int {
+	count := 0
+	for _, pair := range strings.Split(rawQuery, "&") {
+		if pair != "" {
+			count++
+		}
 	}
+	return count
 }
 
-func combinedMiddleware(next http.HandlerFunc) http.HandlerFunc {
-	return loggingMiddleware(throttleMiddleware(rateLimitMiddleware(next)))
+// knownRoutes holds every registered path; metric labels are restricted to
+// it so arbitrary request paths can't blow up label cardinality.
+var knownRoutes = map[string]bool{}
+
+func handleRoute(mux *http.ServeMux, pattern string, handler http.HandlerFunc) {
+	knownRoutes[pattern] = true
+	mux.HandleFunc(pattern, deprecated(pattern, allowMethods(pattern, handler)))
+}
+
+// newRouter registers every route on a fresh mux, each wrapped in its
+// middleware stack. The stacks read config as they are built, so config must
+// be loaded first.
+func newRouter() *http.ServeMux {
+	mux := http.NewServeMux()
+	// Catch-all: "/" itself follows ROOT_BEHAVIOR, anything else gets a JSON 404
+	mux.HandleFunc("/", rootHandler)
+	handleRoute(mux, "/health", healthHandler)
+	handleRoute(mux, "/metrics", metricsHandler)
+	handleRoute(mux, "/readyz", readyzHandler)
+	handleRoute(mux, "/api/get", routeMiddleware("/api/get", getHandler))
+	handleRoute(mux, "/api/post", routeMiddleware("/api/post", postHandler))
+	handleRoute(mux, "/api/db/messages", routeMiddleware("/api/db/messages", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			dbGetHandler(w, r)
+		} else if r.Method == http.MethodPost {
+			idempotent(dbPostHandler)(w, r)
+		} else {
+			writeMethodNotAllowed(w, r, "GET, POST")
+		}
+	}))
+	// Uploads can run for minutes; dbImportHandler lifts the server timeouts
+	// and TOTAL_DEADLINE_MS would cut them off all the same
+	handleRoute(mux, "/api/db/messages/import", routeMiddleware("/api/db/messages/import", dbImportHandler, "deadline"))
+	handleRoute(mux, "/api/db/messages/bulk", routeMiddleware("/api/db/messages/bulk", dbBulkHandler))
+	handleRoute(mux, "/api/db/messages/bulk-delete", routeMiddleware("/api/db/messages/bulk-delete", dbBulkDeleteHandler))
+	// Long-lived streams would count as in-flight forever, hold a QoS
+	// or DB slot for their whole life, skew SLOs and be cut off by
+	// TOTAL_DEADLINE_MS
+	handleRoute(mux, "/api/db/messages/stream", routeMiddleware("/api/db/messages/stream", sseHandler, "loadshed", "qos", "slo", "deadline", "dblimit"))
+	handleRoute(mux, "/admin/replay-failed", adminMiddleware(replayFailedHandler))
+	handleRoute(mux, "/admin/maintenance", adminMiddleware(maintenanceHandler))
+	return mux
+}
+
+func routeLabel(r *http.Request) string {
+	if knownRoutes[r.URL.Path] {
+		return r.URL.Path
+	}
+	return "other"
+}
+
+func methodLabel(r *http.Request) string {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+		http.MethodPatch, http.MethodDelete, http.MethodOptions:
+		return r.Method
+	}
+	return "OTHER"
+}
+
+func isReadMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+func loggingMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// OTIMIZAÇÃO: Logs desabilitados por padrão para alta performance.
+		// ACCESS_LOG=true habilita; LOG_SAMPLE_RATE reduz o volume sob carga.
+		if !config.AccessLog {
+			next(w, r)
+			return
+		}
+
+		start := time.Now()
+		rec := newStatusRecorder(w)
+		next(rec, r)
+
+		// Erros e 429 são sempre logados, independente da amostragem
+		if rec.status < 400 && config.LogSampleRate < 1 && rand.Float64() >= config.LogSampleRate {
+			return
+		}
+		log.Printf("[ACCESS] %s %s %d in %v from %s", r.Method, r.URL.Path, rec.status, time.Since(start), r.RemoteAddr)
+	}
 }
 
 func healthHandler(w http.ResponseWriter, r *http.Request) {
 	start := time.Now()
 	log.Printf("[HEALTH] Health check request from %s", r.RemoteAddr)
 
-	w.Header().Set("Content-Type", "application/json")
-
-	// Verificar conexão com o banco
-	dbStatus := "connected"
-	dbError := ""
-	pingStart := time.Now()
-	if err := db.Ping(); err != nil {
-		dbStatus = "disconnected"
-		dbError = err.Error()
-		log.Printf("[HEALTH] Database ping failed in %v: %v", time.Since(pingStart), err)
-	} else {
-		log.Printf("[HEALTH] Database ping successful in %v", time.Since(pingStart))
+	// The diagnostics run a burst of queries and /health isn't rate
+	// limited, so they are for admins only
+	detailed := r.URL.Query().Get("detailed") == "true"
+	if detailed && !requireAdmin(w, r) {
+		return
 	}
 
+	// Verificar conexão com o banco (reutiliza resultado recente, ver HEALTH_CACHE_MS)
+	dbHealth, cached := cachedDBHealth(r.Context())
+	dbStatus := dbHealth.status
+	dbError := dbHealth.err
+
 	response := map[string]interface{}{
 		"status": "ok",
 		"time":   time.Now().Format(time.RFC3339),
 		"database": map[string]interface{}{
-			"status": dbStatus,
-			"host":   config.DBHost,
-			"port":   config.DBPort,
-			"name":   config.DBName,
+			"status":     dbStatus,
+			"host":       config.DBHost,
+			"port":       config.DBPort,
+			"name":       config.DBName,
+			"query":      dbHealth.query,
+			"read_only":  readOnlyMode.Load(),
+			"cached":     cached,
+			"checked_at": dbHealth.checkedAt.Format(time.RFC3339Nano),
 		},
 		"configuration": map[string]interface{}{
 			"rate_limiting": map[string]interface{}{
 				"requests":        config.RateLimitRequests,
 				"period_seconds":  config.RateLimitPeriod,
 				"rate_per_second": float64(config.RateLimitRequests) / float64(config.RateLimitPeriod),
+				"key_header":      config.RateLimitKeyHeader,
 			},
 			"throttling": map[string]interface{}{
-				"min_ms":  config.ThrottleMinMs,
-				"max_ms":  config.ThrottleMaxMs,
-				"enabled": config.ThrottleMaxMs > 0,
+				"min_ms":        config.ThrottleMinMs,
+				"max_ms":        config.ThrottleMaxMs,
+				"target_ms":     config.ThrottleTargetMs,
+				"db_latency_ms": config.ThrottleDBLatencyMs,
+				"enabled":       config.ThrottleMaxMs > 0 || config.ThrottleTargetMs > 0,
+			},
+			"load_shedding": map[string]interface{}{
+				"enabled":         config.ShedThreshold > 0,
+				"write_threshold": config.ShedThreshold,
+				"read_threshold":  config.ShedReadThreshold,
+				"in_flight":       atomic.LoadInt64(&inFlight),
 			},
 		},
 		"server": map[string]interface{}{
-			"port": config.Port,
+			"port":        config.Port,
+			"connections": connStatsSnapshot(),
 		},
 	}
 
+	// Diagnóstico caro (pool, percentis de latência) só sob demanda
+	if detailed {
+		response["diagnostics"] = detailedDiagnostics(r.Context())
+	}
+
+	response["maintenance"] = maintenanceMode.Load()
+	if maintenanceMode.Load() {
+		response["status"] = "maintenance"
+	}
+
 	// Se houver erro no banco, adicionar detalhes
 	if dbError != "" {
 		response["database"].(map[string]interface{})["error"] = dbError
@@ -224,18 +1252,18 @@ func healthHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Status code baseado na saúde
+	status := http.StatusOK
 	if dbStatus == "disconnected" {
-		w.WriteHeader(http.StatusServiceUnavailable)
+		status = http.StatusServiceUnavailable
 		log.Printf("[HEALTH] Returning 503 (degraded) - DB disconnected")
 	}
 
-	json.NewEncoder(w).Encode(response)
+	writeJSON(w, r, status, response)
 	log.Printf("[HEALTH] Health check completed in %v", time.Since(start))
 }
 
 func getHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{
+	writeJSON(w, r, http.StatusOK, map[string]string{
 		"message": "GET request received successfully",
 		"time":    time.Now().Format(time.RFC3339),
 	})
@@ -245,98 +1273,291 @@ func postHandler(w http.ResponseWriter, r *http.Request) {
 	var payload map[string]interface{}
 
 	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]string{
+		if writeBodyLengthMismatch(w, r, err) {
+			return
+		}
+		writeJSON(w, r, http.StatusBadRequest, map[string]string{
 			"error": "Invalid JSON payload",
 		})
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
+	response := map[string]interface{}{
 		"message":  "POST request received successfully",
-		"received": payload,
+		"received": verbatim{payload},
 		"time":     time.Now().Format(time.RFC3339),
-	})
+	}
+
+	// Evitar amplificação: ecoar no máximo ECHO_MAX_BYTES do payload
+	if config.EchoMaxBytes > 0 {
+		if raw, err := json.Marshal(payload); err == nil && len(raw) > config.EchoMaxBytes {
+			// Back off to a rune boundary so the cut never splits a UTF-8 sequence
+			cut := config.EchoMaxBytes
+			for cut > 0 && !utf8.RuneStart(raw[cut]) {
+				cut--
+			}
+			response["received"] = string(raw[:cut])
+			response["truncated"] = true
+		}
+	}
+
+	writeJSON(w, r, http.StatusOK, response)
 }
 
 func dbGetHandler(w http.ResponseWriter, r *http.Request) {
-	rows, err := db.Query("SELECT id, content, created_at FROM messages ORDER BY created_at DESC LIMIT 100")
-	if err != nil {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(map[string]string{
-			"error": "Database query failed",
+	fields, unknown := parseFieldsParam(r)
+	if unknown != "" {
+		writeJSON(w, r, http.StatusBadRequest, map[string]interface{}{
+			"error":   "Unknown field in fields parameter: " + unknown,
+			"allowed": messageFields,
 		})
 		return
 	}
+
+	dbStart := time.Now()
+	messages, err := retryRead("Messages query", func() ([]Message, error) {
+		if readsFromPrimary(r.Context()) {
+			return queryRecentMessages(r.Context())
+		}
+		return cachedRecentMessages(r.Context(), messagesCacheKey(r))
+	})
+	observeDB(r, dbStart)
+	if err != nil && writeDeadlineExceeded(w, r) {
+		return
+	}
+	if err != nil && config.DegradeReadsOnDBDown {
+		log.Printf("[DB] Messages query failed, serving degraded empty result: %v", err)
+		writeJSON(w, r, http.StatusOK, map[string]interface{}{
+			"count":    0,
+			"messages": []Message{},
+			"degraded": true,
+		})
+		return
+	}
+	if err != nil {
+		writeJSON(w, r, http.StatusInternalServerError, internalError(r, "Database query failed", err))
+		return
+	}
+
+	var body interface{} = messages
+	if fields != nil {
+		body = projectMessages(messages, fields)
+	}
+	writeJSON(w, r, http.StatusOK, map[string]interface{}{
+		"count":    len(messages),
+		"messages": body,
+	})
+}
+
+func queryRecentMessages(ctx context.Context) ([]Message, error) {
+	qctx, cancel := dbContext(ctx)
+	defer cancel()
+	rows, err := readConn(ctx).QueryContext(qctx, tagQuery(ctx, `
+		SELECT id, content, content_type, title, author, created_at, expires_at FROM messages
+		WHERE expires_at IS NULL OR expires_at > `+dialect.now()+`
+		ORDER BY created_at DESC LIMIT 100
+	`))
+	if err != nil {
+		return nil, err
+	}
 	defer rows.Close()
 
-	var messages []Message
+	// Non-nil so an empty table encodes as "messages": [] rather than null
+	messages := []Message{}
 	for rows.Next() {
 		var msg Message
-		if err := rows.Scan(&msg.ID, &msg.Content, &msg.CreatedAt); err != nil {
+		var expiresAt sql.NullTime
+		if err := rows.Scan(&msg.ID, &msg.Content, &msg.ContentType, &msg.Title, &msg.Author, &msg.CreatedAt, &expiresAt); err != nil {
 			continue
 		}
+		if expiresAt.Valid {
+			msg.ExpiresAt = &expiresAt.Time
+		}
 		messages = append(messages, msg)
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"count":    len(messages),
-		"messages": messages,
-	})
+	return messages, nil
 }
 
 func dbPostHandler(w http.ResponseWriter, r *http.Request) {
+	if shouldStreamContent(r) {
+		dbStreamPostHandler(w, r)
+		return
+	}
+
 	var msg Message
 
-	if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]string{
-			"error": "Invalid JSON payload. Expected: {\"content\": \"your message\"}",
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		if writeBodyLengthMismatch(w, r, err) {
+			return
+		}
+		writeJSON(w, r, http.StatusBadRequest, map[string]string{
+			"error": "Failed to read request body",
 		})
 		return
 	}
+	// The JSON decoder silently replaces invalid bytes with U+FFFD, so the
+	// check has to happen on the raw body
+	if config.EnforceUTF8 && !utf8.Valid(body) {
+		writeValidationErrors(w, r, []fieldError{{Field: "content", Message: "invalid UTF-8"}})
+		return
+	}
 
-	if msg.Content == "" {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]string{
-			"error": "Content field is required",
+	if err := json.Unmarshal(body, &msg); err != nil {
+		if writeBodyLengthMismatch(w, r, err) {
+			return
+		}
+		writeJSON(w, r, http.StatusBadRequest, map[string]string{
+			"error": "Invalid JSON payload. Expected: {\"content\": \"your message\"}",
 		})
 		return
 	}
 
-	var id int
-	var createdAt time.Time
-	err := db.QueryRow(
-		"INSERT INTO messages (content) VALUES ($1) RETURNING id, created_at",
-		msg.Content,
-	).Scan(&id, &createdAt)
+	if msg.ContentType == "" {
+		msg.ContentType = defaultContentType
+	}
+	sanitizeMessage(&msg)
+	if errs := validateMessage(msg); len(errs) > 0 {
+		writeValidationErrors(w, r, errs)
+		return
+	}
 
-	if err != nil {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(map[string]string{
-			"error": "Failed to insert message",
+	ttl, ok := ttlFromRequest(r)
+	if !ok {
+		writeValidationErrors(w, r, []fieldError{{Field: "ttl_seconds", Message: "must be a positive integer"}})
+		return
+	}
+	if ttl > 0 {
+		expiresAt := clock.Now().Add(ttl).UTC()
+		msg.ExpiresAt = &expiresAt
+	}
+
+	dbStart := time.Now()
+	id, createdAt, err := queueInsert(r.Context(), msg)
+	observeDB(r, dbStart)
+	if errors.Is(err, errWriteQueueFull) {
+		w.Header().Set("Retry-After", retryAfter(len(writeQueue), writeQueueThroughput.perSecond()))
+		writeJSON(w, r, http.StatusServiceUnavailable, map[string]string{
+			"error": "Write queue is full, try again later",
 		})
 		return
 	}
+	if err != nil && writeDeadlineExceeded(w, r) {
+		return
+	}
+	if err != nil && config.ReadOnlyFailover && isReadOnlyError(err) {
+		// failed_writes would refuse the row as well; the client retries later
+		writeReadOnly(w, r)
+		return
+	}
+	if err != nil {
+		body := internalError(r, "Failed to insert message", err)
+		if !isTransientWriteError(err) {
+			// Constraint and data errors would fail the same way on every replay
+			body["queued_for_replay"] = false
+			body["replay_error"] = "The error is permanent, a replay would fail the same way"
+			writeJSON(w, r, http.StatusInternalServerError, body)
+			return
+		}
+		// Retries esgotadas: guardar para replay posterior
+		queued := recordFailedWrite(msg, err) == nil
+		body["queued_for_replay"] = queued
+		if !queued {
+			body["replay_error"] = "The write could not be recorded for replay either: failed_writes is in the same database"
+		}
+		writeJSON(w, r, http.StatusInternalServerError, body)
+		return
+	}
 
 	msg.ID = id
 	msg.CreatedAt = createdAt
+	auditWrite(r, "create", []int64{int64(id)}, 1, msg.Content)
+	messagesCache.invalidate()
+	messageStored(msg, len(msg.Content))
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(map[string]interface{}{
+	writeJSON(w, r, http.StatusCreated, map[string]interface{}{
 		"message": "Message saved successfully",
 		"data":    msg,
 	})
 }
 
+// messageStored runs what every newly stored message triggers, whichever
+// path stored it: the content size histogram, NOTIFY, SSE, the webhook and
+// the shadow mirror. size is passed on its own because streamed content is
+// never in memory. Callers still invalidate the cache and audit, once per
+// request.
+func messageStored(msg Message, size int) {
+	messageContentBytes.observe(float64(size))
+	notifyInsert(msg)
+	messageEvents.publish(msg)
+	fireWebhook(msg)
+	mirrorToShadow(msg)
+}
+
+func rootHandler(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		notFoundHandler(w, r)
+		return
+	}
+
+	switch config.RootBehavior {
+	case "redirect_health":
+		http.Redirect(w, r, "/health", http.StatusFound)
+	case "status_ok":
+		writeJSON(w, r, http.StatusOK, map[string]string{"status": "ok"})
+	default:
+		routes := make([]string, 0, len(knownRoutes))
+		for route := range knownRoutes {
+			routes = append(routes, route)
+		}
+		sort.Strings(routes)
+		writeJSON(w, r, http.StatusOK, map[string]interface{}{
+			"service": "api-throttling",
+			"routes":  routes,
+		})
+	}
+}
+
+// tcpListenConfig applies TCP_KEEPALIVE / TCP_KEEPALIVE_SEC to every
+// accepted connection (SetKeepAlive + SetKeepAlivePeriod under the hood).
+func tcpListenConfig() *net.ListenConfig {
+	lc := &net.ListenConfig{}
+	switch {
+	case !config.TCPKeepAlive:
+		lc.KeepAlive = -1 // disabled
+		log.Printf("[CONFIG] TCP keep-alive disabled")
+	case config.TCPKeepAliveSec > 0:
+		lc.KeepAlive = time.Duration(config.TCPKeepAliveSec) * time.Second
+		log.Printf("[CONFIG] TCP keep-alive period: %ds", config.TCPKeepAliveSec)
+	}
+	// KeepAlive 0 keeps Go's default of 15s
+	return lc
+}
+
+func notFoundHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, r, http.StatusNotFound, map[string]string{
+		"error": "Not found",
+		"path":  r.URL.Path,
+	})
+}
+
+// withH2C serves HTTP/2 over cleartext alongside HTTP/1.1 on the same port.
+func withH2C(handler http.Handler, idleTimeout time.Duration) http.Handler {
+	return h2c.NewHandler(handler, &http2.Server{IdleTimeout: idleTimeout})
+}
+
+// newGlobalLimiter builds the global bucket from RATE_LIMIT_REQUESTS and
+// RATE_LIMIT_PERIOD, drained up front with RATE_LIMIT_START_EMPTY.
+func newGlobalLimiter() *rate.Limiter {
+	ratePerSecond := float64(config.RateLimitRequests) / float64(config.RateLimitPeriod)
+	l := rate.NewLimiter(rate.Limit(ratePerSecond), config.RateLimitRequests)
+	if config.RateLimitStartEmpty {
+		// Consumir o burst inicial: tokens só ficam disponíveis conforme acumulam
+		l.AllowN(clock.Now(), config.RateLimitRequests)
+	}
+	return l
+}
+
 func main() {
 	log.Println("==========================================")
 	log.Println("  API Throttling Server Starting...")
@@ -357,11 +1578,25 @@ func main() {
 	// Initialize rate limiter
 	// Rate: requests per second = RateLimitRequests / RateLimitPeriod
 	ratePerSecond := float64(config.RateLimitRequests) / float64(config.RateLimitPeriod)
-	limiter = rate.NewLimiter(rate.Limit(ratePerSecond), config.RateLimitRequests)
+	limiter = newGlobalLimiter()
+	if config.RateLimitStartEmpty {
+		log.Printf("[CONFIG] Rate limiter starts empty")
+	}
+	if config.SlowStartSec > 0 {
+		log.Printf("[CONFIG] Rate limiter slow start: %.0f%% to 100%% over %ds", config.SlowStartFraction*100, config.SlowStartSec)
+		startSlowStart(limiter, time.Duration(config.SlowStartSec)*time.Second, config.SlowStartFraction)
+	}
 
 	log.Printf("[CONFIG] Rate limiter: %d requests per %d second(s) (%.2f req/s)",
 		config.RateLimitRequests, config.RateLimitPeriod, ratePerSecond)
 
+	if perClientRateLimiting() {
+		perClientRate, perClientBurst := clientBucketSettings()
+		log.Printf("[CONFIG] Per-client rate limiting: %.2f req/s, burst %d (key header: %q, fallback: IP)",
+			perClientRate, perClientBurst, config.RateLimitKeyHeader)
+		startClientLimiterCleanup()
+	}
+
 	if config.ThrottleMaxMs > 0 {
 		log.Printf("[CONFIG] Throttling enabled: %d-%d ms delay per request",
 			config.ThrottleMinMs, config.ThrottleMaxMs)
@@ -369,6 +1604,45 @@ func main() {
 		log.Printf("[CONFIG] Throttling disabled (THROTTLE_MAX_MS = 0)")
 	}
 
+	if err := compileDenyPatterns(config.ContentDenyPatterns); err != nil {
+		log.Fatalf("[FATAL] %v", err)
+	}
+	if len(contentDenyPatterns) > 0 {
+		log.Printf("[CONFIG] Content denylist: %d pattern(s)", len(contentDenyPatterns))
+		if denyPatternOverlap < 0 && config.StreamContentThreshold > 0 {
+			log.Printf("[CONFIG] WARNING: CONTENT_DENY_PATTERNS has an unbounded pattern (*, +, {n,}), streamed uploads will be rejected")
+		}
+	}
+
+	if config.AccessLog {
+		log.Printf("[CONFIG] Access log enabled (sample rate %.4f)", config.LogSampleRate)
+	}
+
+	if config.ShedThreshold > 0 {
+		log.Printf("[CONFIG] Load shedding enabled: writes above %d, reads above %d in-flight requests",
+			config.ShedThreshold, config.ShedReadThreshold)
+	}
+
+	maintenanceMode.Store(config.MaintenanceMode)
+	if config.MaintenanceMode {
+		log.Printf("[CONFIG] Starting in maintenance mode: /api/* returns 503")
+	}
+
+	if config.AdminToken == "" {
+		log.Printf("[CONFIG] ADMIN_TOKEN not set, /admin/* endpoints are disabled (403)")
+	}
+	if config.LoadTestToken != "" {
+		log.Printf("[CONFIG] WARNING: LOAD_TEST_TOKEN set, requests with %s skip throttle and rate limit", loadTestHeader)
+	}
+
+	startPprofServer()
+
+	disablePostgresOnlyFeatures(&config)
+
+	if config.RequireSignature && config.SignatureSecret == "" {
+		log.Fatalf("[FATAL] REQUIRE_SIGNATURE is set but SIGNATURE_SECRET is empty")
+	}
+
 	// Initialize database
 	log.Println("[INIT] Initializing database connection...")
 	if err := initDB(config); err != nil {
@@ -377,30 +1651,103 @@ func main() {
 	defer db.Close()
 	log.Println("[INIT] Database connected successfully!")
 
+	if config.AutoMaintenance && config.MaintenanceIntervalSec > 0 {
+		log.Printf("[CONFIG] Auto maintenance enabled: VACUUM ANALYZE every %ds", config.MaintenanceIntervalSec)
+		startAutoVacuum(time.Duration(config.MaintenanceIntervalSec) * time.Second)
+	}
+
+	initEndpointSemaphores(config.DBEndpointLimits)
+
+	if config.MaxConcurrentHealthChecks > 0 {
+		healthCheckSem = semaphore.NewWeighted(int64(config.MaxConcurrentHealthChecks))
+	}
+
+	if config.MaxConcurrentRequests > 0 {
+		log.Printf("[CONFIG] QoS concurrency limit: %d (queue %d)", config.MaxConcurrentRequests, config.QoSMaxQueue)
+		qosLimiter = newPriorityLimiter(config.MaxConcurrentRequests, config.QoSMaxQueue)
+	}
+
+	if config.WebhookURL != "" {
+		log.Printf("[CONFIG] Webhook enabled: %d retries, %d ms timeout", config.WebhookRetries, config.WebhookTimeoutMs)
+		startWebhook()
+	}
+
+	if config.DBShadowURL != "" {
+		if err := initShadowDB(config.DBShadowURL); err != nil {
+			log.Printf("[SHADOW] Shadow database unavailable, mirroring disabled: %v", err)
+		} else {
+			log.Printf("[CONFIG] Shadow writes enabled")
+		}
+	}
+
+	if config.DBReplicaURL != "" {
+		if err := initReplicaDB(config.DBReplicaURL); err != nil {
+			log.Printf("[REPLICA] Read replica unavailable, reading from the primary: %v", err)
+		} else {
+			log.Printf("[CONFIG] Read replica enabled, read-your-writes window %d ms", config.ReadYourWritesMs)
+		}
+	}
+
+	if config.ExpiryPurgeIntervalSec > 0 {
+		startExpiryPurge(time.Duration(config.ExpiryPurgeIntervalSec) * time.Second)
+	}
+
+	if config.IdempotencyTTLSec > 0 {
+		startIdempotencyCleanup()
+	}
+
+	if config.QuotaLimit > 0 || len(config.QuotaLimits) > 0 {
+		startQuotaPrune()
+	}
+
+	if config.EnableAuditLog {
+		if err := initAuditLog(config.AuditLogFile); err != nil {
+			log.Fatalf("[FATAL] Failed to open audit log: %v", err)
+		}
+		dest := config.AuditLogFile
+		if dest == "" {
+			dest = "stdout"
+		}
+		log.Printf("[CONFIG] Audit log enabled (%s)", dest)
+	}
+
+	if config.StatsLogIntervalSec > 0 {
+		startStatsLogger(time.Duration(config.StatsLogIntervalSec) * time.Second)
+	}
+
+	if config.DBWriteQueueDepth > 0 {
+		log.Printf("[CONFIG] DB write queue enabled: depth %d, %d workers", config.DBWriteQueueDepth, config.DBWriteWorkers)
+		startWriteQueue(config.DBWriteQueueDepth, config.DBWriteWorkers)
+	}
+
+	if config.MessagesCacheMs > 0 {
+		log.Printf("[CONFIG] Messages cache enabled: TTL %d ms", config.MessagesCacheMs)
+		// Warms the entry for the plain list (no query string)
+		if _, err := cachedRecentMessages(context.Background(), ""); err != nil {
+			log.Printf("[CACHE] Warmup failed: %v", err)
+		}
+	}
+
 	// Routes
-	http.HandleFunc("/health", healthHandler)
-	http.HandleFunc("/api/get", combinedMiddleware(getHandler))
-	http.HandleFunc("/api/post", combinedMiddleware(postHandler))
-	http.HandleFunc("/api/db/messages", func(w http.ResponseWriter, r *http.Request) {
-		combinedMiddleware(func(w http.ResponseWriter, r *http.Request) {
-			if r.Method == http.MethodGet {
-				dbGetHandler(w, r)
-			} else if r.Method == http.MethodPost {
-				dbPostHandler(w, r)
-			} else {
-				w.WriteHeader(http.StatusMethodNotAllowed)
-			}
-		})(w, r)
-	})
+	mux := newRouter()
 
 	log.Println("==========================================")
 	log.Printf("[SERVER] Starting on port %s", config.Port)
 	log.Println("[SERVER] Endpoints:")
 	log.Println("  - GET  /health")
+	log.Println("  - GET  /metrics")
+	log.Println("  - GET  /readyz")
 	log.Println("  - GET  /api/get")
 	log.Println("  - POST /api/post")
 	log.Println("  - GET  /api/db/messages")
 	log.Println("  - POST /api/db/messages")
+	log.Println("  - POST /api/db/messages/import")
+	log.Println("  - POST /api/db/messages/bulk")
+	log.Println("  - POST /api/db/messages/bulk-delete")
+	log.Println("  - GET  /api/db/messages/stream")
+	log.Println("  - POST /admin/replay-failed")
+	log.Println("  - GET  /admin/maintenance")
+	log.Println("  - POST /admin/maintenance")
 	log.Println("==========================================")
 	log.Printf("[SERVER] 🚀 High Performance Server ready at http://0.0.0.0:%s", config.Port)
 	log.Printf("[SERVER] 📊 Target: 10k+ TPS | %d CPUs | Pool: 200 connections", numCPU)
@@ -410,12 +1757,64 @@ func main() {
 	server := &http.Server{
 		Addr:           ":" + config.Port,
 		ReadTimeout:    10 * time.Second,
-		WriteTimeout:   10 * time.Second,
+		WriteTimeout:   time.Duration(config.WriteTimeoutSec) * time.Second,
 		IdleTimeout:    120 * time.Second,
 		MaxHeaderBytes: 1 << 20, // 1 MB
+		ConnState:      trackConnState,
+		ConnContext:    connContext,
 	}
 
-	if err := server.ListenAndServe(); err != nil {
+	tlsMinVersion, err := parseTLSVersion(config.TLSMinVersion)
+	if err != nil {
+		log.Fatalf("[FATAL] Invalid TLS_MIN_VERSION: %v", err)
+	}
+	useTLS := config.TLSCertFile != "" && config.TLSKeyFile != ""
+	if useTLS {
+		server.TLSConfig = serverTLSConfig(tlsMinVersion)
+		log.Printf("[CONFIG] TLS enabled (minimum version %s)", config.TLSMinVersion)
+	}
+
+	// h2c: HTTP/2 sem TLS. Cada stream passa pelo mux normalmente, então
+	// rate limiting e throttling continuam valendo por requisição.
+	var handler http.Handler = requestIDMiddleware(recoveryMiddleware(sizeMetricsMiddleware(slowClientMiddleware(contentLengthMiddleware(responseLimitMiddleware(uriLengthMiddleware(queryParamsMiddleware(mux))))))))
+	if config.EnableGzip {
+		handler = gzipMiddleware(handler)
+		log.Printf("[CONFIG] Gzip enabled: min %d bytes, level %d", config.GzipMinBytes, config.GzipLevel)
+	}
+	handler = connReuseMiddleware(handler)
+	if config.EnableHTTP2 {
+		handler = withH2C(handler, server.IdleTimeout)
+		log.Printf("[CONFIG] HTTP/2 enabled (h2c)")
+	}
+	server.Handler = handler
+
+	listener, err := tcpListenConfig().Listen(context.Background(), "tcp", server.Addr)
+	if err != nil {
 		log.Fatalf("[FATAL] Server failed to start: %v", err)
 	}
+	if config.MaxConnsPerIP > 0 {
+		listener = newPerIPConnListener(listener, config.MaxConnsPerIP)
+		log.Printf("[CONFIG] Max connections per IP: %d", config.MaxConnsPerIP)
+	}
+	if config.ConnAcceptRate > 0 {
+		listener = newAcceptRateListener(listener, config.ConnAcceptRate, config.ConnAcceptBurst,
+			time.Duration(config.ConnAcceptMaxWaitMs)*time.Millisecond)
+		log.Printf("[CONFIG] Connection accept rate: %.2f/s (burst %d, max wait %d ms)",
+			config.ConnAcceptRate, config.ConnAcceptBurst, config.ConnAcceptMaxWaitMs)
+	}
+
+	go func() {
+		var err error
+		if useTLS {
+			err = server.ServeTLS(listener, config.TLSCertFile, config.TLSKeyFile)
+		} else {
+			err = server.Serve(listener)
+		}
+		if err != nil && err != http.ErrServerClosed {
+			log.Fatalf("[FATAL] Server failed to start: %v", err)
+		}
+	}()
+	ready.Store(true)
+
+	waitForShutdown(server)
 }
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/time/rate"
+)
+
+func TestMain(m *testing.M) {
+	// Handlers log every request; keep test output readable
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// withConfig starts the test from the defaults loadConfig produces, applies
+// edit, and restores the previous config when the test ends.
+func withConfig(t *testing.T, edit func(*Config)) {
+	t.Helper()
+	prev := config
+	config = loadConfig()
+	if edit != nil {
+		edit(&config)
+	}
+	t.Cleanup(func() { config = prev })
+}
+
+// captureLog sends the standard logger to a buffer for the test.
+func captureLog(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(io.Discard) })
+	return &buf
+}
+
+// useRouter serves the full route table on a test server. The global rate
+// limit is lifted so only the middleware under test can refuse requests.
+func useRouter(t *testing.T) *httptest.Server {
+	t.Helper()
+	useLimiter(t, rate.NewLimiter(rate.Inf, 0))
+	srv := httptest.NewServer(newRouter())
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// openStream subscribes to the SSE route of srv and returns once the
+// response headers are in. Closing the body ends the stream.
+func openStream(t *testing.T, srv *httptest.Server) *http.Response {
+	t.Helper()
+	resp, err := http.Get(srv.URL + "/api/db/messages/stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("stream status = %d, want 200", resp.StatusCode)
+	}
+	return resp
+}
+
+// useTestDB points db at a fresh, migrated SQLite file for the test. Call
+// it after withConfig, since it switches config to the sqlite driver.
+func useTestDB(t *testing.T) {
+	t.Helper()
+	prevDB, prevDialect := db, dialect
+	config.DBDriver = "sqlite"
+	config.DBSQLitePath = filepath.Join(t.TempDir(), "test.db")
+	if err := initDB(config); err != nil {
+		t.Fatalf("initDB: %v", err)
+	}
+	messagesCache.invalidate()
+	t.Cleanup(func() {
+		db.Close()
+		db, dialect = prevDB, prevDialect
+		messagesCache.invalidate()
+	})
+}
+
+// countMessages returns how many rows the messages table holds.
+func countMessages(t *testing.T) int {
+	t.Helper()
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM messages").Scan(&n); err != nil {
+		t.Fatalf("count messages: %v", err)
+	}
+	return n
+}
+
+// messageContent reads back the stored content of message id.
+func messageContent(t *testing.T, id int64) string {
+	t.Helper()
+	var content string
+	err := db.QueryRow("SELECT content FROM messages WHERE id = $1", id).Scan(&content)
+	if err == sql.ErrNoRows {
+		t.Fatalf("message %d not found", id)
+	} else if err != nil {
+		t.Fatalf("read message %d: %v", id, err)
+	}
+	return content
+}
+
+// postMessage sends body to dbPostHandler as a JSON POST to target.
+func postMessage(target, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	dbPostHandler(w, r)
+	return w
+}
+
+// getMessages sends a GET for target to dbGetHandler.
+func getMessages(target string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	dbGetHandler(w, httptest.NewRequest(http.MethodGet, target, nil))
+	return w
+}
+
+// captureAudit sends audit entries to a buffer for the test.
+func captureAudit(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	prev := auditLogger
+	auditLogger = log.New(&buf, "", 0)
+	t.Cleanup(func() { auditLogger = prev })
+	return &buf
+}
+
+// subscribeEvents receives what messageStored publishes during the test.
+func subscribeEvents(t *testing.T) chan Message {
+	t.Helper()
+	ch, cancel := messageEvents.subscribe()
+	t.Cleanup(cancel)
+	return ch
+}
+
+// drainEvents collects the events already published, without waiting.
+func drainEvents(ch chan Message) []Message {
+	var got []Message
+	for {
+		select {
+		case msg := <-ch:
+			got = append(got, msg)
+		default:
+			return got
+		}
+	}
+}
+
+func TestValidateDBParams(t *testing.T) {
+	valid := Config{DBHost: "localhost", DBPort: "5432", DBUser: "postgres", DBName: "app"}
+	tests := []struct {
+		name    string
+		edit    func(*Config)
+		wantErr string
+	}{
+		{"valid", func(c *Config) {}, ""},
+		{"empty host", func(c *Config) { c.DBHost = "" }, "DB_HOST"},
+		{"blank host", func(c *Config) { c.DBHost = "   " }, "DB_HOST"},
+		{"non-numeric port", func(c *Config) { c.DBPort = "abc" }, "DB_PORT"},
+		{"port zero", func(c *Config) { c.DBPort = "0" }, "DB_PORT"},
+		{"port too large", func(c *Config) { c.DBPort = "65536" }, "DB_PORT"},
+		{"empty port", func(c *Config) { c.DBPort = "" }, "DB_PORT"},
+		{"empty user", func(c *Config) { c.DBUser = "" }, "DB_USER"},
+		{"empty name", func(c *Config) { c.DBName = "" }, "DB_NAME"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := valid
+			tt.edit(&c)
+			err := validateDBParams(c)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("err = %v, want mention of %s", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestConnValueQuotes(t *testing.T) {
+	if got := connValue(`p'a ss\`); got != `'p\'a ss\\'` {
+		t.Fatalf("connValue = %s", got)
+	}
+}
+
+func TestURILengthLimit(t *testing.T) {
+	withConfig(t, func(c *Config) { c.MaxURILength = 32 })
+	handler := uriLengthMiddleware(http.HandlerFunc(okHandler))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/get?q=short", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("short URI: status = %d, want 200", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/get?q="+strings.Repeat("x", 40), nil))
+	if w.Code != http.StatusRequestURITooLong {
+		t.Fatalf("long URI: status = %d, want 414", w.Code)
+	}
+}
+
+func TestQueryParamsLimit(t *testing.T) {
+	withConfig(t, func(c *Config) { c.MaxQueryParams = 2 })
+	handler := queryParamsMiddleware(http.HandlerFunc(okHandler))
+
+	for query, want := range map[string]int{
+		"":          http.StatusOK,
+		"a=1&b=2":   http.StatusOK,
+		"a=1&&b=2&": http.StatusOK, // empty pairs don't count
+		"a=1&b=2&c": http.StatusBadRequest,
+	} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/get?"+query, nil))
+		if w.Code != want {
+			t.Errorf("%q: status = %d, want %d", query, w.Code, want)
+		}
+	}
+}
+
+func TestUnknownPathIsJSON404(t *testing.T) {
+	withConfig(t, nil)
+	w := httptest.NewRecorder()
+	rootHandler(w, httptest.NewRequest(http.MethodGet, "/nope", nil))
+	if w.Code != http.StatusNotFound || !strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+		t.Fatalf("got %d %q, want a JSON 404", w.Code, w.Header().Get("Content-Type"))
+	}
+	if !strings.Contains(w.Body.String(), `"path":"/nope"`) {
+		t.Fatalf("body %s lacks the path", w.Body.String())
+	}
+}
+
+func TestRootBehavior(t *testing.T) {
+	tests := []struct {
+		behavior string
+		want     int
+		contains string
+	}{
+		{"catalog", http.StatusOK, `"routes"`},
+		{"status_ok", http.StatusOK, `"status":"ok"`},
+		{"redirect_health", http.StatusFound, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.behavior, func(t *testing.T) {
+			withConfig(t, func(c *Config) { c.RootBehavior = tt.behavior })
+			w := httptest.NewRecorder()
+			rootHandler(w, httptest.NewRequest(http.MethodGet, "/", nil))
+			if w.Code != tt.want || !strings.Contains(w.Body.String(), tt.contains) {
+				t.Fatalf("got %d %s, want %d containing %s", w.Code, w.Body.String(), tt.want, tt.contains)
+			}
+			if tt.want == http.StatusFound && w.Header().Get("Location") != "/health" {
+				t.Fatalf("Location = %q, want /health", w.Header().Get("Location"))
+			}
+		})
+	}
+}
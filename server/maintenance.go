@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+)
+
+// Maintenance mode: every /api/* route answers 503 with Retry-After while
+// /health keeps working and reports the state. Starts from
+// MAINTENANCE_MODE and can be toggled at runtime via /admin/maintenance.
+
+var maintenanceMode atomic.Bool
+
+func maintenanceMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if maintenanceMode.Load() {
+			w.Header().Set("Retry-After", strconv.Itoa(config.MaintenanceRetryAfterSec))
+			writeJSON(w, r, http.StatusServiceUnavailable, map[string]interface{}{
+				"error":       "The API is under maintenance. Please try again later.",
+				"maintenance": true,
+			})
+			return
+		}
+		next(w, r)
+	}
+}
+
+// maintenanceHandler reports the mode on GET and sets it on POST with
+// {"enabled": true|false}.
+func maintenanceHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var body struct {
+			Enabled *bool `json:"enabled"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Enabled == nil {
+			if writeBodyLengthMismatch(w, r, err) {
+				return
+			}
+			writeJSON(w, r, http.StatusBadRequest, map[string]string{
+				"error": "Invalid JSON payload. Expected: {\"enabled\": true}",
+			})
+			return
+		}
+		if maintenanceMode.Swap(*body.Enabled) != *body.Enabled {
+			log.Printf("[MAINTENANCE] Maintenance mode set to %v from %s", *body.Enabled, r.RemoteAddr)
+		}
+	default:
+		writeMethodNotAllowed(w, r, "GET, POST")
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, map[string]interface{}{
+		"maintenance": maintenanceMode.Load(),
+	})
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// useMaintenanceState resets maintenance mode after the test.
+func useMaintenanceState(t *testing.T) {
+	t.Helper()
+	prev := maintenanceMode.Load()
+	t.Cleanup(func() { maintenanceMode.Store(prev) })
+}
+
+func TestMaintenanceToggle(t *testing.T) {
+	withConfig(t, func(c *Config) { c.MaintenanceRetryAfterSec = 30 })
+	useMaintenanceState(t)
+	maintenanceMode.Store(false)
+
+	api := maintenanceMiddleware(okHandler)
+	w := httptest.NewRecorder()
+	api(w, httptest.NewRequest(http.MethodGet, "/api/get", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("outside maintenance: status = %d, want 200", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	maintenanceHandler(w, httptest.NewRequest(http.MethodPost, "/admin/maintenance", strings.NewReader(`{"enabled":true}`)))
+	if w.Code != http.StatusOK || decodeBody(t, w)["maintenance"] != true {
+		t.Fatalf("enable: %d %s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	api(w, httptest.NewRequest(http.MethodGet, "/api/get", nil))
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "30" {
+		t.Fatalf("in maintenance: status %d, Retry-After %q; want 503 and 30", w.Code, w.Header().Get("Retry-After"))
+	}
+
+	w = httptest.NewRecorder()
+	maintenanceHandler(w, httptest.NewRequest(http.MethodPost, "/admin/maintenance", strings.NewReader(`{"enabled":false}`)))
+	if decodeBody(t, w)["maintenance"] != false || maintenanceMode.Load() {
+		t.Fatal("maintenance mode not switched off")
+	}
+}
+
+func TestMaintenanceHandlerRejectsBadPayload(t *testing.T) {
+	withConfig(t, nil)
+	useMaintenanceState(t)
+
+	for _, body := range []string{`{}`, `{"enabled":"yes"}`, `not json`} {
+		w := httptest.NewRecorder()
+		maintenanceHandler(w, httptest.NewRequest(http.MethodPost, "/admin/maintenance", strings.NewReader(body)))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", body, w.Code)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestDegradeReadsOnDBDown(t *testing.T) {
+	tests := []struct {
+		degrade  bool
+		wantCode int
+	}{
+		{false, http.StatusInternalServerError},
+		{true, http.StatusOK},
+	}
+	for _, tt := range tests {
+		withConfig(t, func(c *Config) { c.DegradeReadsOnDBDown = tt.degrade })
+		useFakeDB(t, errors.New("relation \"messages\" does not exist"))
+
+		w := getMessages("/api/db/messages")
+		if w.Code != tt.wantCode {
+			t.Fatalf("DEGRADE_READS_ON_DB_DOWN=%v: status = %d, want %d (%s)", tt.degrade, w.Code, tt.wantCode, w.Body.String())
+		}
+		body := decodeBody(t, w)
+		if !tt.degrade {
+			if body["error_id"] == nil {
+				t.Fatalf("500 body lacks error_id: %v", body)
+			}
+			continue
+		}
+		messages, ok := body["messages"].([]interface{})
+		if !ok || len(messages) != 0 || body["count"] != float64(0) || body["degraded"] != true {
+			t.Fatalf("degraded body = %v, want an empty list marked degraded", body)
+		}
+	}
+}
+
+func TestContentTypeStoredAndListed(t *testing.T) {
+	withConfig(t, func(c *Config) {
+		c.AllowedContentTypes = map[string]bool{"text/plain": true, "text/markdown": true}
+	})
+	useTestDB(t)
+
+	if w := postMessage("/api/db/messages", `{"content":"# Title","content_type":"text/markdown"}`); w.Code != http.StatusCreated {
+		t.Fatalf("markdown POST = %d (%s)", w.Code, w.Body.String())
+	}
+	if w := postMessage("/api/db/messages", `{"content":"plain"}`); w.Code != http.StatusCreated {
+		t.Fatalf("POST without content_type = %d (%s)", w.Code, w.Body.String())
+	}
+
+	w := postMessage("/api/db/messages", `{"content":"<p>hi</p>","content_type":"text/html"}`)
+	if w.Code != http.StatusUnprocessableEntity || !strings.Contains(w.Body.String(), `"field":"content_type"`) {
+		t.Fatalf("text/html POST = %d %s, want 422 on content_type", w.Code, w.Body.String())
+	}
+
+	var resp struct {
+		Messages []Message `json:"messages"`
+	}
+	if err := json.Unmarshal(getMessages("/api/db/messages").Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]string{}
+	for _, m := range resp.Messages {
+		got[m.Content] = m.ContentType
+	}
+	want := map[string]string{"# Title": "text/markdown", "plain": defaultContentType}
+	if len(got) != len(want) || got["# Title"] != want["# Title"] || got["plain"] != want["plain"] {
+		t.Fatalf("listed content types = %v, want %v", got, want)
+	}
+}
+
+func TestEmptyTableListsAsArray(t *testing.T) {
+	withConfig(t, nil)
+	useTestDB(t)
+
+	w := getMessages("/api/db/messages")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), `"messages":[]`) {
+		t.Fatalf("body = %s, want \"messages\":[]", w.Body.String())
+	}
+
+	// Same with a fields projection
+	w = getMessages("/api/db/messages?fields=id")
+	if !strings.Contains(w.Body.String(), `"messages":[]`) {
+		t.Fatalf("projected body = %s, want \"messages\":[]", w.Body.String())
+	}
+}
+
+func TestMessageContentBytesObserved(t *testing.T) {
+	withConfig(t, nil)
+	useTestDB(t)
+	count, sum := histogramStats(messageContentBytes)
+
+	// Bytes, not runes: "é" is two
+	content := strings.Repeat("é", 150)
+	if w := postMessage("/api/db/messages", `{"content":"`+content+`"}`); w.Code != http.StatusCreated {
+		t.Fatalf("POST = %d (%s)", w.Code, w.Body.String())
+	}
+	if w := postBulk(dbBulkHandler, `[{"content":"abc"},{"content":"defgh"}]`); w.Code != http.StatusCreated {
+		t.Fatalf("bulk POST = %d (%s)", w.Code, w.Body.String())
+	}
+
+	gotCount, gotSum := histogramStats(messageContentBytes)
+	if gotCount-count != 3 || gotSum-sum != 308 {
+		t.Fatalf("message_content_bytes grew by %d observations summing %g, want 3 summing 308", gotCount-count, gotSum-sum)
+	}
+}
+
+func TestTitleAndAuthorStoredAndListed(t *testing.T) {
+	withConfig(t, nil)
+	useTestDB(t)
+
+	w := postMessage("/api/db/messages", `{"content":"body","title":"Release notes","author":"ana"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("POST = %d (%s)", w.Code, w.Body.String())
+	}
+	data := decodeBody(t, w)["data"].(map[string]interface{})
+	if data["title"] != "Release notes" || data["author"] != "ana" {
+		t.Fatalf("created = %v, want the title and author echoed", data)
+	}
+
+	var title, author string
+	if err := db.QueryRow("SELECT title, author FROM messages WHERE id = $1", int64(data["id"].(float64))).Scan(&title, &author); err != nil {
+		t.Fatal(err)
+	}
+	if title != "Release notes" || author != "ana" {
+		t.Fatalf("stored title %q, author %q", title, author)
+	}
+
+	var resp struct {
+		Messages []Message `json:"messages"`
+	}
+	if err := json.Unmarshal(getMessages("/api/db/messages").Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Messages) != 1 || resp.Messages[0].Title != "Release notes" || resp.Messages[0].Author != "ana" {
+		t.Fatalf("listed = %+v, want the title and author", resp.Messages)
+	}
+
+	// Neither is required by default
+	if w := postMessage("/api/db/messages", `{"content":"untitled"}`); w.Code != http.StatusCreated {
+		t.Fatalf("POST without title/author = %d (%s)", w.Code, w.Body.String())
+	}
+}
+
+func TestRequiredMessageFields(t *testing.T) {
+	withConfig(t, func(c *Config) {
+		c.RequiredMessageFields = map[string]bool{"content": true, "title": true, "author": true}
+	})
+	useTestDB(t)
+
+	tests := []struct {
+		body string
+		want []string // fields reported as required
+	}{
+		{`{"content":"x","author":"ana"}`, []string{"title"}},
+		{`{"content":"x","title":"  ","author":"ana"}`, []string{"title"}},
+		{`{"content":"x"}`, []string{"title", "author"}},
+	}
+	for _, tt := range tests {
+		w := postMessage("/api/db/messages", tt.body)
+		if w.Code != http.StatusUnprocessableEntity {
+			t.Errorf("%s: status = %d, want 422", tt.body, w.Code)
+			continue
+		}
+		for _, field := range tt.want {
+			if !strings.Contains(w.Body.String(), `"field":"`+field+`","message":"required"`) {
+				t.Errorf("%s: body %s lacks %s required", tt.body, w.Body.String(), field)
+			}
+		}
+	}
+	if n := countMessages(t); n != 0 {
+		t.Fatalf("%d messages stored despite missing fields", n)
+	}
+
+	if w := postMessage("/api/db/messages", `{"content":"x","title":"t","author":"ana"}`); w.Code != http.StatusCreated {
+		t.Fatalf("POST with every field = %d (%s)", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strings"
+)
+
+// defaultRouteMethods is the method policy for every registered route;
+// ROUTE_METHODS replaces individual entries. Routes missing from the map
+// accept any method.
+var defaultRouteMethods = map[string][]string{
+	"/health":                      {http.MethodGet, http.MethodHead},
+	"/metrics":                     {http.MethodGet},
+	"/readyz":                      {http.MethodGet, http.MethodHead},
+	"/api/get":                     {http.MethodGet},
+	"/api/post":                    {http.MethodPost},
+	"/api/db/messages":             {http.MethodGet, http.MethodPost},
+	"/api/db/messages/import":      {http.MethodPost},
+	"/api/db/messages/bulk":        {http.MethodPost},
+	"/api/db/messages/bulk-delete": {http.MethodPost},
+	"/api/db/messages/stream":      {http.MethodGet},
+	"/admin/replay-failed":         {http.MethodPost},
+	"/admin/maintenance":           {http.MethodGet, http.MethodPost},
+}
+
+// allowMethods answers 405 with an Allow header for methods outside the
+// route's allow-list, before any other middleware runs.
+func allowMethods(pattern string, next http.HandlerFunc) http.HandlerFunc {
+	methods, ok := config.RouteMethods[pattern]
+	if !ok {
+		return next
+	}
+	allowed := make(map[string]bool, len(methods))
+	for _, m := range methods {
+		allowed[m] = true
+	}
+	allow := strings.Join(methods, ", ")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !allowed[r.Method] {
+			writeMethodNotAllowed(w, r, allow)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// writeMethodNotAllowed is the 405 for every route. Handlers that dispatch
+// on the method call it too, for methods ROUTE_METHODS lets through but
+// they don't implement.
+func writeMethodNotAllowed(w http.ResponseWriter, r *http.Request, allow string) {
+	w.Header().Set("Allow", allow)
+	writeJSON(w, r, http.StatusMethodNotAllowed, map[string]string{
+		"error": "Method not allowed, use " + allow,
+	})
+}
+
+// parseRouteMethods overlays "/api/get=GET+HEAD,/api/post=POST" on the
+// default allow-lists.
+func parseRouteMethods(value string) map[string][]string {
+	result := make(map[string][]string, len(defaultRouteMethods))
+	for route, methods := range defaultRouteMethods {
+		result[route] = methods
+	}
+
+	for _, entry := range parseList(value) {
+		route, list, ok := strings.Cut(entry, "=")
+		if !ok {
+			log.Printf("[CONFIG] Ignoring invalid route methods entry %q", entry)
+			continue
+		}
+		var methods []string
+		for _, m := range strings.Split(list, "+") {
+			if m = strings.ToUpper(strings.TrimSpace(m)); m != "" {
+				methods = append(methods, m)
+			}
+		}
+		if len(methods) == 0 {
+			log.Printf("[CONFIG] Ignoring empty method list for %s", route)
+			continue
+		}
+		result[strings.TrimSpace(route)] = methods
+	}
+	return result
+}
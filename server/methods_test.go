@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseRouteMethodsOverlaysDefaults(t *testing.T) {
+	got := parseRouteMethods("/api/get=get+head, /api/post=, broken")
+
+	if want := []string{"GET", "HEAD"}; !reflect.DeepEqual(got["/api/get"], want) {
+		t.Errorf("/api/get = %v, want %v", got["/api/get"], want)
+	}
+	if want := defaultRouteMethods["/api/post"]; !reflect.DeepEqual(got["/api/post"], want) {
+		t.Errorf("/api/post = %v, want the default %v after an empty list", got["/api/post"], want)
+	}
+	if want := defaultRouteMethods["/health"]; !reflect.DeepEqual(got["/health"], want) {
+		t.Errorf("/health = %v, want the default %v", got["/health"], want)
+	}
+}
+
+func TestAllowMethodsAnswers405WithAllow(t *testing.T) {
+	withConfig(t, func(c *Config) {
+		c.RouteMethods = parseRouteMethods("/api/post=POST+PUT")
+	})
+	handler := allowMethods("/api/post", okHandler)
+
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodGet, "/api/post", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("status = %d, want 405", w.Code)
+	}
+	if got := w.Header().Get("Allow"); got != "POST, PUT" {
+		t.Fatalf("Allow = %q, want \"POST, PUT\"", got)
+	}
+	if !strings.Contains(w.Body.String(), "Method not allowed") {
+		t.Fatalf("body = %s", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodPut, "/api/post", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("allowed method status = %d, want 200", w.Code)
+	}
+}
+
+func TestAllowMethodsUnlistedRouteAcceptsAll(t *testing.T) {
+	withConfig(t, func(c *Config) { c.RouteMethods = map[string][]string{} })
+	w := httptest.NewRecorder()
+	allowMethods("/custom", okHandler)(w, httptest.NewRequest(http.MethodPatch, "/custom", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", w.Code)
+	}
+}
+
+func TestInHandler405UsesHelper(t *testing.T) {
+	// ROUTE_METHODS lets PUT through, but the handler doesn't implement it
+	withConfig(t, func(c *Config) {
+		c.RouteMethods = parseRouteMethods("/admin/maintenance=GET+POST+PUT")
+	})
+	w := httptest.NewRecorder()
+	allowMethods("/admin/maintenance", maintenanceHandler)(w, httptest.NewRequest(http.MethodPut, "/admin/maintenance", nil))
+	if w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != "GET, POST" {
+		t.Fatalf("status = %d, Allow = %q; want 405 with \"GET, POST\"", w.Code, w.Header().Get("Allow"))
+	}
+}
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Minimal Prometheus text-format metrics, kept dependency-free so the
+// hot path only pays for an atomic increment.
+
+type metric interface {
+	// write renders the metric in the Prometheus text format, or in
+	// OpenMetrics when openMetrics is set (see metricsHandler).
+	write(w io.Writer, openMetrics bool)
+}
+
+var registry []metric
+
+type counter struct {
+	name  string
+	help  string
+	value int64
+}
+
+func newCounter(name, help string) *counter {
+	c := &counter{name: name, help: help}
+	registry = append(registry, c)
+	return c
+}
+
+func (c *counter) inc() {
+	atomic.AddInt64(&c.value, 1)
+}
+
+func (c *counter) write(w io.Writer, openMetrics bool) {
+	family := familyName(c.name, openMetrics)
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n%s %d\n",
+		family, c.help, family, c.name, atomic.LoadInt64(&c.value))
+}
+
+// familyName is the name used in HELP/TYPE lines. OpenMetrics names a
+// counter family without the _total suffix its samples carry.
+func familyName(name string, openMetrics bool) string {
+	if openMetrics {
+		return strings.TrimSuffix(name, "_total")
+	}
+	return name
+}
+
+// counterVec is a counter partitioned by label values. Callers are
+// responsible for keeping label cardinality bounded.
+type counterVec struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]*int64
+}
+
+func newCounterVec(name, help string, labels ...string) *counterVec {
+	c := &counterVec{name: name, help: help, labels: labels, values: make(map[string]*int64)}
+	registry = append(registry, c)
+	return c
+}
+
+func (c *counterVec) inc(labelValues ...string) {
+	key := formatLabels(c.labels, labelValues)
+	c.mu.Lock()
+	v, ok := c.values[key]
+	if !ok {
+		v = new(int64)
+		c.values[key] = v
+	}
+	c.mu.Unlock()
+	atomic.AddInt64(v, 1)
+}
+
+func (c *counterVec) write(w io.Writer, openMetrics bool) {
+	family := familyName(c.name, openMetrics)
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", family, c.help, family)
+	c.mu.Lock()
+	keys := make([]string, 0, len(c.values))
+	for k := range c.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(w, "%s{%s} %d\n", c.name, k, atomic.LoadInt64(c.values[k]))
+	}
+	c.mu.Unlock()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabels(names, values []string) string {
+	var b strings.Builder
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		value := ""
+		if i < len(values) {
+			value = values[i]
+		}
+		b.WriteString(name)
+		b.WriteString(`="`)
+		b.WriteString(labelEscaper.Replace(value))
+		b.WriteByte('"')
+	}
+	return b.String()
+}
+
+type gaugeFunc struct {
+	name string
+	help string
+	fn   func() float64
+}
+
+func newGaugeFunc(name, help string, fn func() float64) *gaugeFunc {
+	g := &gaugeFunc{name: name, help: help, fn: fn}
+	registry = append(registry, g)
+	return g
+}
+
+func (g *gaugeFunc) write(w io.Writer, openMetrics bool) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %g\n",
+		g.name, g.help, g.name, g.name, g.fn())
+}
+
+// histogram is a cumulative Prometheus histogram over fixed upper bounds.
+type histogram struct {
+	name    string
+	help    string
+	buckets []float64
+
+	mu        sync.Mutex
+	counts    []uint64 // per bucket, non-cumulative; the last is +Inf
+	sum       float64
+	count     uint64
+	exemplars []*exemplar // latest traced observation per bucket, nil = none
+}
+
+// exemplar ties one observation to the trace it came from.
+type exemplar struct {
+	traceID string
+	value   float64
+	at      time.Time
+}
+
+func newHistogram(name, help string, buckets []float64) *histogram {
+	h := &histogram{
+		name:      name,
+		help:      help,
+		buckets:   buckets,
+		counts:    make([]uint64, len(buckets)+1),
+		exemplars: make([]*exemplar, len(buckets)+1),
+	}
+	registry = append(registry, h)
+	return h
+}
+
+func (h *histogram) observe(v float64) {
+	h.observeWithTrace(v, "")
+}
+
+// observeWithTrace records v and, when traceID is set, keeps it as the
+// exemplar of its bucket.
+func (h *histogram) observeWithTrace(v float64, traceID string) {
+	i := sort.SearchFloat64s(h.buckets, v)
+	h.mu.Lock()
+	h.counts[i]++
+	h.sum += v
+	h.count++
+	if traceID != "" {
+		h.exemplars[i] = &exemplar{traceID: traceID, value: v, at: time.Now()}
+	}
+	h.mu.Unlock()
+}
+
+func (h *histogram) write(w io.Writer, openMetrics bool) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	h.writeSamples(w, "", openMetrics)
+}
+
+// writeSamples renders the bucket, sum and count lines; labels, if any,
+// are prepended to each line's label set.
+func (h *histogram) writeSamples(w io.Writer, labels string, openMetrics bool) {
+	sumLabels, bucketPrefix := "", ""
+	if labels != "" {
+		sumLabels, bucketPrefix = "{"+labels+"}", labels+","
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var cumulative uint64
+	for i, le := range h.buckets {
+		cumulative += h.counts[i]
+		fmt.Fprintf(w, "%s_bucket{%sle=\"%g\"} %d%s\n", h.name, bucketPrefix, le, cumulative, h.exemplarSuffix(i, openMetrics))
+	}
+	fmt.Fprintf(w, "%s_bucket{%sle=\"+Inf\"} %d%s\n", h.name, bucketPrefix, h.count, h.exemplarSuffix(len(h.buckets), openMetrics))
+	fmt.Fprintf(w, "%s_sum%s %g\n%s_count%s %d\n", h.name, sumLabels, h.sum, h.name, sumLabels, h.count)
+}
+
+// histogramVec is a histogram partitioned by label values. As with
+// counterVec, callers keep label cardinality bounded.
+type histogramVec struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*histogram
+}
+
+func newHistogramVec(name, help string, buckets []float64, labels ...string) *histogramVec {
+	h := &histogramVec{name: name, help: help, labels: labels, buckets: buckets, series: make(map[string]*histogram)}
+	registry = append(registry, h)
+	return h
+}
+
+func (h *histogramVec) observe(v float64, labelValues ...string) {
+	key := formatLabels(h.labels, labelValues)
+	h.mu.Lock()
+	s, ok := h.series[key]
+	if !ok {
+		// Not registered on its own: written by the vec under its labels
+		s = &histogram{name: h.name, buckets: h.buckets, counts: make([]uint64, len(h.buckets)+1), exemplars: make([]*exemplar, len(h.buckets)+1)}
+		h.series[key] = s
+	}
+	h.mu.Unlock()
+	s.observe(v)
+}
+
+func (h *histogramVec) write(w io.Writer, openMetrics bool) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	h.mu.Lock()
+	keys := make([]string, 0, len(h.series))
+	for k := range h.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	series := make([]*histogram, len(keys))
+	for i, k := range keys {
+		series[i] = h.series[k]
+	}
+	h.mu.Unlock()
+	for i, s := range series {
+		s.writeSamples(w, keys[i], openMetrics)
+	}
+}
+
+// exemplarSuffix renders bucket i's exemplar. Only OpenMetrics has a
+// syntax for them; the Prometheus text format would reject the line.
+func (h *histogram) exemplarSuffix(i int, openMetrics bool) string {
+	e := h.exemplars[i]
+	if !openMetrics || e == nil {
+		return ""
+	}
+	return fmt.Sprintf(" # {trace_id=\"%s\"} %g %.3f", e.traceID, e.value, float64(e.at.UnixNano())/1e9)
+}
+
+// rateWindow counts rate-limiter decisions per RATE_LIMIT_PERIOD window and
+// remembers the utilization of the last completed window.
+type rateWindow struct {
+	mu    sync.Mutex
+	start time.Time
+	count int
+	last  float64
+}
+
+func (rw *rateWindow) observe(now time.Time) {
+	rw.mu.Lock()
+	rw.roll(now)
+	rw.count++
+	rw.mu.Unlock()
+}
+
+func (rw *rateWindow) utilization(now time.Time) float64 {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	rw.roll(now)
+	return rw.last
+}
+
+// roll closes the current window once a full period has elapsed. A gap of
+// more than one period means the window in between saw no traffic.
+func (rw *rateWindow) roll(now time.Time) {
+	period := time.Duration(config.RateLimitPeriod) * time.Second
+	if rw.start.IsZero() {
+		rw.start = now
+		return
+	}
+	elapsed := now.Sub(rw.start)
+	if elapsed < period {
+		return
+	}
+	if elapsed < 2*period && config.RateLimitRequests > 0 {
+		rw.last = float64(rw.count) / float64(config.RateLimitRequests)
+	} else {
+		rw.last = 0
+	}
+	rw.count = 0
+	rw.start = now
+}
+
+var (
+	rateLimitWindow = &rateWindow{}
+
+	rateLimitRequests   = newCounter("ratelimit_requests_total", "Requests evaluated by the rate limiter.")
+	rateLimitRejections = newCounterVec("ratelimit_rejections_total", "Requests rejected with 429 by the rate limiter.", "method", "path")
+
+	messagesCacheHits      = newCounter("messages_cache_hits_total", "Messages list requests served from the in-memory cache.")
+	messagesCacheStale     = newCounter("messages_cache_stale_total", "Messages list requests served stale from the cache while it refreshed.")
+	messagesCacheMisses    = newCounter("messages_cache_misses_total", "Messages list requests that had to query the database.")
+	messagesCacheEvictions = newCounter("messages_cache_evictions_total", "Messages cache entries dropped to stay under MESSAGES_CACHE_MAX_KEYS.")
+
+	messageContentBytes = newHistogram("message_content_bytes", "Byte length of message content stored by POST /api/db/messages.",
+		[]float64{64, 256, 1024, 4096, 16384, 65536, 262144, 1048576})
+
+	requestDuration = newHistogram("http_request_duration_seconds", "Time from entering the API middleware stack to the handler returning, throttle included.",
+		[]float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10})
+
+	panicsRecovered = newCounter("panics_recovered_total", "Handler panics turned into 500 responses.")
+
+	jsonEncodeErrors = newCounter("json_encode_errors_total", "JSON responses that failed to encode or write.")
+
+	dbLimitRejections = newCounterVec("db_endpoint_limit_rejections_total", "Requests rejected with 503 by a per-endpoint DB concurrency cap.", "path")
+
+	sloBreaches = newCounterVec("slo_breaches_total", "Requests whose handler time exceeded the path latency SLO.", "path")
+
+	_ = newGaugeFunc("ratelimit_utilization", "Request rate in the last window divided by the configured rate limit.", func() float64 {
+		return rateLimitWindow.utilization(clock.Now())
+	})
+	_ = newGaugeFunc("inflight_requests", "Requests currently being processed by API routes.", func() float64 {
+		return float64(atomic.LoadInt64(&inFlight))
+	})
+)
+
+// metricsHandler serves the Prometheus text format, or OpenMetrics (with
+// exemplars) to scrapers that ask for it when ENABLE_EXEMPLARS is on.
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	openMetrics := config.EnableExemplars && strings.Contains(r.Header.Get("Accept"), "application/openmetrics-text")
+	if openMetrics {
+		w.Header().Set("Content-Type", "application/openmetrics-text; version=1.0.0; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	}
+	for _, m := range registry {
+		m.write(w, openMetrics)
+	}
+	if openMetrics {
+		fmt.Fprint(w, "# EOF\n")
+	}
+}
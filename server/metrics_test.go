@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTraceIDFrom(t *testing.T) {
+	tests := map[string]string{
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01": "4bf92f3577b34da6a3ce929d0e0e4736",
+		"":        "",
+		"garbage": "",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01": "",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01": "",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01": "",
+		"00-4bf92f3577b34da6-00f067aa0ba902b7-01":                 "",
+	}
+	for header, want := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("traceparent", header)
+		if got := traceIDFrom(r); got != want {
+			t.Errorf("traceIDFrom(%q) = %q, want %q", header, got, want)
+		}
+	}
+}
+
+// vecValue reads one series of a counterVec.
+func vecValue(c *counterVec, labelValues ...string) int64 {
+	key := formatLabels(c.labels, labelValues)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if v, ok := c.values[key]; ok {
+		return atomic.LoadInt64(v)
+	}
+	return 0
+}
+
+// histogramStats reads the count and sum of h, like seriesStats does for
+// a labelled series.
+func histogramStats(h *histogram) (uint64, float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.count, h.sum
+}
+
+// waitForCounter polls c until it reaches want.
+func waitForCounter(t *testing.T, c *counter, want int64) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for atomic.LoadInt64(&c.value) < want && time.Now().Before(deadline) {
+		time.Sleep(2 * time.Millisecond)
+	}
+	if got := atomic.LoadInt64(&c.value); got != want {
+		t.Fatalf("%s = %d, want %d", c.name, got, want)
+	}
+}
+
+// testHistogram builds a histogram outside the registry.
+func testHistogram() *histogram {
+	buckets := []float64{0.1, 1}
+	return &histogram{name: "test_seconds", buckets: buckets, counts: make([]uint64, len(buckets)+1), exemplars: make([]*exemplar, len(buckets)+1)}
+}
+
+func TestHistogramExemplarsOnlyInOpenMetrics(t *testing.T) {
+	h := testHistogram()
+	h.observeWithTrace(0.05, "4bf92f3577b34da6a3ce929d0e0e4736")
+	h.observe(0.5)
+
+	var om, text bytes.Buffer
+	h.writeSamples(&om, "", true)
+	h.writeSamples(&text, "", false)
+
+	if !strings.Contains(om.String(), `test_seconds_bucket{le="0.1"} 1 # {trace_id="4bf92f3577b34da6a3ce929d0e0e4736"} 0.05`) {
+		t.Fatalf("OpenMetrics output lacks the exemplar:\n%s", om.String())
+	}
+	if strings.Contains(text.String(), "#") {
+		t.Fatalf("Prometheus text output carries an exemplar:\n%s", text.String())
+	}
+	if !strings.Contains(text.String(), `test_seconds_bucket{le="1"} 2`) || !strings.Contains(text.String(), "test_seconds_count 2") {
+		t.Fatalf("cumulative buckets wrong:\n%s", text.String())
+	}
+}
+
+func TestMetricsHandlerNegotiatesOpenMetrics(t *testing.T) {
+	tests := []struct {
+		name      string
+		exemplars bool
+		accept    string
+		wantType  string
+		wantEOF   bool
+	}{
+		{"prometheus", true, "text/plain", "text/plain; version=0.0.4; charset=utf-8", false},
+		{"openmetrics", true, "application/openmetrics-text; version=1.0.0", "application/openmetrics-text; version=1.0.0; charset=utf-8", true},
+		{"openmetrics asked, exemplars off", false, "application/openmetrics-text", "text/plain; version=0.0.4; charset=utf-8", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withConfig(t, func(c *Config) { c.EnableExemplars = tt.exemplars })
+			r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+			r.Header.Set("Accept", tt.accept)
+			w := httptest.NewRecorder()
+			metricsHandler(w, r)
+
+			if got := w.Header().Get("Content-Type"); got != tt.wantType {
+				t.Fatalf("Content-Type = %q, want %q", got, tt.wantType)
+			}
+			if got := strings.HasSuffix(w.Body.String(), "# EOF\n"); got != tt.wantEOF {
+				t.Fatalf("# EOF terminator present = %v, want %v", got, tt.wantEOF)
+			}
+		})
+	}
+}
+
+func TestRateWindowUtilization(t *testing.T) {
+	withConfig(t, func(c *Config) {
+		c.RateLimitRequests = 10
+		c.RateLimitPeriod = 60
+	})
+	clk := newMockClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	rw := &rateWindow{}
+
+	for i := 0; i < 4; i++ {
+		rw.observe(clk.Now())
+	}
+	// The first window is still open: nothing completed yet
+	clk.Advance(30 * time.Second)
+	if got := rw.utilization(clk.Now()); got != 0 {
+		t.Fatalf("utilization mid-window = %g, want 0", got)
+	}
+
+	// The window closes with 4 of 10 requests used
+	clk.Advance(30 * time.Second)
+	if got := rw.utilization(clk.Now()); got != 0.4 {
+		t.Fatalf("utilization after one window = %g, want 0.4", got)
+	}
+
+	for i := 0; i < 10; i++ {
+		rw.observe(clk.Now())
+	}
+	clk.Advance(time.Minute)
+	if got := rw.utilization(clk.Now()); got != 1 {
+		t.Fatalf("utilization after a full window = %g, want 1", got)
+	}
+}
+
+func TestRateWindowIdleGapReadsZero(t *testing.T) {
+	withConfig(t, func(c *Config) {
+		c.RateLimitRequests = 10
+		c.RateLimitPeriod = 60
+	})
+	clk := newMockClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	rw := &rateWindow{}
+
+	for i := 0; i < 9; i++ {
+		rw.observe(clk.Now())
+	}
+	// More than two periods without traffic: the last window was empty,
+	// not the one that had 9 requests
+	clk.Advance(150 * time.Second)
+	if got := rw.utilization(clk.Now()); got != 0 {
+		t.Fatalf("utilization after an idle gap = %g, want 0", got)
+	}
+	rw.observe(clk.Now())
+	clk.Advance(time.Minute)
+	if got := rw.utilization(clk.Now()); got != 0.1 {
+		t.Fatalf("utilization of the window after the gap = %g, want 0.1", got)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strings"
+)
+
+// operationalRoutes are never throttled or rate-limited, even if they end up
+// wrapped by the API stack; probes and scrapers must not see 429s.
+var operationalRoutes = map[string]bool{
+	"/health":  true,
+	"/metrics": true,
+	"/readyz":  true,
+}
+
+type namedMiddleware struct {
+	name string
+	wrap func(http.HandlerFunc) http.HandlerFunc
+}
+
+// apiMiddlewares is the full API stack, outermost first. Names are what
+// ROUTE_SKIP_MIDDLEWARE refers to.
+var apiMiddlewares = []namedMiddleware{
+	{"maintenance", maintenanceMiddleware},
+	{"loadshed", loadShedMiddleware},
+	{"qos", qosMiddleware},
+	{"timing", timingMiddleware},
+	{"slo", sloMiddleware},
+	{"logging", loggingMiddleware},
+	{"stats", statsMiddleware},
+	{"consistency", consistencyMiddleware},
+	{"deadline", deadlineMiddleware},
+	{"headers", requiredHeadersMiddleware},
+	{"signature", signatureMiddleware},
+	{"readonly", readOnlyMiddleware},
+	{"loadtest", loadTestMiddleware},
+	{"throttle", throttleMiddleware},
+	{"ratelimit", rateLimitMiddleware},
+	{"quota", quotaMiddleware},
+	{"dblimit", dbLimitMiddleware},
+	{"timer", handlerTimer},
+}
+
+// middlewareStack selects which of apiMiddlewares wrap a handler.
+type middlewareStack struct {
+	skip map[string]bool
+}
+
+func newMiddlewareStack() *middlewareStack {
+	return &middlewareStack{skip: make(map[string]bool)}
+}
+
+// without drops the named middlewares from the stack.
+func (s *middlewareStack) without(names ...string) *middlewareStack {
+	for _, name := range names {
+		s.skip[name] = true
+	}
+	return s
+}
+
+// wrap applies the selected middlewares keeping their usual order.
+func (s *middlewareStack) wrap(next http.HandlerFunc) http.HandlerFunc {
+	h := next
+	for i := len(apiMiddlewares) - 1; i >= 0; i-- {
+		if m := apiMiddlewares[i]; !s.skip[m.name] {
+			h = m.wrap(h)
+		}
+	}
+	return h
+}
+
+func combinedMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return newMiddlewareStack().wrap(next)
+}
+
+// routeMiddleware is the stack for a route, minus skip and whatever
+// ROUTE_SKIP_MIDDLEWARE disables for it.
+func routeMiddleware(pattern string, next http.HandlerFunc, skip ...string) http.HandlerFunc {
+	return newMiddlewareStack().without(skip...).without(config.RouteSkipMiddleware[pattern]...).wrap(next)
+}
+
+// parseRouteSkips parses "/api/get=throttle,/api/post=throttle+ratelimit".
+// Unknown middleware names are logged and ignored.
+func parseRouteSkips(value string) map[string][]string {
+	known := make(map[string]bool, len(apiMiddlewares))
+	for _, m := range apiMiddlewares {
+		known[m.name] = true
+	}
+
+	result := make(map[string][]string)
+	for _, entry := range parseList(value) {
+		route, names, ok := strings.Cut(entry, "=")
+		if !ok {
+			log.Printf("[CONFIG] Ignoring invalid route middleware entry %q", entry)
+			continue
+		}
+		route = strings.TrimSpace(route)
+		for _, name := range strings.Split(names, "+") {
+			name = strings.TrimSpace(name)
+			if !known[name] {
+				log.Printf("[CONFIG] Unknown middleware %q for %s", name, route)
+				continue
+			}
+			result[route] = append(result[route], name)
+		}
+	}
+	return result
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+func TestParseRouteSkips(t *testing.T) {
+	got := parseRouteSkips("/api/get=throttle, /api/post=throttle+ratelimit, /api/db/messages=nosuch+quota, broken")
+	want := map[string][]string{
+		"/api/get":         {"throttle"},
+		"/api/post":        {"throttle", "ratelimit"},
+		"/api/db/messages": {"quota"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("parseRouteSkips = %v, want %v", got, want)
+	}
+}
+
+func TestRouteSkipMiddlewareSkipsThrottle(t *testing.T) {
+	withConfig(t, func(c *Config) {
+		c.ThrottleMinMs = 100
+		c.ThrottleMaxMs = 100
+		c.ThrottleTargetMs = 0
+		c.RouteSkipMiddleware = parseRouteSkips("/api/get=throttle")
+	})
+	clk := useMockClock(t)
+	useLimiter(t, rate.NewLimiter(rate.Inf, 0))
+
+	w := httptest.NewRecorder()
+	routeMiddleware("/api/get", getHandler)(w, httptest.NewRequest(http.MethodGet, "/api/get", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("/api/get status = %d", w.Code)
+	}
+	if got := clk.Slept(); got != 0 {
+		t.Fatalf("/api/get slept %v with throttle skipped, want 0", got)
+	}
+
+	// A route without an entry keeps the full stack
+	w = httptest.NewRecorder()
+	routeMiddleware("/api/post", postHandler)(w, httptest.NewRequest(http.MethodPost, "/api/post", nil))
+	if got := clk.Slept(); got != 100*time.Millisecond {
+		t.Fatalf("/api/post slept %v, want the 100ms throttle", got)
+	}
+}
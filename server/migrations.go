@@ -0,0 +1,135 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// migrations run in order on every startup, so each statement must be
+// idempotent (IF NOT EXISTS). Append new entries; never edit old ones.
+var migrations = []struct {
+	name string
+	sql  string
+}{
+	{"create messages", `
+		CREATE TABLE IF NOT EXISTS messages (
+			id SERIAL PRIMARY KEY,
+			content TEXT NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)
+	`},
+	{"create failed_writes", `
+		CREATE TABLE IF NOT EXISTS failed_writes (
+			id SERIAL PRIMARY KEY,
+			content TEXT NOT NULL,
+			error TEXT NOT NULL,
+			attempts INT NOT NULL DEFAULT 1,
+			failed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)
+	`},
+	{"add messages.content_type", `
+		ALTER TABLE messages ADD COLUMN IF NOT EXISTS content_type TEXT NOT NULL DEFAULT 'text/plain'
+	`},
+	{"add failed_writes.content_type", `
+		ALTER TABLE failed_writes ADD COLUMN IF NOT EXISTS content_type TEXT NOT NULL DEFAULT 'text/plain'
+	`},
+	{"create api_quotas", `
+		CREATE TABLE IF NOT EXISTS api_quotas (
+			client_key TEXT NOT NULL,
+			period_start TIMESTAMP NOT NULL,
+			used INT NOT NULL,
+			PRIMARY KEY (client_key, period_start)
+		)
+	`},
+	{"add messages.expires_at", `
+		ALTER TABLE messages ADD COLUMN IF NOT EXISTS expires_at TIMESTAMPTZ
+	`},
+	{"index messages.expires_at", `
+		CREATE INDEX IF NOT EXISTS messages_expires_at_idx ON messages (expires_at) WHERE expires_at IS NOT NULL
+	`},
+	{"add failed_writes.expires_at", `
+		ALTER TABLE failed_writes ADD COLUMN IF NOT EXISTS expires_at TIMESTAMPTZ
+	`},
+	{"add messages.title and author", `
+		ALTER TABLE messages
+			ADD COLUMN IF NOT EXISTS title TEXT NOT NULL DEFAULT '',
+			ADD COLUMN IF NOT EXISTS author TEXT NOT NULL DEFAULT ''
+	`},
+	{"add failed_writes.title and author", `
+		ALTER TABLE failed_writes
+			ADD COLUMN IF NOT EXISTS title TEXT NOT NULL DEFAULT '',
+			ADD COLUMN IF NOT EXISTS author TEXT NOT NULL DEFAULT ''
+	`},
+}
+
+func runMigrations(conn *sql.DB) error {
+	for _, m := range migrations {
+		if err := execMigration(conn, m.name, m.sql); err != nil {
+			log.Printf("[DB] Migration %q failed: %v", m.name, err)
+			return err
+		}
+	}
+	return nil
+}
+
+const migrationRaceRetries = 3
+
+// execMigration runs one idempotent statement, retrying when it lost a
+// creation race against another instance migrating the same fresh
+// database: IF NOT EXISTS checks the catalog before creating, so two
+// concurrent creators can both pass the check and the loser fails. On the
+// retry the object exists and the statement is a no-op.
+func execMigration(conn *sql.DB, name, stmt string) error {
+	_, err := conn.Exec(stmt)
+	for attempt := 1; attempt <= migrationRaceRetries && isCreationRace(err); attempt++ {
+		log.Printf("[DB] Migration %q raced with another instance, retry %d/%d: %v", name, attempt, migrationRaceRetries, err)
+		time.Sleep(time.Duration(attempt) * 100 * time.Millisecond)
+		_, err = conn.Exec(stmt)
+	}
+	return err
+}
+
+// isCreationRace matches the errors Postgres raises when a concurrent DDL
+// statement created the same object first.
+func isCreationRace(err error) bool {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return false
+	}
+	switch pqErr.Code {
+	case "42P07", "42701", "42710": // duplicate_table, duplicate_column, duplicate_object
+		return true
+	case "23505": // unique_violation on the catalog, e.g. pg_type_typname_nsp_index
+		return strings.HasPrefix(pqErr.Constraint, "pg_")
+	}
+	return false
+}
+
+// readIndexes speed up the list queries but are opt-in (DB_AUTO_INDEXES):
+// building them on a large existing table is DDL operators may want to
+// schedule themselves.
+var readIndexes = []struct {
+	name string
+	sql  string
+}{
+	{"messages_created_at_idx", `
+		CREATE INDEX IF NOT EXISTS messages_created_at_idx ON messages (created_at DESC)
+	`},
+}
+
+func createReadIndexes(conn *sql.DB) error {
+	for _, idx := range readIndexes {
+		start := time.Now()
+		if err := execMigration(conn, idx.name, idx.sql); err != nil {
+			log.Printf("[DB] Creating index %s failed: %v", idx.name, err)
+			return err
+		}
+		log.Printf("[DB] Index %s ready in %v", idx.name, time.Since(start))
+	}
+	return nil
+}
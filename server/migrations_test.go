@@ -0,0 +1,108 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+// hasIndex reports whether the SQLite test DB has an index called name.
+func hasIndex(t *testing.T, name string) bool {
+	t.Helper()
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'index' AND name = $1", name).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n == 1
+}
+
+func TestReadIndexesCreatedByInit(t *testing.T) {
+	withConfig(t, func(c *Config) { c.DBAutoIndexes = true })
+	useTestDB(t)
+
+	for _, idx := range readIndexes {
+		if !hasIndex(t, idx.name) {
+			t.Fatalf("index %s missing after initDB with DB_AUTO_INDEXES", idx.name)
+		}
+	}
+	// A restart runs them again over the existing indexes
+	if err := createReadIndexes(db); err != nil {
+		t.Fatalf("second createReadIndexes: %v", err)
+	}
+	for _, idx := range readIndexes {
+		if !hasIndex(t, idx.name) {
+			t.Fatalf("index %s gone after a second run", idx.name)
+		}
+	}
+}
+
+func TestReadIndexesOptIn(t *testing.T) {
+	withConfig(t, func(c *Config) { c.DBAutoIndexes = false })
+	useTestDB(t)
+
+	for _, idx := range readIndexes {
+		if hasIndex(t, idx.name) {
+			t.Fatalf("index %s created without DB_AUTO_INDEXES", idx.name)
+		}
+	}
+}
+
+func TestIsCreationRace(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{&pq.Error{Code: "42P07"}, true},
+		{&pq.Error{Code: "42701"}, true},
+		{&pq.Error{Code: "42710"}, true},
+		{&pq.Error{Code: "23505", Constraint: "pg_type_typname_nsp_index"}, true},
+		{&pq.Error{Code: "23505", Constraint: "messages_pkey"}, false},
+		{&pq.Error{Code: "42601"}, false},
+		{errors.New("duplicate table"), false},
+		{nil, false},
+	}
+	for _, tt := range tests {
+		if got := isCreationRace(tt.err); got != tt.want {
+			t.Errorf("isCreationRace(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestExecMigrationRetriesRace(t *testing.T) {
+	captureLog(t)
+	f := &fakeDB{err: &pq.Error{Code: "42P07"}, errN: 1}
+	conn := sql.OpenDB(f)
+	defer conn.Close()
+
+	if err := execMigration(conn, "messages_created_at_idx", "CREATE INDEX x"); err != nil {
+		t.Fatalf("execMigration = %v, want success on the retry", err)
+	}
+	if n := f.count("CREATE INDEX"); n != 2 {
+		t.Fatalf("%d attempts, want 2", n)
+	}
+}
+
+func TestExecMigrationGivesUp(t *testing.T) {
+	captureLog(t)
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"race", &pq.Error{Code: "42P07"}, 1 + migrationRaceRetries},
+		{"other error", &pq.Error{Code: "42601"}, 1},
+	}
+	for _, tt := range tests {
+		f := &fakeDB{err: tt.err}
+		conn := sql.OpenDB(f)
+		if err := execMigration(conn, "x", "CREATE INDEX x"); err == nil {
+			t.Errorf("%s: execMigration succeeded", tt.name)
+		}
+		if n := f.count("CREATE INDEX"); n != tt.want {
+			t.Errorf("%s: %d attempts, want %d", tt.name, n, tt.want)
+		}
+		conn.Close()
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+)
+
+// notifyPayload is what LISTEN consumers receive. Content is left out:
+// NOTIFY payloads are capped at 8000 bytes, so consumers fetch the row by id.
+type notifyPayload struct {
+	ID          int       `json:"id"`
+	ContentType string    `json:"content_type"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// notifyInsert fires a Postgres NOTIFY on NOTIFY_CHANNEL for a stored
+// message. Failures are only logged; the insert itself already succeeded.
+func notifyInsert(msg Message) {
+	if config.NotifyChannel == "" {
+		return
+	}
+
+	payload, err := json.Marshal(notifyPayload{ID: msg.ID, ContentType: msg.ContentType, CreatedAt: msg.CreatedAt})
+	if err != nil {
+		log.Printf("[NOTIFY] Failed to encode payload for message %d: %v", msg.ID, err)
+		return
+	}
+
+	// pg_notify takes the channel as a parameter, unlike the NOTIFY statement
+	if _, err := db.Exec("SELECT pg_notify($1, $2)", config.NotifyChannel, string(payload)); err != nil {
+		log.Printf("[NOTIFY] Failed to notify %s for message %d: %v", config.NotifyChannel, msg.ID, err)
+	}
+}
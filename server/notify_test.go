@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNotifyInsert(t *testing.T) {
+	withConfig(t, func(c *Config) { c.NotifyChannel = "new_messages" })
+	fake := useFakeDB(t, nil)
+
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	notifyInsert(Message{ID: 42, Content: "not sent", ContentType: "text/plain", CreatedAt: created})
+
+	args := fake.argsOf("pg_notify")
+	if len(args) != 2 || args[0] != "new_messages" {
+		t.Fatalf("pg_notify args = %v, want the channel and a payload", args)
+	}
+	var payload notifyPayload
+	if err := json.Unmarshal([]byte(args[1].(string)), &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.ID != 42 || payload.ContentType != "text/plain" || !payload.CreatedAt.Equal(created) {
+		t.Fatalf("payload = %+v", payload)
+	}
+	if strings.Contains(args[1].(string), "not sent") {
+		t.Fatalf("payload %s carries the content", args[1])
+	}
+}
+
+func TestNotifyInsertDisabled(t *testing.T) {
+	withConfig(t, func(c *Config) { c.NotifyChannel = "" })
+	fake := useFakeDB(t, nil)
+
+	notifyInsert(Message{ID: 1})
+	if n := fake.count("pg_notify"); n != 0 {
+		t.Fatalf("%d NOTIFY statements without NOTIFY_CHANNEL", n)
+	}
+}
+
+func TestNotifyInsertFailureOnlyLogged(t *testing.T) {
+	withConfig(t, func(c *Config) { c.NotifyChannel = "new_messages" })
+	useFakeDB(t, errors.New("connection reset"))
+	logs := captureLog(t)
+
+	notifyInsert(Message{ID: 9})
+	if !strings.Contains(logs.String(), "Failed to notify new_messages for message 9") {
+		t.Fatalf("failure not logged:\n%s", logs.String())
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"time"
+)
+
+// startPprofServer exposes net/http/pprof on PPROF_ADDR when ENABLE_PPROF
+// is set, entirely separate from the API mux so profiling never goes
+// through rate limiting and is never reachable on the public port. Returns
+// nil when profiling is off; otherwise Addr is the address actually bound.
+func startPprofServer() *http.Server {
+	if !config.EnablePprof {
+		return nil
+	}
+
+	listener, err := net.Listen("tcp", config.PprofAddr)
+	if err != nil {
+		log.Printf("[PPROF] Cannot listen on %s: %v", config.PprofAddr, err)
+		return nil
+	}
+
+	server := &http.Server{
+		Addr:              listener.Addr().String(),
+		Handler:           newPprofMux(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		log.Printf("[PPROF] Profiling endpoints at http://%s/debug/pprof/", server.Addr)
+		if err := server.Serve(listener); err != nil {
+			log.Printf("[PPROF] Server stopped: %v", err)
+		}
+	}()
+	return server
+}
+
+func newPprofMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return mux
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestPprofOnlyWhenEnabled(t *testing.T) {
+	withConfig(t, func(c *Config) { c.EnablePprof = false })
+	if srv := startPprofServer(); srv != nil {
+		srv.Close()
+		t.Fatal("pprof server started without ENABLE_PPROF")
+	}
+
+	withConfig(t, func(c *Config) {
+		c.EnablePprof = true
+		c.PprofAddr = "127.0.0.1:0"
+	})
+	srv := startPprofServer()
+	if srv == nil {
+		t.Fatal("pprof server not started with ENABLE_PPROF=true")
+	}
+	t.Cleanup(func() { srv.Shutdown(context.Background()) })
+
+	for _, path := range []string{"/debug/pprof/", "/debug/pprof/cmdline", "/debug/pprof/symbol"} {
+		resp, err := http.Get("http://" + srv.Addr + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("%s on the pprof port = %d, want 200", path, resp.StatusCode)
+		}
+	}
+}
+
+func TestPprofAbsentFromAPIMux(t *testing.T) {
+	withConfig(t, func(c *Config) { c.EnablePprof = true })
+	srv := useRouter(t)
+
+	for _, path := range []string{"/debug/pprof/", "/debug/pprof/cmdline", "/api/debug/pprof/"} {
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("%s on the API port = %d, want 404", path, resp.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// QoS classes: with MAX_CONCURRENT_REQUESTS set, requests past the limit
+// wait in one FIFO queue per class and a freed slot always goes to the
+// highest class waiting, so "high" clients keep moving while "low" ones
+// absorb the contention.
+
+const (
+	qosHigh = iota
+	qosNormal
+	qosLow
+	qosClasses
+)
+
+var qosClassNames = [qosClasses]string{"high", "normal", "low"}
+
+var errQoSQueueFull = errors.New("qos queue full")
+
+type qosWaiter struct {
+	ready   chan struct{}
+	granted bool // set under the limiter lock when a slot is handed over
+}
+
+type priorityLimiter struct {
+	mu       sync.Mutex
+	limit    int
+	maxQueue int
+	active   int
+	queued   int
+	waiting  [qosClasses][]*qosWaiter
+}
+
+var qosLimiter *priorityLimiter
+
+func newPriorityLimiter(limit, maxQueue int) *priorityLimiter {
+	return &priorityLimiter{limit: limit, maxQueue: maxQueue}
+}
+
+// acquire takes a slot, waiting behind the same or higher classes when the
+// limiter is saturated, until ctx ends.
+func (l *priorityLimiter) acquire(ctx context.Context, class int) error {
+	l.mu.Lock()
+	// Slots are handed straight to waiters on release, so a free slot means
+	// nobody is queued
+	if l.active < l.limit {
+		l.active++
+		l.mu.Unlock()
+		return nil
+	}
+	if l.queued >= l.maxQueue {
+		l.mu.Unlock()
+		return errQoSQueueFull
+	}
+	w := &qosWaiter{ready: make(chan struct{})}
+	l.waiting[class] = append(l.waiting[class], w)
+	l.queued++
+	l.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if w.granted {
+			// Lost the race with release: pass the slot on
+			l.handOff()
+			return ctx.Err()
+		}
+		queue := l.waiting[class]
+		for i, q := range queue {
+			if q == w {
+				l.waiting[class] = append(queue[:i], queue[i+1:]...)
+				l.queued--
+				break
+			}
+		}
+		return ctx.Err()
+	}
+}
+
+func (l *priorityLimiter) release() {
+	l.mu.Lock()
+	l.handOff()
+	l.mu.Unlock()
+}
+
+// handOff gives the caller's slot to the first waiter of the highest class,
+// or frees it. Must be called with l.mu held.
+func (l *priorityLimiter) handOff() {
+	for class := range l.waiting {
+		if queue := l.waiting[class]; len(queue) > 0 {
+			w := queue[0]
+			l.waiting[class] = queue[1:]
+			l.queued--
+			w.granted = true
+			close(w.ready)
+			return
+		}
+	}
+	l.active--
+}
+
+func (l *priorityLimiter) queueDepth() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.queued
+}
+
+// qosClass reads the class from QOS_HEADER when configured, then from the
+// client's API key (QOS_KEYS), defaulting to normal.
+func qosClass(r *http.Request) int {
+	if config.QoSHeader != "" {
+		if class, ok := parseQoSClass(r.Header.Get(config.QoSHeader)); ok {
+			return class
+		}
+	}
+	if config.RateLimitKeyHeader != "" {
+		if class, ok := config.QoSKeys[r.Header.Get(config.RateLimitKeyHeader)]; ok {
+			return class
+		}
+	}
+	return qosNormal
+}
+
+func parseQoSClass(name string) (int, bool) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for class, n := range qosClassNames {
+		if n == name {
+			return class, true
+		}
+	}
+	return 0, false
+}
+
+// parseQoSKeys parses "key=high,key=low" into API key -> class.
+func parseQoSKeys(value string) map[string]int {
+	result := make(map[string]int)
+	for _, entry := range parseList(value) {
+		key, name, ok := strings.Cut(entry, "=")
+		class, known := parseQoSClass(name)
+		if !ok || !known {
+			log.Printf("[CONFIG] Ignoring invalid QoS key entry %q", entry)
+			continue
+		}
+		result[strings.TrimSpace(key)] = class
+	}
+	return result
+}
+
+func qosMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if qosLimiter == nil || operationalRoutes[r.URL.Path] {
+			next(w, r)
+			return
+		}
+
+		class := qosClass(r)
+		if err := qosLimiter.acquire(r.Context(), class); err != nil {
+			if errors.Is(err, errQoSQueueFull) {
+				qosRejections.inc(qosClassNames[class])
+				w.Header().Set("Retry-After", retryAfter(qosLimiter.queueDepth(), qosThroughput.perSecond()))
+				writeJSON(w, r, http.StatusServiceUnavailable, map[string]string{
+					"error": "Server is at capacity. Please retry later.",
+				})
+			}
+			// Otherwise the client (or the total deadline) gave up while queued
+			return
+		}
+		defer func() {
+			qosLimiter.release()
+			qosThroughput.done()
+		}()
+		next(w, r)
+	}
+}
+
+var (
+	qosRejections = newCounterVec("qos_rejections_total", "Requests rejected because the QoS queue was full.", "class")
+
+	_ = newGaugeFunc("qos_queue_depth", "Requests waiting for a MAX_CONCURRENT_REQUESTS slot.", func() float64 {
+		if qosLimiter == nil {
+			return 0
+		}
+		return float64(qosLimiter.queueDepth())
+	})
+)
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+// useQoSLimiter installs a MAX_CONCURRENT_REQUESTS limiter for the test.
+func useQoSLimiter(t *testing.T, limit, maxQueue int) *priorityLimiter {
+	t.Helper()
+	prev := qosLimiter
+	qosLimiter = newPriorityLimiter(limit, maxQueue)
+	t.Cleanup(func() { qosLimiter = prev })
+	return qosLimiter
+}
+
+func TestOpenStreamDoesNotHoldQoSSlot(t *testing.T) {
+	withConfig(t, func(c *Config) { c.MaxConcurrentRequests = 1 })
+	useQoSLimiter(t, 1, 0)
+	srv := useRouter(t)
+
+	openStream(t, srv)
+	openStream(t, srv)
+
+	// With the only slot taken and no queue, /api/get would get a 503
+	resp, err := http.Get(srv.URL + "/api/get")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("/api/get with two open streams: status = %d, want 200", resp.StatusCode)
+	}
+}
+
+func TestQoSRejectsWhenSaturated(t *testing.T) {
+	withConfig(t, nil)
+	l := useQoSLimiter(t, 1, 0)
+	if err := l.acquire(context.Background(), qosNormal); err != nil {
+		t.Fatal(err)
+	}
+	defer l.release()
+
+	srv := useRouter(t)
+	resp, err := http.Get(srv.URL + "/api/get")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want 503 once the only slot is taken", resp.StatusCode)
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+type queryEndpointKey struct{}
+
+// withQueryEndpoint records the request's method and route for tagQuery.
+// The route goes through routeLabel, so unknown paths are tagged "other"
+// instead of copying client input into the SQL text.
+func withQueryEndpoint(ctx context.Context, r *http.Request) context.Context {
+	return context.WithValue(ctx, queryEndpointKey{}, [2]string{methodLabel(r), routeLabel(r)})
+}
+
+// tagQuery prefixes a statement with /* reqid=... endpoint=... method=... */
+// when DB_QUERY_COMMENTS is set, so pg_stat_activity entries and slow
+// queries in the Postgres logs can be traced back to the request that
+// issued them.
+func tagQuery(ctx context.Context, query string) string {
+	if !config.DBTagQueries {
+		return query
+	}
+
+	var tags []string
+	if id := sanitizeTag(requestIDFrom(ctx)); id != "" {
+		tags = append(tags, "reqid="+id)
+	}
+	if ep, ok := ctx.Value(queryEndpointKey{}).([2]string); ok {
+		tags = append(tags, "endpoint="+sanitizeTag(ep[1]), "method="+sanitizeTag(ep[0]))
+	}
+	if len(tags) == 0 {
+		return query
+	}
+	return "/* " + strings.Join(tags, " ") + " */ " + query
+}
+
+// sanitizeTag keeps only characters that can't end the SQL comment: the
+// request ID may come straight from a client header. "/" alone is harmless
+// since "*" never survives.
+func sanitizeTag(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.', r == '/':
+			return r
+		}
+		return -1
+	}, s)
+}
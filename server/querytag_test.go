@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// useKnownRoute registers pattern as a metric label for the test when
+// newRouter hasn't already.
+func useKnownRoute(t *testing.T, pattern string) {
+	t.Helper()
+	if !knownRoutes[pattern] {
+		knownRoutes[pattern] = true
+		t.Cleanup(func() { delete(knownRoutes, pattern) })
+	}
+}
+
+func TestTagQuery(t *testing.T) {
+	withConfig(t, func(c *Config) { c.DBTagQueries = true })
+	useKnownRoute(t, "/api/db/messages")
+
+	var tagged string
+	handler := requestIDMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tagged = tagQuery(r.Context(), "SELECT 1")
+	}))
+	r := httptest.NewRequest(http.MethodGet, "/api/db/messages", nil)
+	// "*/" would close the comment; "/" alone is harmless
+	r.Header.Set("X-Request-ID", "abc-123*/; DROP")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	if want := "/* reqid=abc-123/DROP endpoint=/api/db/messages method=GET */ SELECT 1"; tagged != want {
+		t.Fatalf("tagQuery = %q, want %q", tagged, want)
+	}
+}
+
+func TestTagQueryUnknownRouteAndDisabled(t *testing.T) {
+	withConfig(t, func(c *Config) { c.DBTagQueries = true })
+	var tagged string
+	handler := requestIDMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tagged = tagQuery(r.Context(), "SELECT 1")
+	}))
+	r := httptest.NewRequest(http.MethodGet, "/user/controlled/path", nil)
+	r.Header.Set("X-Request-ID", "id1")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+	if want := "/* reqid=id1 endpoint=other method=GET */ SELECT 1"; tagged != want {
+		t.Fatalf("tagQuery = %q, want %q", tagged, want)
+	}
+
+	config.DBTagQueries = false
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+	if tagged != "SELECT 1" {
+		t.Fatalf("disabled tagQuery = %q, want the bare query", tagged)
+	}
+}
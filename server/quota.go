@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Long-horizon request quotas (per day or month), tracked in Postgres so
+// they survive restarts and are shared between instances.
+
+// quotaWindow returns the start of the current quota period and the start
+// of the next one, in UTC.
+func quotaWindow(now time.Time) (time.Time, time.Time) {
+	now = now.UTC()
+	if config.QuotaPeriod == "month" {
+		start := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+		return start, start.AddDate(0, 1, 0)
+	}
+	start := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	return start, start.AddDate(0, 0, 1)
+}
+
+// quotaLimitFor returns the cap for a client: a QUOTA_LIMITS override for
+// its API key, or QUOTA_LIMIT.
+func quotaLimitFor(r *http.Request) (string, int) {
+	key, policy := clientKeyAndPolicy(r)
+	if policy == "key" {
+		if limit, ok := config.QuotaLimits[strings.TrimPrefix(key, "key:")]; ok {
+			return key, limit
+		}
+	}
+	return key, config.QuotaLimit
+}
+
+// consumeQuota counts one request against the client's quota. ok is false
+// once the cap is reached; rejected requests are not counted.
+func consumeQuota(ctx context.Context, key string, limit int, periodStart time.Time) (used int, ok bool, err error) {
+	qctx, cancel := dbContext(ctx)
+	defer cancel()
+	err = db.QueryRowContext(qctx, `
+		INSERT INTO api_quotas (client_key, period_start, used) VALUES ($1, $2, 1)
+		ON CONFLICT (client_key, period_start)
+		DO UPDATE SET used = api_quotas.used + 1 WHERE api_quotas.used < $3
+		RETURNING used
+	`, key, periodStart, limit).Scan(&used)
+	if err == sql.ErrNoRows {
+		return limit, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return used, true, nil
+}
+
+// quotaMiddleware enforces QUOTA_LIMIT per client. If the quota table can't
+// be reached the request is let through: quotas are not worth an outage.
+func quotaMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if (config.QuotaLimit <= 0 && len(config.QuotaLimits) == 0) || operationalRoutes[r.URL.Path] {
+			next(w, r)
+			return
+		}
+
+		key, limit := quotaLimitFor(r)
+		if limit <= 0 {
+			next(w, r)
+			return
+		}
+
+		now := clock.Now()
+		periodStart, reset := quotaWindow(now)
+		used, ok, err := consumeQuota(r.Context(), key, limit, periodStart)
+		if err != nil {
+			log.Printf("[QUOTA] Failed to update quota for %s, allowing request: %v", key, err)
+			next(w, r)
+			return
+		}
+
+		w.Header().Set("X-Quota-Limit", strconv.Itoa(limit))
+		w.Header().Set("X-Quota-Remaining", strconv.Itoa(limit-used))
+		w.Header().Set("X-Quota-Reset", strconv.FormatInt(reset.Unix(), 10))
+
+		if !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds(reset.Sub(now))))
+			writeJSON(w, r, http.StatusTooManyRequests, map[string]interface{}{
+				"error":    "Quota exceeded",
+				"limit":    limit,
+				"reset_at": reset,
+			})
+			return
+		}
+		next(w, r)
+	}
+}
+
+// startQuotaPrune drops finished quota windows once an hour, so api_quotas
+// holds one row per active client instead of one per client per period.
+func startQuotaPrune() {
+	go func() {
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+		for range ticker.C {
+			pruneQuotaWindows(clock.Now())
+		}
+	}()
+}
+
+// pruneQuotaWindows deletes the rows of every period before the one now
+// falls in.
+func pruneQuotaWindows(now time.Time) {
+	current, _ := quotaWindow(now)
+	res, err := db.Exec("DELETE FROM api_quotas WHERE period_start < $1", current)
+	if err != nil {
+		log.Printf("[QUOTA] Prune of old quota windows failed: %v", err)
+		return
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		log.Printf("[QUOTA] Pruned %d rows of past quota windows", n)
+	}
+}
+
+// parseQuotaLimits parses "key=limit,key=limit".
+func parseQuotaLimits(value string) map[string]int {
+	result := make(map[string]int)
+	for _, entry := range parseList(value) {
+		key, n, ok := strings.Cut(entry, "=")
+		limit, err := strconv.Atoi(strings.TrimSpace(n))
+		if !ok || err != nil || limit < 0 {
+			log.Printf("[CONFIG] Ignoring invalid quota entry %q", entry)
+			continue
+		}
+		result[strings.TrimSpace(key)] = limit
+	}
+	return result
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestQuotaCapAndReset(t *testing.T) {
+	withConfig(t, func(c *Config) {
+		c.QuotaLimit = 2
+		c.QuotaPeriod = "day"
+	})
+	useTestDB(t)
+	clk := useMockClock(t)
+	clk.Advance(10 * time.Hour) // 2024-01-01 10:00 UTC
+	handler := quotaMiddleware(okHandler)
+	send := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler(w, requestFrom("10.0.0.1"))
+		return w
+	}
+
+	for i, remaining := range []string{"1", "0"} {
+		w := send()
+		if w.Code != http.StatusOK || w.Header().Get("X-Quota-Remaining") != remaining {
+			t.Fatalf("request %d = %d, remaining %q; want 200, %s", i+1, w.Code, w.Header().Get("X-Quota-Remaining"), remaining)
+		}
+	}
+	reset := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	w := send()
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("request past the cap = %d, want 429", w.Code)
+	}
+	if got := w.Header().Get("X-Quota-Reset"); got != strconv.FormatInt(reset.Unix(), 10) {
+		t.Fatalf("X-Quota-Reset = %s, want midnight UTC", got)
+	}
+	if got := w.Header().Get("Retry-After"); got != strconv.Itoa(14*60*60) {
+		t.Fatalf("Retry-After = %s, want the 14h left in the day", got)
+	}
+	// Another client has its own quota
+	other := httptest.NewRecorder()
+	handler(other, requestFrom("10.0.0.2"))
+	if other.Code != http.StatusOK {
+		t.Fatalf("other client = %d, want 200", other.Code)
+	}
+
+	// The next day starts a fresh window
+	clk.Advance(14 * time.Hour)
+	if w := send(); w.Code != http.StatusOK || w.Header().Get("X-Quota-Remaining") != "1" {
+		t.Fatalf("after the reset = %d, remaining %q; want 200, 1", w.Code, w.Header().Get("X-Quota-Remaining"))
+	}
+}
+
+func TestPruneQuotaWindows(t *testing.T) {
+	withConfig(t, func(c *Config) {
+		c.QuotaLimit = 5
+		c.QuotaPeriod = "day"
+	})
+	useTestDB(t)
+	clk := useMockClock(t)
+	ctx := requestFrom("10.0.0.1").Context()
+
+	for _, day := range []int{0, 1, 2} {
+		start, _ := quotaWindow(clk.Now().AddDate(0, 0, day))
+		if _, _, err := consumeQuota(ctx, "ip:10.0.0.1", 5, start); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pruneQuotaWindows(clk.Now().AddDate(0, 0, 2).Add(time.Hour))
+	var rows int
+	if err := db.QueryRow("SELECT COUNT(*) FROM api_quotas").Scan(&rows); err != nil {
+		t.Fatal(err)
+	}
+	if rows != 1 {
+		t.Fatalf("%d quota rows after pruning, want only the current window", rows)
+	}
+}
@@ -0,0 +1,534 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// useLimiter swaps the global bucket for the duration of the test.
+func useLimiter(t *testing.T, l *rate.Limiter) {
+	t.Helper()
+	prev := limiter
+	limiter = l
+	t.Cleanup(func() { limiter = prev })
+}
+
+// timedRequest carries a requestTiming like timingMiddleware would attach.
+func timedRequest(method, target string) (*http.Request, *requestTiming) {
+	t := &requestTiming{}
+	r := httptest.NewRequest(method, target, nil)
+	return r.WithContext(context.WithValue(r.Context(), timingKey{}, t)), t
+}
+
+func okHandler(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("ok"))
+}
+
+func TestThrottleFixedDelay(t *testing.T) {
+	withConfig(t, func(c *Config) {
+		c.ThrottleMinMs = 200
+		c.ThrottleMaxMs = 200
+		c.ThrottleTargetMs = 0
+	})
+	clk := useMockClock(t)
+
+	r, timing := timedRequest(http.MethodGet, "/api/db/messages")
+	w := httptest.NewRecorder()
+	throttleMiddleware(okHandler)(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", w.Code)
+	}
+	if got := clk.Slept(); got != 200*time.Millisecond {
+		t.Fatalf("slept %v, want 200ms", got)
+	}
+	if timing.throttle != 200*time.Millisecond {
+		t.Fatalf("timing.throttle = %v, want 200ms", timing.throttle)
+	}
+}
+
+func TestThrottleStopsWhenClientCancels(t *testing.T) {
+	withConfig(t, func(c *Config) {
+		c.ThrottleMinMs = 5000
+		c.ThrottleMaxMs = 5000
+	})
+
+	called := false
+	handler := throttleMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		okHandler(w, r)
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	r := httptest.NewRequest(http.MethodGet, "/api/get", nil).WithContext(ctx)
+
+	start := time.Now()
+	w := httptest.NewRecorder()
+	handler(w, r)
+	if called {
+		t.Fatal("handler ran for a client that went away during the delay")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("throttle held the request %v after the cancel", elapsed)
+	}
+	if w.Body.Len() != 0 {
+		t.Fatalf("wrote %q to a cancelled request", w.Body.String())
+	}
+}
+
+func TestThrottleSkipsOperationalRoutes(t *testing.T) {
+	withConfig(t, func(c *Config) {
+		c.ThrottleMinMs = 500
+		c.ThrottleMaxMs = 500
+	})
+	clk := useMockClock(t)
+
+	w := httptest.NewRecorder()
+	throttleMiddleware(okHandler)(w, httptest.NewRequest(http.MethodGet, "/health", nil))
+	if clk.Slept() != 0 {
+		t.Fatalf("operational route was throttled by %v", clk.Slept())
+	}
+}
+
+func TestThrottleToTargetPadsAfterHandler(t *testing.T) {
+	withConfig(t, func(c *Config) {
+		c.ThrottleTargetMs = 500
+	})
+	clk := useMockClock(t)
+
+	handlerDone := false
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		clk.Advance(100 * time.Millisecond)
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("created"))
+		handlerDone = true
+	}
+
+	r, timing := timedRequest(http.MethodPost, "/api/db/messages")
+	w := httptest.NewRecorder()
+	throttleMiddleware(handler)(w, r)
+
+	if !handlerDone {
+		t.Fatal("handler did not run")
+	}
+	if got := clk.Slept(); got != 400*time.Millisecond {
+		t.Fatalf("padding = %v, want 400ms", got)
+	}
+	if timing.throttle != 400*time.Millisecond {
+		t.Fatalf("timing.throttle = %v, want 400ms", timing.throttle)
+	}
+	if w.Code != http.StatusCreated || w.Body.String() != "created" {
+		t.Fatalf("response = %d %q, want 201 \"created\"", w.Code, w.Body.String())
+	}
+}
+
+func TestThrottleToTargetSlowHandlerNotPadded(t *testing.T) {
+	withConfig(t, func(c *Config) {
+		c.ThrottleTargetMs = 100
+	})
+	clk := useMockClock(t)
+
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		clk.Advance(300 * time.Millisecond)
+		okHandler(w, r)
+	}
+	throttleMiddleware(handler)(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/db/messages", nil))
+	if clk.Slept() != 0 {
+		t.Fatalf("slow handler padded by %v", clk.Slept())
+	}
+}
+
+func TestThrottleToTargetStreamingNotPadded(t *testing.T) {
+	withConfig(t, func(c *Config) {
+		c.ThrottleTargetMs = 500
+	})
+	clk := useMockClock(t)
+
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("data: 1\n\n"))
+		w.(http.Flusher).Flush()
+	}
+	w := httptest.NewRecorder()
+	throttleMiddleware(handler)(w, httptest.NewRequest(http.MethodGet, "/api/db/messages/events", nil))
+
+	if clk.Slept() != 0 {
+		t.Fatalf("streamed response padded by %v", clk.Slept())
+	}
+	if !w.Flushed || w.Body.String() != "data: 1\n\n" {
+		t.Fatalf("stream not passed through: flushed=%v body=%q", w.Flushed, w.Body.String())
+	}
+}
+
+func TestRateLimitRejectsWhenBucketEmpty(t *testing.T) {
+	withConfig(t, func(c *Config) {
+		c.RateLimitRequests = 1
+		c.RateLimitPeriod = 1
+		c.RateLimitMode = "reject"
+	})
+	clk := useMockClock(t)
+	useLimiter(t, rate.NewLimiter(1, 1))
+
+	handler := rateLimitMiddleware(okHandler)
+	first := httptest.NewRecorder()
+	handler(first, httptest.NewRequest(http.MethodGet, "/api/db/messages", nil))
+	if first.Code != http.StatusOK {
+		t.Fatalf("first request status = %d, want 200", first.Code)
+	}
+
+	second := httptest.NewRecorder()
+	handler(second, httptest.NewRequest(http.MethodGet, "/api/db/messages", nil))
+	if second.Code != http.StatusTooManyRequests {
+		t.Fatalf("second request status = %d, want 429", second.Code)
+	}
+	if got := second.Header().Get("Retry-After"); got != "1" {
+		t.Fatalf("Retry-After = %q, want \"1\"", got)
+	}
+
+	// The bucket refills on the mock clock, not wall time.
+	clk.Advance(time.Second)
+	third := httptest.NewRecorder()
+	handler(third, httptest.NewRequest(http.MethodGet, "/api/db/messages", nil))
+	if third.Code != http.StatusOK {
+		t.Fatalf("request after refill status = %d, want 200", third.Code)
+	}
+}
+
+func TestOperationalRoutesExemptFromRateLimit(t *testing.T) {
+	withConfig(t, func(c *Config) {
+		c.RateLimitRequests = 1
+		c.RateLimitMode = "reject"
+	})
+	useTestDB(t)
+	useMockClock(t)
+	srv := useRouter(t)
+	useLimiter(t, rate.NewLimiter(1, 1))
+
+	get := func(path string) int {
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	for _, path := range []string{"/health", "/metrics", "/readyz"} {
+		for i := 0; i < 5; i++ {
+			if code := get(path); code == http.StatusTooManyRequests {
+				t.Fatalf("%s request %d got 429 past RATE_LIMIT_REQUESTS", path, i+1)
+			}
+		}
+	}
+
+	// The bucket is still full: the exempt calls took no tokens
+	if code := get("/api/get"); code != http.StatusOK {
+		t.Fatalf("first API request: status = %d, want 200", code)
+	}
+	if code := get("/api/get"); code != http.StatusTooManyRequests {
+		t.Fatalf("second API request: status = %d, want 429", code)
+	}
+}
+
+func TestRateLimitWaitMode(t *testing.T) {
+	withConfig(t, func(c *Config) {
+		c.RateLimitRequests = 1
+		c.RateLimitPeriod = 1
+		c.RateLimitMode = "wait"
+		c.RateLimitMaxWaitMs = 2000
+	})
+	clk := useMockClock(t)
+	useLimiter(t, rate.NewLimiter(1, 1))
+
+	handler := rateLimitMiddleware(okHandler)
+	handler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/db/messages", nil))
+
+	r, timing := timedRequest(http.MethodGet, "/api/db/messages")
+	w := httptest.NewRecorder()
+	handler(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("waited request status = %d, want 200", w.Code)
+	}
+	if got := w.Header().Get("X-RateLimit-Waited-Ms"); got != "1000" {
+		t.Fatalf("X-RateLimit-Waited-Ms = %q, want \"1000\"", got)
+	}
+	if clk.Slept() != time.Second || timing.rateLimit != time.Second {
+		t.Fatalf("slept %v, timing.rateLimit %v, want 1s each", clk.Slept(), timing.rateLimit)
+	}
+}
+
+func TestRateLimitWaitBeyondMaxRejects(t *testing.T) {
+	withConfig(t, func(c *Config) {
+		c.RateLimitRequests = 1
+		c.RateLimitPeriod = 10
+		c.RateLimitMode = "wait"
+		c.RateLimitMaxWaitMs = 500
+	})
+	clk := useMockClock(t)
+	useLimiter(t, rate.NewLimiter(0.1, 1))
+
+	handler := rateLimitMiddleware(okHandler)
+	handler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/db/messages", nil))
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodGet, "/api/db/messages", nil))
+
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("status = %d, want 429", w.Code)
+	}
+	if clk.Slept() != 0 {
+		t.Fatalf("slept %v before rejecting", clk.Slept())
+	}
+}
+
+func TestRateLimitRejectionsLabelledByRoute(t *testing.T) {
+	withConfig(t, func(c *Config) {
+		c.RateLimitRequests = 1
+		c.RateLimitPeriod = 60
+		c.RateLimitMode = "reject"
+	})
+	useMockClock(t)
+	useLimiter(t, rate.NewLimiter(rate.Every(time.Minute), 1))
+	useKnownRoute(t, "/api/db/messages")
+	useKnownRoute(t, "/api/get")
+
+	rejected, other := vecValue(rateLimitRejections, "POST", "/api/db/messages"), vecValue(rateLimitRejections, "GET", "/api/get")
+	handler := rateLimitMiddleware(okHandler)
+	handler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/get", nil))
+
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodPost, "/api/db/messages", nil))
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("status = %d, want 429", w.Code)
+	}
+	if got := vecValue(rateLimitRejections, "POST", "/api/db/messages"); got != rejected+1 {
+		t.Fatalf(`ratelimit_rejections_total{method="POST",path="/api/db/messages"} = %d, want %d`, got, rejected+1)
+	}
+	// The request that got through is not counted under its own route
+	if got := vecValue(rateLimitRejections, "GET", "/api/get"); got != other {
+		t.Fatalf(`ratelimit_rejections_total{method="GET",path="/api/get"} = %d, want %d`, got, other)
+	}
+
+	// Unregistered paths share the "other" label instead of adding series
+	before := vecValue(rateLimitRejections, "GET", "other")
+	handler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/no-such-route", nil))
+	if got := vecValue(rateLimitRejections, "GET", "other"); got != before+1 {
+		t.Fatalf(`ratelimit_rejections_total{method="GET",path="other"} = %d, want %d`, got, before+1)
+	}
+}
+
+func TestRateLimitStartEmpty(t *testing.T) {
+	for _, startEmpty := range []bool{false, true} {
+		withConfig(t, func(c *Config) {
+			c.RateLimitRequests = 5
+			c.RateLimitPeriod = 5 // one token a second
+			c.RateLimitMode = "reject"
+			c.RateLimitStartEmpty = startEmpty
+		})
+		clk := useMockClock(t)
+		useLimiter(t, newGlobalLimiter())
+		handler := rateLimitMiddleware(okHandler)
+
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest(http.MethodGet, "/api/db/messages", nil))
+		want := http.StatusOK
+		if startEmpty {
+			want = http.StatusTooManyRequests
+		}
+		if w.Code != want {
+			t.Fatalf("RATE_LIMIT_START_EMPTY=%v: first request = %d, want %d", startEmpty, w.Code, want)
+		}
+		if !startEmpty {
+			continue
+		}
+
+		// Tokens arrive at the configured rate, not as a full burst
+		clk.Advance(time.Second)
+		for i, want := range []int{http.StatusOK, http.StatusTooManyRequests} {
+			w := httptest.NewRecorder()
+			handler(w, httptest.NewRequest(http.MethodGet, "/api/db/messages", nil))
+			if w.Code != want {
+				t.Fatalf("request %d one second in = %d, want %d", i+1, w.Code, want)
+			}
+		}
+	}
+}
+
+func TestThrottleMethodMultipliers(t *testing.T) {
+	withConfig(t, func(c *Config) {
+		c.ThrottleMinMs = 100
+		c.ThrottleMaxMs = 100
+		c.ThrottleTargetMs = 0
+		c.ThrottleMultipliers = map[string]float64{http.MethodGet: 0.5, http.MethodPost: 2, http.MethodDelete: 0}
+	})
+	tests := []struct {
+		method string
+		want   time.Duration
+	}{
+		{http.MethodGet, 50 * time.Millisecond},
+		{http.MethodPost, 200 * time.Millisecond},
+		{http.MethodDelete, 0},
+		{http.MethodPut, 100 * time.Millisecond}, // no multiplier: 1.0
+	}
+	for _, tt := range tests {
+		clk := useMockClock(t)
+		r, _ := timedRequest(tt.method, "/api/db/messages")
+		throttleMiddleware(okHandler)(httptest.NewRecorder(), r)
+		if got := clk.Slept(); got != tt.want {
+			t.Errorf("%s slept %v, want %v", tt.method, got, tt.want)
+		}
+	}
+}
+
+func TestParseMethodMultipliers(t *testing.T) {
+	t.Setenv("THROTTLE_GET_MULTIPLIER", "0.25")
+	t.Setenv("THROTTLE_POST_MULTIPLIER", "3")
+	t.Setenv("THROTTLE_PUT_MULTIPLIER", "-1")
+	t.Setenv("THROTTLE_PATCH_MULTIPLIER", "fast")
+
+	got := parseMethodMultipliers()
+	if len(got) != 2 || got[http.MethodGet] != 0.25 || got[http.MethodPost] != 3 {
+		t.Fatalf("parseMethodMultipliers = %v, want GET 0.25 and POST 3 only", got)
+	}
+}
+
+func TestRateLimitVerboseBody(t *testing.T) {
+	for _, verbose := range []bool{false, true} {
+		withConfig(t, func(c *Config) {
+			c.RateLimitRequests = 10
+			c.RateLimitPeriod = 60
+			c.RateLimitMode = "reject"
+			c.RateLimitVerboseBody = verbose
+		})
+		useMockClock(t)
+		useLimiter(t, rate.NewLimiter(rate.Every(6*time.Second), 1))
+		handler := rateLimitMiddleware(okHandler)
+		handler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/db/messages", nil))
+
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest(http.MethodGet, "/api/db/messages", nil))
+		if w.Code != http.StatusTooManyRequests {
+			t.Fatalf("status = %d, want 429", w.Code)
+		}
+		body := decodeBody(t, w)
+		if body["error"] == nil {
+			t.Fatalf("429 body lacks error: %v", body)
+		}
+		if !verbose {
+			if len(body) != 1 {
+				t.Fatalf("terse 429 body = %v, want only the error", body)
+			}
+			continue
+		}
+		want := map[string]interface{}{"policy": "global", "limit": float64(10), "period_seconds": float64(60), "retry_after_seconds": float64(6)}
+		for k, v := range want {
+			if body[k] != v {
+				t.Errorf("%s = %v, want %v", k, body[k], v)
+			}
+		}
+		if got := w.Header().Get("Retry-After"); got != "6" {
+			t.Errorf("Retry-After = %s, want 6 like the body", got)
+		}
+	}
+}
+
+// useDBLatency pins the DB latency average the throttle adapts to.
+func useDBLatency(t *testing.T, d time.Duration) {
+	t.Helper()
+	prev := atomic.SwapInt64(&dbLatencyEWMA, int64(d))
+	t.Cleanup(func() { atomic.StoreInt64(&dbLatencyEWMA, prev) })
+}
+
+func TestAdaptThrottleToDB(t *testing.T) {
+	tests := []struct {
+		thresholdMs int
+		latency     time.Duration
+		want        time.Duration
+	}{
+		{0, time.Second, 300 * time.Millisecond},             // feature off
+		{50, 20 * time.Millisecond, 300 * time.Millisecond},  // DB under the threshold
+		{50, 150 * time.Millisecond, 200 * time.Millisecond}, // 100ms excess taken off
+		{50, 500 * time.Millisecond, 0},                      // excess beyond the delay
+		{50, 350 * time.Millisecond, 0},                      // excess equal to the delay
+	}
+	for _, tt := range tests {
+		withConfig(t, func(c *Config) { c.ThrottleDBLatencyMs = tt.thresholdMs })
+		useDBLatency(t, tt.latency)
+		if got := adaptThrottleToDB(300 * time.Millisecond); got != tt.want {
+			t.Errorf("threshold %dms, DB at %v: delay %v, want %v", tt.thresholdMs, tt.latency, got, tt.want)
+		}
+	}
+}
+
+func TestThrottleShrinksWithSlowDB(t *testing.T) {
+	withConfig(t, func(c *Config) {
+		c.ThrottleMinMs = 300
+		c.ThrottleMaxMs = 300
+		c.ThrottleTargetMs = 0
+		c.ThrottleDBLatencyMs = 50
+	})
+	useDBLatency(t, 150*time.Millisecond)
+	clk := useMockClock(t)
+
+	r, _ := timedRequest(http.MethodGet, "/api/db/messages")
+	throttleMiddleware(okHandler)(httptest.NewRecorder(), r)
+	if got := clk.Slept(); got != 200*time.Millisecond {
+		t.Fatalf("slept %v, want 300ms less the 100ms DB excess", got)
+	}
+}
+
+func TestParseThrottleOverrides(t *testing.T) {
+	got := parseThrottleOverrides("ip:10.0.0.5=500-1000, key:batch=200, ip:10.0.0.6=9-3, host:x=10, key:neg=-5, key:none")
+	want := map[string]throttleSpan{
+		"ip:10.0.0.5": {minMs: 500, maxMs: 1000},
+		"key:batch":   {minMs: 200, maxMs: 200},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("parseThrottleOverrides = %v, want %v", got, want)
+	}
+}
+
+func TestThrottleClientOverrides(t *testing.T) {
+	withConfig(t, func(c *Config) {
+		c.ThrottleMinMs = 100
+		c.ThrottleMaxMs = 100
+		c.ThrottleTargetMs = 300 // overridden clients skip the target too
+		c.RateLimitKeyHeader = "X-API-Key"
+		c.ThrottleOverrides = parseThrottleOverrides("ip:10.0.0.5=40,key:batch=0,key:slow=700")
+	})
+	tests := []struct {
+		name string
+		ip   string
+		key  string
+		want time.Duration
+	}{
+		{"ip override", "10.0.0.5", "", 40 * time.Millisecond},
+		{"key override", "10.0.0.9", "batch", 0},
+		{"key wins over ip", "10.0.0.5", "slow", 700 * time.Millisecond},
+		{"key without override falls back to ip", "10.0.0.5", "other", 40 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		clk := useMockClock(t)
+		r := requestFrom(tt.ip)
+		if tt.key != "" {
+			r.Header.Set("X-API-Key", tt.key)
+		}
+		throttleMiddleware(okHandler)(httptest.NewRecorder(), r)
+		if got := clk.Slept(); got != tt.want {
+			t.Errorf("%s: slept %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	// Everyone else gets the global THROTTLE_TARGET_MS padding
+	clk := useMockClock(t)
+	throttleMiddleware(okHandler)(httptest.NewRecorder(), requestFrom("10.0.0.9"))
+	if got := clk.Slept(); got != 300*time.Millisecond {
+		t.Fatalf("client without an override slept %v, want the 300ms target", got)
+	}
+}
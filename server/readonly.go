@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Read-only failover: when Postgres starts refusing writes (e.g. the primary
+// was demoted during a failover), writes get a clear 503 while reads keep
+// being served. A background probe flips the server back once the database
+// accepts writes again.
+
+var (
+	readOnlyMode     atomic.Bool
+	readOnlyProbing  atomic.Bool
+	readOnlySince    atomic.Int64 // unix nanoseconds
+	readOnlyFailover = newCounter("db_read_only_transitions_total", "Times the server switched to read-only mode after a write failure.")
+)
+
+// isReadOnlyError reports whether err means the database rejects writes.
+func isReadOnlyError(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "25006" // read_only_sql_transaction
+}
+
+// noteWriteError switches to read-only mode on a read-only rejection and
+// reports whether it did so, so callers can stop retrying.
+func noteWriteError(err error) bool {
+	if !config.ReadOnlyFailover || !isReadOnlyError(err) {
+		return false
+	}
+	if readOnlyMode.CompareAndSwap(false, true) {
+		readOnlySince.Store(time.Now().UnixNano())
+		readOnlyFailover.inc()
+		log.Printf("[READONLY] Database rejected a write (%v), switching to read-only mode", err)
+		startReadOnlyProbe()
+	}
+	return true
+}
+
+func startReadOnlyProbe() {
+	if !readOnlyProbing.CompareAndSwap(false, true) {
+		return
+	}
+	go func() {
+		defer readOnlyProbing.Store(false)
+		interval := time.Duration(config.ReadOnlyRecheckMs) * time.Millisecond
+		for readOnlyMode.Load() {
+			time.Sleep(interval)
+			var readOnly string
+			if err := db.QueryRow("SHOW transaction_read_only").Scan(&readOnly); err != nil {
+				log.Printf("[READONLY] Recovery probe failed: %v", err)
+				continue
+			}
+			if readOnly == "off" {
+				readOnlyMode.Store(false)
+				log.Printf("[READONLY] Database accepts writes again, leaving read-only mode after %v",
+					time.Since(time.Unix(0, readOnlySince.Load())))
+			}
+		}
+	}()
+}
+
+// readOnlyMiddleware rejects writes while in read-only mode.
+func readOnlyMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if readOnlyMode.Load() && !isReadMethod(r.Method) {
+			writeReadOnly(w, r)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// writeReadOnly is the 503 for writes refused in read-only mode, also used
+// by the write that first runs into it.
+func writeReadOnly(w http.ResponseWriter, r *http.Request) {
+	retryAfter := (config.ReadOnlyRecheckMs + 999) / 1000
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	writeJSON(w, r, http.StatusServiceUnavailable, map[string]interface{}{
+		"error":     "Database is in read-only mode. Writes are temporarily unavailable; reads are still served.",
+		"read_only": true,
+	})
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+// useReadOnlyState resets read-only mode around the test. The recovery
+// probe is marked as already running so it never starts against the fake
+// database.
+func useReadOnlyState(t *testing.T) {
+	t.Helper()
+	readOnlyMode.Store(false)
+	readOnlyProbing.Store(true)
+	t.Cleanup(func() {
+		readOnlyMode.Store(false)
+		readOnlyProbing.Store(false)
+	})
+}
+
+func TestFirstReadOnlyWriteGets503(t *testing.T) {
+	withConfig(t, func(c *Config) {
+		c.ReadOnlyFailover = true
+		c.ReadOnlyRecheckMs = 5000
+		c.DBWriteRetries = 3
+		c.DBWriteRetryDelayMs = 0
+	})
+	useReadOnlyState(t)
+	fake := useFakeDB(t, &pq.Error{Code: "25006", Message: "cannot execute INSERT in a read-only transaction"})
+
+	r := httptest.NewRequest(http.MethodPost, "/api/db/messages", strings.NewReader(`{"content":"hi"}`))
+	w := httptest.NewRecorder()
+	dbPostHandler(w, r)
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want 503 (%s)", w.Code, w.Body.String())
+	}
+	if got := w.Header().Get("Retry-After"); got != "5" {
+		t.Fatalf("Retry-After = %q, want \"5\"", got)
+	}
+	if !readOnlyMode.Load() {
+		t.Fatal("read-only mode not entered")
+	}
+	if n := fake.count("INSERT INTO messages"); n != 1 {
+		t.Fatalf("insert attempted %d times, want 1 (no retries against a read-only database)", n)
+	}
+	if n := fake.count("failed_writes"); n != 0 {
+		t.Fatal("read-only write was dead-lettered")
+	}
+}
+
+func TestReadOnlyMiddlewareRejectsWritesOnly(t *testing.T) {
+	withConfig(t, func(c *Config) { c.ReadOnlyRecheckMs = 1000 })
+	useReadOnlyState(t)
+	readOnlyMode.Store(true)
+
+	handler := readOnlyMiddleware(okHandler)
+	for method, want := range map[string]int{
+		http.MethodGet:    http.StatusOK,
+		http.MethodPost:   http.StatusServiceUnavailable,
+		http.MethodDelete: http.StatusServiceUnavailable,
+	} {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest(method, "/api/db/messages", nil))
+		if w.Code != want {
+			t.Errorf("%s status = %d, want %d", method, w.Code, want)
+		}
+	}
+}
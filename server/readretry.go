@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// isConnectionError reports failures of the connection itself (reset,
+// refused, server restart) as opposed to errors in the query. Only those
+// are worth retrying: the broken connection is discarded by the pool, so
+// the next attempt runs on a different one.
+func isConnectionError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		// context errors satisfy net.Error, but retrying can't help
+		return false
+	}
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		// Class 08 (connection exception) and the shutdown family 57P0x
+		return strings.HasPrefix(string(pqErr.Code), "08") || strings.HasPrefix(string(pqErr.Code), "57P0")
+	}
+	return false
+}
+
+// retryRead runs read once plus up to READ_RETRIES more times while it
+// keeps failing with connection-level errors.
+func retryRead[T any](name string, read func() (T, error)) (T, error) {
+	result, err := read()
+	for attempt := 1; attempt <= config.ReadRetries && isConnectionError(err); attempt++ {
+		log.Printf("[DB] %s failed on connection error, retry %d/%d: %v", name, attempt, config.ReadRetries, err)
+		result, err = read()
+	}
+	return result, err
+}
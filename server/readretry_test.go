@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+func TestIsConnectionError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{driver.ErrBadConn, true},
+		{fmt.Errorf("query: %w", io.ErrUnexpectedEOF), true},
+		{&pq.Error{Code: "57P01"}, true},  // admin_shutdown
+		{&pq.Error{Code: "08006"}, true},  // connection_failure
+		{&pq.Error{Code: "42P01"}, false}, // undefined_table
+		{context.DeadlineExceeded, false},
+		{errors.New("syntax error"), false},
+	}
+	for _, tt := range tests {
+		if got := isConnectionError(tt.err); got != tt.want {
+			t.Errorf("isConnectionError(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+// flakyRead fails with errs in order, then succeeds.
+func flakyRead(errs ...error) (func() (string, error), *int) {
+	calls := 0
+	return func() (string, error) {
+		calls++
+		if calls <= len(errs) {
+			return "", errs[calls-1]
+		}
+		return "rows", nil
+	}, &calls
+}
+
+func TestRetryReadRecoversFromTransientError(t *testing.T) {
+	withConfig(t, func(c *Config) { c.ReadRetries = 2 })
+	read, calls := flakyRead(driver.ErrBadConn, io.EOF)
+
+	got, err := retryRead("test read", read)
+	if err != nil || got != "rows" {
+		t.Fatalf("retryRead = %q, %v; want the rows from the third attempt", got, err)
+	}
+	if *calls != 3 {
+		t.Fatalf("%d attempts, want 3", *calls)
+	}
+}
+
+func TestRetryReadGivesUp(t *testing.T) {
+	withConfig(t, func(c *Config) { c.ReadRetries = 2 })
+
+	// Still failing after READ_RETRIES: the last error comes back
+	read, calls := flakyRead(driver.ErrBadConn, driver.ErrBadConn, driver.ErrBadConn, driver.ErrBadConn)
+	if _, err := retryRead("test read", read); !errors.Is(err, driver.ErrBadConn) {
+		t.Fatalf("err = %v, want ErrBadConn", err)
+	}
+	if *calls != 3 {
+		t.Fatalf("%d attempts, want 1 plus 2 retries", *calls)
+	}
+
+	// A query error is not retried at all
+	read, calls = flakyRead(&pq.Error{Code: "42P01"})
+	if _, err := retryRead("test read", read); err == nil {
+		t.Fatal("query error swallowed")
+	}
+	if *calls != 1 {
+		t.Fatalf("%d attempts on a query error, want 1", *calls)
+	}
+}
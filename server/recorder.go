@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+)
+
+// statusRecorder captures the status code and body size written by the
+// wrapped handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	bytes       int
+	wroteHeader bool
+
+	// beforeWrite, if set, runs once just before the first header or body
+	// byte reaches the client.
+	beforeWrite func()
+}
+
+func newStatusRecorder(w http.ResponseWriter) *statusRecorder {
+	return &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	if !s.wroteHeader {
+		s.fire()
+		s.status = status
+		s.wroteHeader = true
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(p []byte) (int, error) {
+	if !s.wroteHeader {
+		s.fire()
+	}
+	s.wroteHeader = true
+	n, err := s.ResponseWriter.Write(p)
+	s.bytes += n
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+// (deadlines, flushing).
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+func (s *statusRecorder) Flush() {
+	if !s.wroteHeader {
+		s.fire()
+	}
+	http.NewResponseController(s.ResponseWriter).Flush()
+}
+
+func (s *statusRecorder) fire() {
+	if f := s.beforeWrite; f != nil {
+		s.beforeWrite = nil
+		f()
+	}
+}
+
+// bufferedResponse holds the handler's status and body so a middleware can
+// act after the handler returns and before anything reaches the client.
+// A Flush means the handler is streaming; from then on writes go straight
+// through.
+type bufferedResponse struct {
+	http.ResponseWriter
+	status    int
+	body      bytes.Buffer
+	streaming bool
+}
+
+func newBufferedResponse(w http.ResponseWriter) *bufferedResponse {
+	return &bufferedResponse{ResponseWriter: w}
+}
+
+func (b *bufferedResponse) WriteHeader(status int) {
+	if b.streaming {
+		b.ResponseWriter.WriteHeader(status)
+		return
+	}
+	if b.status == 0 {
+		b.status = status
+	}
+}
+
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	if b.streaming {
+		return b.ResponseWriter.Write(p)
+	}
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	return b.body.Write(p)
+}
+
+func (b *bufferedResponse) Unwrap() http.ResponseWriter {
+	return b.ResponseWriter
+}
+
+func (b *bufferedResponse) Flush() {
+	b.flush()
+	b.streaming = true
+	http.NewResponseController(b.ResponseWriter).Flush()
+}
+
+// flush sends what was buffered. Handlers that wrote nothing get the
+// implicit 200.
+func (b *bufferedResponse) flush() {
+	if b.streaming {
+		return
+	}
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	b.ResponseWriter.WriteHeader(b.status)
+	b.ResponseWriter.Write(b.body.Bytes())
+	b.body.Reset()
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"runtime/debug"
+)
+
+type requestIDKey struct{}
+
+func requestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// requestIDMiddleware reuses the caller's X-Request-ID (up to 128 bytes) or
+// generates one, echoes it on the response and stores it in the context.
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if id == "" || len(id) > 128 {
+			id = newRequestID()
+		}
+		w.Header().Set("X-Request-ID", id)
+		ctx := context.WithValue(r.Context(), requestIDKey{}, id)
+		next.ServeHTTP(w, r.WithContext(withQueryEndpoint(ctx, r)))
+	})
+}
+
+// panicReport is logged as a single JSON line so log pipelines can index
+// panics by path and request ID.
+type panicReport struct {
+	Event     string `json:"event"`
+	Method    string `json:"method"`
+	Path      string `json:"path"`
+	RequestID string `json:"request_id"`
+	Panic     string `json:"panic"`
+	Stack     string `json:"stack"`
+}
+
+// recoveryMiddleware turns a handler panic into a 500 and a structured
+// report instead of a dropped connection. http.ErrAbortHandler is re-raised:
+// it is net/http's way of deliberately aborting a response.
+func recoveryMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := newStatusRecorder(w)
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				panic(v)
+			}
+
+			panicsRecovered.inc()
+			report, _ := json.Marshal(panicReport{
+				Event:     "panic",
+				Method:    r.Method,
+				Path:      r.URL.Path,
+				RequestID: requestIDFrom(r.Context()),
+				Panic:     fmt.Sprint(v),
+				Stack:     string(debug.Stack()),
+			})
+			log.Printf("[PANIC] %s", report)
+
+			if !rec.wroteHeader {
+				writeJSON(rec, r, http.StatusInternalServerError, map[string]string{
+					"error":      "Internal server error",
+					"request_id": requestIDFrom(r.Context()),
+				})
+			}
+		}()
+		next.ServeHTTP(rec, r)
+	})
+}
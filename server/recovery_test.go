@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRecoveryTurnsPanicInto500(t *testing.T) {
+	withConfig(t, nil)
+	logs := captureLog(t)
+
+	handler := requestIDMiddleware(recoveryMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})))
+	r := httptest.NewRequest(http.MethodGet, "/api/get", nil)
+	r.Header.Set("X-Request-ID", "req-42")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	if w.Code != http.StatusInternalServerError || !strings.Contains(w.Body.String(), `"request_id":"req-42"`) {
+		t.Fatalf("got %d %s, want 500 with the request id", w.Code, w.Body.String())
+	}
+
+	line := logs.String()
+	i := strings.Index(line, "[PANIC] ")
+	if i < 0 {
+		t.Fatalf("no panic report logged:\n%s", line)
+	}
+	var report panicReport
+	if err := json.Unmarshal([]byte(strings.TrimSpace(line[i+len("[PANIC] "):])), &report); err != nil {
+		t.Fatalf("panic report is not JSON: %v", err)
+	}
+	if report.Panic != "boom" || report.Path != "/api/get" || report.RequestID != "req-42" || report.Stack == "" {
+		t.Fatalf("report = %+v", report)
+	}
+}
+
+func TestRecoveryKeepsWrittenStatus(t *testing.T) {
+	withConfig(t, nil)
+	captureLog(t)
+
+	handler := recoveryMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+		panic("late")
+	}))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusAccepted || w.Body.Len() != 0 {
+		t.Fatalf("got %d %q, want the 202 already sent and no error body", w.Code, w.Body.String())
+	}
+}
+
+func TestRecoveryReraisesAbortHandler(t *testing.T) {
+	handler := recoveryMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	}))
+	defer func() {
+		if v := recover(); v != http.ErrAbortHandler {
+			t.Fatalf("recovered %v, want http.ErrAbortHandler", v)
+		}
+	}()
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+}
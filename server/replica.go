@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Read replica (DB_REPLICA_URL) with read-your-writes pinning: a client
+// that wrote within READ_YOUR_WRITES_MS reads from the primary, since the
+// replica may not have replayed its write yet. Everyone else reads from
+// the replica.
+
+var replicaDB *sql.DB
+
+func initReplicaDB(url string) error {
+	conn, err := sql.Open("postgres", url)
+	if err != nil {
+		return err
+	}
+	conn.SetMaxOpenConns(50)
+	if err := conn.Ping(); err != nil {
+		conn.Close()
+		return err
+	}
+	replicaDB = conn
+	return nil
+}
+
+type writePins struct {
+	mu        sync.Mutex
+	lastWrite map[string]time.Time
+	lastSweep time.Time
+}
+
+var recentWriters = &writePins{lastWrite: make(map[string]time.Time)}
+
+func (p *writePins) pin(key string, now time.Time) {
+	window := time.Duration(config.ReadYourWritesMs) * time.Millisecond
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.lastWrite[key] = now
+	if now.Sub(p.lastSweep) > window {
+		for k, at := range p.lastWrite {
+			if now.Sub(at) > window {
+				delete(p.lastWrite, k)
+			}
+		}
+		p.lastSweep = now
+	}
+}
+
+func (p *writePins) pinned(key string, now time.Time) bool {
+	window := time.Duration(config.ReadYourWritesMs) * time.Millisecond
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	at, ok := p.lastWrite[key]
+	return ok && now.Sub(at) <= window
+}
+
+type primaryReadsKey struct{}
+
+// readsFromPrimary reports whether reads for this request must skip the
+// replica (and the shared messages cache, which may hold replica rows).
+func readsFromPrimary(ctx context.Context) bool {
+	pinned, _ := ctx.Value(primaryReadsKey{}).(bool)
+	return pinned
+}
+
+// readConn is the pool reads for ctx should use.
+func readConn(ctx context.Context) *sql.DB {
+	if replicaDB == nil || readsFromPrimary(ctx) {
+		return db
+	}
+	return replicaDB
+}
+
+// consistencyMiddleware pins clients to the primary after a successful
+// write and marks their reads while the pin lasts.
+func consistencyMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if replicaDB == nil || config.ReadYourWritesMs <= 0 {
+			next(w, r)
+			return
+		}
+
+		key := clientKey(r)
+		if isReadMethod(r.Method) {
+			if recentWriters.pinned(key, clock.Now()) {
+				r = r.WithContext(context.WithValue(r.Context(), primaryReadsKey{}, true))
+			}
+			next(w, r)
+			return
+		}
+
+		rec := newStatusRecorder(w)
+		next(rec, r)
+		if rec.status < 400 {
+			recentWriters.pin(key, clock.Now())
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// useReplica points replicaDB at a pool distinct from db, with an empty
+// set of write pins.
+func useReplica(t *testing.T) *sql.DB {
+	t.Helper()
+	replicaDB = sql.OpenDB(&fakeDB{})
+	recentWriters = &writePins{lastWrite: make(map[string]time.Time)}
+	t.Cleanup(func() {
+		replicaDB.Close()
+		replicaDB = nil
+		recentWriters = &writePins{lastWrite: make(map[string]time.Time)}
+	})
+	return replicaDB
+}
+
+// readPool sends a GET from ip through consistencyMiddleware and returns the
+// pool the handler would read from.
+func readPool(ip string) *sql.DB {
+	var conn *sql.DB
+	consistencyMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		conn = readConn(r.Context())
+	})(httptest.NewRecorder(), requestFrom(ip))
+	return conn
+}
+
+// writeFrom sends a POST from ip answered with status.
+func writeFrom(ip string, status int) {
+	r := httptest.NewRequest(http.MethodPost, "/api/db/messages", nil)
+	r.RemoteAddr = ip + ":40000"
+	consistencyMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	})(httptest.NewRecorder(), r)
+}
+
+func TestReadYourWritesPinsToPrimary(t *testing.T) {
+	withConfig(t, func(c *Config) { c.ReadYourWritesMs = 2000 })
+	useTestDB(t)
+	replica := useReplica(t)
+	c := useMockClock(t)
+
+	if got := readPool("10.0.0.1"); got != replica {
+		t.Fatal("read before any write did not use the replica")
+	}
+
+	writeFrom("10.0.0.1", http.StatusCreated)
+	c.Advance(1500 * time.Millisecond)
+	if got := readPool("10.0.0.1"); got != db {
+		t.Fatal("read 1.5s after a write did not use the primary")
+	}
+	if got := readPool("10.0.0.2"); got != replica {
+		t.Fatal("another client's read was pinned to the primary")
+	}
+
+	c.Advance(time.Second)
+	if got := readPool("10.0.0.1"); got != replica {
+		t.Fatal("read after the window did not go back to the replica")
+	}
+}
+
+func TestReadYourWritesIgnoresFailedWrites(t *testing.T) {
+	withConfig(t, func(c *Config) { c.ReadYourWritesMs = 2000 })
+	useTestDB(t)
+	replica := useReplica(t)
+	useMockClock(t)
+
+	writeFrom("10.0.0.1", http.StatusUnprocessableEntity)
+	if got := readPool("10.0.0.1"); got != replica {
+		t.Fatal("a rejected write pinned the client to the primary")
+	}
+}
+
+func TestReadYourWritesOff(t *testing.T) {
+	withConfig(t, func(c *Config) { c.ReadYourWritesMs = 0 })
+	useTestDB(t)
+	replica := useReplica(t)
+
+	writeFrom("10.0.0.1", http.StatusCreated)
+	if got := readPool("10.0.0.1"); got != replica {
+		t.Fatal("read pinned to the primary with READ_YOUR_WRITES_MS=0")
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// writeJSON sends v as the JSON response body with the given status.
+// Output is compact unless PRETTY_JSON is set or the request asks for
+// ?pretty=true, keeping the hot path cheap by default.
+func writeJSON(w http.ResponseWriter, r *http.Request, status int, v interface{}) {
+	if config.ResponseEnvelope == "wrapped" {
+		v = wrapEnvelope(status, v)
+	}
+	if config.JSONCase == "camel" {
+		if converted, err := camelCaseKeys(v); err == nil {
+			v = converted
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	enc := json.NewEncoder(w)
+	if wantsPrettyJSON(r) {
+		enc.SetIndent("", "  ")
+	}
+	if err := enc.Encode(v); err != nil {
+		// Headers are already sent, so the client can't be told; record it
+		jsonEncodeErrors.inc()
+		path := ""
+		if r != nil {
+			path = r.URL.Path
+		}
+		log.Printf("[RESPONSE] Failed to encode JSON response (status %d, path %q): %v", status, path, err)
+	}
+}
+
+// internalError builds the body for a failed DB operation. The underlying
+// error is always logged under an error_id; the response carries the id
+// and, only with ERROR_VERBOSITY=debug, the error text itself.
+func internalError(r *http.Request, message string, err error) map[string]interface{} {
+	id := requestIDFrom(r.Context())
+	if id == "" {
+		id = newRequestID()
+	}
+	log.Printf("[ERROR] %s %s: %s (error_id=%s): %v", r.Method, r.URL.Path, message, id, err)
+
+	body := map[string]interface{}{
+		"error":    message,
+		"error_id": id,
+	}
+	if config.ErrorVerbosity == "debug" {
+		body["detail"] = err.Error()
+	}
+	return body
+}
+
+func wantsPrettyJSON(r *http.Request) bool {
+	if config.PrettyJSON {
+		return true
+	}
+	return r != nil && r.URL.Query().Get("pretty") == "true"
+}
+
+// envelope is the RESPONSE_ENVELOPE=wrapped shape. Successful responses
+// carry the payload in data; error responses carry it in errors.
+type envelope struct {
+	Data   interface{}   `json:"data"`
+	Meta   envelopeMeta  `json:"meta"`
+	Errors []interface{} `json:"errors"`
+}
+
+type envelopeMeta struct {
+	Status int `json:"status"`
+}
+
+func wrapEnvelope(status int, v interface{}) envelope {
+	env := envelope{Meta: envelopeMeta{Status: status}, Errors: []interface{}{}}
+	if status < 400 {
+		env.Data = v
+		return env
+	}
+
+	// Validation failures already are a list; spread them instead of nesting
+	if m, ok := v.(map[string]interface{}); ok {
+		if errs, ok := m["errors"].([]fieldError); ok {
+			for _, e := range errs {
+				env.Errors = append(env.Errors, e)
+			}
+			return env
+		}
+	}
+	env.Errors = append(env.Errors, v)
+	return env
+}
+
+// verbatim marks client-supplied JSON, such as the /api/post echo, whose
+// keys JSON_CASE must leave as the client sent them.
+type verbatim struct{ v interface{} }
+
+func (v verbatim) MarshalJSON() ([]byte, error) {
+	return json.Marshal(v.v)
+}
+
+// marshalPayload encodes v for the bodies writeJSON doesn't send, SSE events
+// and webhook calls, with the same JSON_CASE as HTTP responses.
+func marshalPayload(v interface{}) ([]byte, error) {
+	if config.JSONCase == "camel" {
+		if converted, err := camelCaseKeys(v); err == nil {
+			v = converted
+		}
+	}
+	return json.Marshal(v)
+}
+
+// camelCaseKeys re-encodes v with every server-owned object key converted
+// from snake_case to camelCase. Maps and envelopes are walked so verbatim
+// values inside them are kept apart; anything else goes through a generic
+// tree, which covers structs without a second set of struct tags.
+func camelCaseKeys(v interface{}) (interface{}, error) {
+	switch t := v.(type) {
+	case verbatim:
+		return t, nil
+	case envelope:
+		return camelCaseKeys(map[string]interface{}{"data": t.Data, "meta": t.Meta, "errors": t.Errors})
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(t))
+		for k, val := range t {
+			converted, err := camelCaseKeys(val)
+			if err != nil {
+				return nil, err
+			}
+			out[snakeToCamel(k)] = converted
+		}
+		return out, nil
+	case []interface{}:
+		out := make([]interface{}, len(t))
+		for i, val := range t {
+			converted, err := camelCaseKeys(val)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = converted
+		}
+		return out, nil
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber() // keep ids and counts exactly as encoded
+	var tree interface{}
+	if err := dec.Decode(&tree); err != nil {
+		return nil, err
+	}
+	return camelizeTree(tree), nil
+}
+
+func camelizeTree(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(t))
+		for k, val := range t {
+			out[snakeToCamel(k)] = camelizeTree(val)
+		}
+		return out
+	case []interface{}:
+		for i := range t {
+			t[i] = camelizeTree(t[i])
+		}
+		return t
+	default:
+		return v
+	}
+}
+
+func snakeToCamel(s string) string {
+	if !strings.Contains(s, "_") {
+		return s
+	}
+	var b strings.Builder
+	upper := false
+	for _, c := range s {
+		if c == '_' {
+			upper = b.Len() > 0
+			continue
+		}
+		if upper {
+			b.WriteString(strings.ToUpper(string(c)))
+			upper = false
+		} else {
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestJSONCase(t *testing.T) {
+	msg := Message{ID: 1, Content: "hi", ContentType: "text/plain", CreatedAt: time.Unix(0, 0).UTC()}
+	tests := []struct {
+		jsonCase string
+		want     []string
+		absent   string
+	}{
+		{"snake", []string{`"content_type":"text/plain"`, `"created_at":`, `"error_id":"x"`}, "contentType"},
+		{"camel", []string{`"contentType":"text/plain"`, `"createdAt":`, `"errorId":"x"`}, "content_type"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.jsonCase, func(t *testing.T) {
+			withConfig(t, func(c *Config) { c.JSONCase = tt.jsonCase })
+			w := httptest.NewRecorder()
+			writeJSON(w, nil, http.StatusOK, map[string]interface{}{"data": msg, "error_id": "x"})
+			for _, want := range tt.want {
+				if !strings.Contains(w.Body.String(), want) {
+					t.Errorf("body %s lacks %s", w.Body.String(), want)
+				}
+			}
+			if strings.Contains(w.Body.String(), tt.absent) {
+				t.Errorf("body %s still has %s", w.Body.String(), tt.absent)
+			}
+		})
+	}
+}
+
+func TestJSONCaseKeepsClientKeys(t *testing.T) {
+	withConfig(t, func(c *Config) {
+		c.JSONCase = "camel"
+		c.ResponseEnvelope = "wrapped"
+	})
+	w := httptest.NewRecorder()
+	postHandler(w, httptest.NewRequest(http.MethodPost, "/api/post", strings.NewReader(`{"user_id":7,"nested":{"first_name":"a"}}`)))
+
+	body := w.Body.String()
+	if !strings.Contains(body, `"user_id":7`) || !strings.Contains(body, `"first_name":"a"`) {
+		t.Fatalf("client keys were rewritten: %s", body)
+	}
+	if !strings.Contains(body, `"meta":{"status":200}`) {
+		t.Fatalf("envelope missing: %s", body)
+	}
+}
+
+func TestJSONCaseAppliesToStreamEvents(t *testing.T) {
+	withConfig(t, func(c *Config) { c.JSONCase = "camel" })
+	srv := useRouter(t)
+
+	resp := openStream(t, srv)
+	messageEvents.publish(Message{ID: 3, Content: "x", ContentType: "text/plain"})
+	reader := bufio.NewReader(resp.Body)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.HasPrefix(line, "data: ") {
+			if !strings.Contains(line, `"contentType":"text/plain"`) {
+				t.Fatalf("event %q not camelCased", line)
+			}
+			return
+		}
+	}
+}
+
+func TestWriteJSONPretty(t *testing.T) {
+	payload := map[string]interface{}{"message": "hi", "nested": map[string]int{"n": 1}}
+	tests := []struct {
+		name   string
+		pretty bool
+		target string
+		want   string
+	}{
+		{"compact", false, "/api/get", "{\"message\":\"hi\",\"nested\":{\"n\":1}}\n"},
+		{"query", false, "/api/get?pretty=true", "{\n  \"message\": \"hi\",\n  \"nested\": {\n    \"n\": 1\n  }\n}\n"},
+		{"PRETTY_JSON", true, "/api/get", "{\n  \"message\": \"hi\",\n  \"nested\": {\n    \"n\": 1\n  }\n}\n"},
+		{"other query value", false, "/api/get?pretty=1", "{\"message\":\"hi\",\"nested\":{\"n\":1}}\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withConfig(t, func(c *Config) { c.PrettyJSON = tt.pretty })
+			w := httptest.NewRecorder()
+			writeJSON(w, httptest.NewRequest(http.MethodGet, tt.target, nil), http.StatusOK, payload)
+			if got := w.Body.String(); got != tt.want {
+				t.Fatalf("body = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// failingWriter accepts headers but fails every body write, like a client
+// that hung up.
+type failingWriter struct {
+	header http.Header
+}
+
+func (f *failingWriter) Header() http.Header {
+	if f.header == nil {
+		f.header = make(http.Header)
+	}
+	return f.header
+}
+func (f *failingWriter) WriteHeader(int)           {}
+func (f *failingWriter) Write([]byte) (int, error) { return 0, errors.New("broken pipe") }
+
+func TestWriteJSONCountsEncodeErrors(t *testing.T) {
+	withConfig(t, nil)
+	logs := captureLog(t)
+	before := atomic.LoadInt64(&jsonEncodeErrors.value)
+
+	writeJSON(&failingWriter{}, httptest.NewRequest(http.MethodGet, "/api/get", nil), http.StatusOK, map[string]string{"message": "hi"})
+	if n := atomic.LoadInt64(&jsonEncodeErrors.value) - before; n != 1 {
+		t.Fatalf("json_encode_errors_total grew by %d, want 1", n)
+	}
+	if !strings.Contains(logs.String(), `path "/api/get"`) {
+		t.Fatalf("failure not logged with its path:\n%s", logs.String())
+	}
+
+	// Values that can't be encoded count too
+	writeJSON(httptest.NewRecorder(), nil, http.StatusOK, map[string]interface{}{"f": func() {}})
+	if n := atomic.LoadInt64(&jsonEncodeErrors.value) - before; n != 2 {
+		t.Fatalf("json_encode_errors_total grew by %d, want 2", n)
+	}
+
+	// A successful write leaves it alone
+	writeJSON(httptest.NewRecorder(), nil, http.StatusOK, map[string]string{"message": "hi"})
+	if n := atomic.LoadInt64(&jsonEncodeErrors.value) - before; n != 2 {
+		t.Fatalf("json_encode_errors_total grew by %d after a good write, want still 2", n)
+	}
+}
+
+func TestResponseEnvelope(t *testing.T) {
+	success := map[string]interface{}{"message": "hi"}
+	invalid := map[string]interface{}{"errors": []fieldError{{Field: "content", Message: "required"}, {Field: "id", Message: "read-only"}}}
+	tests := []struct {
+		mode   string
+		status int
+		v      interface{}
+		want   string
+	}{
+		{"bare", http.StatusOK, success, `{"message":"hi"}`},
+		{"bare", http.StatusUnprocessableEntity, invalid, `{"errors":[{"field":"content","message":"required"},{"field":"id","message":"read-only"}]}`},
+		{"wrapped", http.StatusOK, success, `{"data":{"message":"hi"},"meta":{"status":200},"errors":[]}`},
+		{"wrapped", http.StatusNotFound, map[string]string{"error": "Not found"}, `{"data":null,"meta":{"status":404},"errors":[{"error":"Not found"}]}`},
+		// Field errors are spread into errors, not nested
+		{"wrapped", http.StatusUnprocessableEntity, invalid, `{"data":null,"meta":{"status":422},"errors":[{"field":"content","message":"required"},{"field":"id","message":"read-only"}]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode+"/"+strconv.Itoa(tt.status), func(t *testing.T) {
+			withConfig(t, func(c *Config) { c.ResponseEnvelope = tt.mode })
+			w := httptest.NewRecorder()
+			writeJSON(w, nil, tt.status, tt.v)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d", w.Code, tt.status)
+			}
+			if got := strings.TrimSpace(w.Body.String()); got != tt.want {
+				t.Fatalf("body = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInternalErrorVerbosity(t *testing.T) {
+	dbErr := errors.New(`pq: relation "messages" does not exist`)
+	for _, verbosity := range []string{"public", "debug"} {
+		withConfig(t, func(c *Config) { c.ErrorVerbosity = verbosity })
+		logs := captureLog(t)
+
+		body := internalError(httptest.NewRequest(http.MethodGet, "/api/db/messages", nil), "Database query failed", dbErr)
+		id, _ := body["error_id"].(string)
+		if body["error"] != "Database query failed" || id == "" {
+			t.Fatalf("%s: body = %v", verbosity, body)
+		}
+		detail, hasDetail := body["detail"]
+		if verbosity == "public" && hasDetail {
+			t.Fatalf("public body leaks the DB error: %v", detail)
+		}
+		if verbosity == "debug" && detail != dbErr.Error() {
+			t.Fatalf("debug detail = %v, want the DB error", detail)
+		}
+		// Either way the log ties the id to the real error
+		if !strings.Contains(logs.String(), "error_id="+id) || !strings.Contains(logs.String(), dbErr.Error()) {
+			t.Fatalf("%s: log lacks the error under its id:\n%s", verbosity, logs.String())
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strings"
+)
+
+// responseLimitMiddleware aborts responses that grow past MAX_RESPONSE_BYTES,
+// a backstop against a query that slips past the pagination caps. Event
+// streams are exempt: they are long-lived by design.
+func responseLimitMiddleware(next http.Handler) http.Handler {
+	if config.MaxResponseBytes <= 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(&limitedResponseWriter{ResponseWriter: w, r: r, limit: config.MaxResponseBytes}, r)
+	})
+}
+
+type limitedResponseWriter struct {
+	http.ResponseWriter
+	r       *http.Request
+	limit   int
+	written int
+}
+
+func (l *limitedResponseWriter) Write(p []byte) (int, error) {
+	if strings.HasPrefix(l.Header().Get("Content-Type"), "text/event-stream") {
+		return l.ResponseWriter.Write(p)
+	}
+	if l.written+len(p) > l.limit {
+		responsesTooLarge.inc()
+		log.Printf("[RESPONSE] %s %s exceeded MAX_RESPONSE_BYTES (%d), aborting after %d bytes",
+			l.r.Method, l.r.URL.Path, l.limit, l.written)
+		// Headers may already be out, so the only honest signal left is a
+		// broken connection; net/http treats this panic as a silent abort
+		panic(http.ErrAbortHandler)
+	}
+	n, err := l.ResponseWriter.Write(p)
+	l.written += n
+	return n, err
+}
+
+func (l *limitedResponseWriter) Unwrap() http.ResponseWriter {
+	return l.ResponseWriter
+}
+
+func (l *limitedResponseWriter) Flush() {
+	http.NewResponseController(l.ResponseWriter).Flush()
+}
+
+var responsesTooLarge = newCounter("responses_too_large_total", "Responses aborted for exceeding MAX_RESPONSE_BYTES.")
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestResponseLimitAbortsOversizedBodies(t *testing.T) {
+	withConfig(t, func(c *Config) { c.MaxResponseBytes = 16 })
+	before := atomic.LoadInt64(&responsesTooLarge.value)
+
+	handler := responseLimitMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, strings.Repeat("a", 10))
+		io.WriteString(w, strings.Repeat("b", 10))
+	}))
+	func() {
+		defer func() {
+			if v := recover(); v != http.ErrAbortHandler {
+				t.Fatalf("recovered %v, want http.ErrAbortHandler", v)
+			}
+		}()
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/get", nil))
+	}()
+	if atomic.LoadInt64(&responsesTooLarge.value) != before+1 {
+		t.Fatal("responses_too_large_total was not incremented")
+	}
+}
+
+func TestResponseLimitExemptsEventStreams(t *testing.T) {
+	withConfig(t, func(c *Config) { c.MaxResponseBytes = 4 })
+
+	handler := responseLimitMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, "data: a long enough event\n\n")
+	}))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/stream", nil))
+	if !strings.Contains(w.Body.String(), "long enough") {
+		t.Fatalf("event stream was cut: %q", w.Body.String())
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseRetryAfter interprets a Retry-After header value, which may be either
+// delay-seconds or an HTTP-date (RFC 9110, section 10.2.3). The returned
+// duration is never negative.
+func ParseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if at, err := http.ParseTime(value); err == nil {
+		if d := at.Sub(now); d > 0 {
+			return d, true
+		}
+		return 0, true
+	}
+
+	return 0, false
+}
+
+// RetryClient is an example client that honors 429 + Retry-After by waiting
+// the advertised delay and retrying, which exercises the server's backoff
+// signaling end-to-end.
+type RetryClient struct {
+	Client     *http.Client
+	MaxRetries int           // retries after the first attempt
+	MaxWait    time.Duration // cap on a single wait (0 = no cap)
+}
+
+// Do sends the request built by newRequest, rebuilding it for each attempt
+// so request bodies can be replayed.
+func (c *RetryClient) Do(newRequest func() (*http.Request, error)) (*http.Response, error) {
+	client := c.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	for attempt := 0; ; attempt++ {
+		req, err := newRequest()
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusTooManyRequests || attempt >= c.MaxRetries {
+			return resp, nil
+		}
+
+		wait, ok := ParseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+		if !ok {
+			wait = time.Second
+		}
+		if c.MaxWait > 0 && wait > c.MaxWait {
+			wait = c.MaxWait
+		}
+		resp.Body.Close()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		}
+	}
+}
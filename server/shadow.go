@@ -0,0 +1,75 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+)
+
+// Shadow writes: every stored message is mirrored to DB_SHADOW_URL in the
+// background for canary testing. The shadow can be slow or down without
+// touching the main request; mirrored writes are dropped instead of queued
+// without bound.
+
+const shadowQueueSize = 1000
+
+var (
+	shadowDB    *sql.DB
+	shadowQueue chan Message
+
+	shadowWrites   = newCounter("shadow_writes_total", "Messages mirrored to the shadow database.")
+	shadowFailures = newCounter("shadow_write_failures_total", "Shadow writes that failed or were dropped because the queue was full.")
+)
+
+func initShadowDB(url string) error {
+	conn, err := sql.Open("postgres", url)
+	if err != nil {
+		return err
+	}
+	conn.SetMaxOpenConns(4)
+	if err := runMigrations(conn); err != nil {
+		conn.Close()
+		return err
+	}
+
+	shadowDB = conn
+	shadowQueue = make(chan Message, shadowQueueSize)
+	go shadowWorker()
+	return nil
+}
+
+// mirrorToShadow hands msg to the shadow worker without ever blocking.
+func mirrorToShadow(msg Message) {
+	if shadowQueue == nil {
+		return
+	}
+	select {
+	case shadowQueue <- msg:
+	default:
+		shadowFailures.inc()
+		log.Printf("[SHADOW] Queue full, dropping mirror of message %d", msg.ID)
+	}
+}
+
+func shadowWorker() {
+	for msg := range shadowQueue {
+		if msg.contentOmitted {
+			// Streamed uploads only exist in the primary; fetch the content
+			// here so the upload path stays bounded in memory
+			if err := db.QueryRow("SELECT content FROM messages WHERE id = $1", msg.ID).Scan(&msg.Content); err != nil {
+				shadowFailures.inc()
+				log.Printf("[SHADOW] Could not read streamed message %d to mirror it: %v", msg.ID, err)
+				continue
+			}
+		}
+		_, err := shadowDB.Exec(
+			"INSERT INTO messages (content, content_type, title, author, expires_at) VALUES ($1, $2, $3, $4, $5)",
+			msg.Content, msg.ContentType, msg.Title, msg.Author, msg.ExpiresAt,
+		)
+		if err != nil {
+			shadowFailures.inc()
+			log.Printf("[SHADOW] Mirror of message %d failed: %v", msg.ID, err)
+			continue
+		}
+		shadowWrites.inc()
+	}
+}
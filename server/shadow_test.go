@@ -0,0 +1,66 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+// useShadowDB mirrors writes to conn for the test.
+func useShadowDB(t *testing.T, conn *sql.DB) {
+	t.Helper()
+	shadowDB = conn
+	shadowQueue = make(chan Message, shadowQueueSize)
+	go shadowWorker()
+	q := shadowQueue
+	t.Cleanup(func() {
+		shadowQueue = nil
+		close(q)
+		conn.Close()
+	})
+}
+
+func TestShadowMirrorsStoredMessages(t *testing.T) {
+	withConfig(t, nil)
+	useTestDB(t)
+	conn, err := sqliteDialect{}.open(Config{DBSQLitePath: filepath.Join(t.TempDir(), "shadow.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := (sqliteDialect{}).migrate(conn); err != nil {
+		t.Fatal(err)
+	}
+	useShadowDB(t, conn)
+	writes := atomic.LoadInt64(&shadowWrites.value)
+
+	if w := postMessage("/api/db/messages", `{"content":"mirrored","title":"t","author":"a"}`); w.Code != http.StatusCreated {
+		t.Fatalf("POST = %d (%s)", w.Code, w.Body.String())
+	}
+	waitForCounter(t, shadowWrites, writes+1)
+
+	var content, title, author string
+	if err := conn.QueryRow("SELECT content, title, author FROM messages").Scan(&content, &title, &author); err != nil {
+		t.Fatal(err)
+	}
+	if content != "mirrored" || title != "t" || author != "a" {
+		t.Fatalf("shadow row = %q %q %q", content, title, author)
+	}
+}
+
+func TestFailingShadowLeavesRequestAlone(t *testing.T) {
+	withConfig(t, nil)
+	useTestDB(t)
+	useShadowDB(t, sql.OpenDB(&fakeDB{err: errors.New("shadow is down")}))
+	failures := atomic.LoadInt64(&shadowFailures.value)
+
+	if w := postMessage("/api/db/messages", `{"content":"primary only"}`); w.Code != http.StatusCreated {
+		t.Fatalf("POST = %d with the shadow down, want 201 (%s)", w.Code, w.Body.String())
+	}
+	if n := countMessages(t); n != 1 {
+		t.Fatalf("%d rows in the primary, want 1", n)
+	}
+	waitForCounter(t, shadowFailures, failures+1)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Graceful shutdown: on SIGTERM/SIGINT /readyz flips to 503 first, so load
+// balancers stop routing new traffic, and only then does server.Shutdown
+// wait for in-flight requests to drain.
+
+var ready atomic.Bool
+
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	inFlightNow := atomic.LoadInt64(&inFlight)
+	if !ready.Load() {
+		writeJSON(w, r, http.StatusServiceUnavailable, map[string]interface{}{
+			"ready":     false,
+			"draining":  true,
+			"in_flight": inFlightNow,
+		})
+		return
+	}
+	writeJSON(w, r, http.StatusOK, map[string]interface{}{
+		"ready":     true,
+		"in_flight": inFlightNow,
+	})
+}
+
+// waitForShutdown blocks until a termination signal, then drains the
+// server.
+func waitForShutdown(server *http.Server) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	drainServer(server, <-signals)
+}
+
+// drainServer flips readiness and shuts server down. It returns once every
+// in-flight request finished or the SHUTDOWN_TIMEOUT_SEC budget ran out.
+func drainServer(server *http.Server, sig os.Signal) {
+	// Readiness must flip before Shutdown blocks
+	ready.Store(false)
+	log.Printf("[SHUTDOWN] Received %v, readiness set to false (%d in-flight requests)", sig, atomic.LoadInt64(&inFlight))
+
+	if config.ShutdownDrainDelaySec > 0 {
+		// Give the load balancer time to observe /readyz before we stop accepting
+		log.Printf("[SHUTDOWN] Waiting %ds for load balancers to stop routing", config.ShutdownDrainDelaySec)
+		time.Sleep(time.Duration(config.ShutdownDrainDelaySec) * time.Second)
+	}
+
+	// SSE streams never finish on their own
+	messageEvents.close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(config.ShutdownTimeoutSec)*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("[SHUTDOWN] Drain did not complete: %v (%d requests still in flight)", err, atomic.LoadInt64(&inFlight))
+		return
+	}
+	log.Printf("[SHUTDOWN] All requests drained, server stopped")
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// useMessageHub gives the test its own SSE hub, since a drain closes it.
+func useMessageHub(t *testing.T) {
+	t.Helper()
+	prev := messageEvents
+	messageEvents = &messageHub{subs: make(map[chan Message]struct{})}
+	t.Cleanup(func() { messageEvents = prev })
+}
+
+func readyzStatus() int {
+	w := httptest.NewRecorder()
+	readyzHandler(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	return w.Code
+}
+
+func TestDrainFlipsReadinessBeforeInFlightFinishes(t *testing.T) {
+	withConfig(t, func(c *Config) {
+		c.ShutdownDrainDelaySec = 0
+		c.ShutdownTimeoutSec = 5
+	})
+	useMessageHub(t)
+	ready.Store(true)
+	t.Cleanup(func() { ready.Store(false) })
+
+	entered, release := make(chan struct{}), make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(entered)
+		<-release
+		w.Write([]byte("done"))
+	}))
+	defer srv.Close()
+
+	type result struct {
+		status int
+		body   string
+		err    error
+	}
+	pending := make(chan result, 1)
+	go func() {
+		resp, err := http.Get(srv.URL)
+		if err != nil {
+			pending <- result{err: err}
+			return
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		pending <- result{status: resp.StatusCode, body: string(body)}
+	}()
+	<-entered
+
+	if code := readyzStatus(); code != http.StatusOK {
+		t.Fatalf("/readyz before shutdown = %d, want 200", code)
+	}
+	drained := make(chan struct{})
+	go func() {
+		drainServer(srv.Config, syscall.SIGTERM)
+		close(drained)
+	}()
+
+	// Readiness drops while the request is still being handled
+	deadline := time.Now().Add(2 * time.Second)
+	for readyzStatus() != http.StatusServiceUnavailable && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if code := readyzStatus(); code != http.StatusServiceUnavailable {
+		t.Fatalf("/readyz during shutdown = %d, want 503", code)
+	}
+	select {
+	case <-drained:
+		t.Fatal("drain finished while a request was still in flight")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(release)
+	res := <-pending
+	if res.err != nil || res.status != http.StatusOK || res.body != "done" {
+		t.Fatalf("in-flight request = %d %q (%v), want it to complete", res.status, res.body, res.err)
+	}
+	select {
+	case <-drained:
+	case <-time.After(2 * time.Second):
+		t.Fatal("drain did not return after the last request finished")
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"io"
+	"net/http"
+)
+
+// Per-endpoint request and response body sizes (ENABLE_SIZE_METRICS), for
+// capacity planning: histogram_quantile gives percentiles and _sum/_count
+// the average. Response sizes are counted before gzip compression.
+
+func sizeMetricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !config.EnableSizeMetrics {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		body := &countingBody{ReadCloser: r.Body}
+		r.Body = body
+		sw := &sizeWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r)
+
+		// The declared length is what the client sent even when the
+		// handler rejected the request without reading it
+		requestSize := r.ContentLength
+		if requestSize < 0 {
+			requestSize = body.n
+		}
+		path := routeLabel(r)
+		requestBodyBytes.observe(float64(requestSize), path)
+		responseBodyBytes.observe(float64(sw.n), path)
+	})
+}
+
+type countingBody struct {
+	io.ReadCloser
+	n int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	return n, err
+}
+
+type sizeWriter struct {
+	http.ResponseWriter
+	n int64
+}
+
+func (s *sizeWriter) Write(p []byte) (int, error) {
+	n, err := s.ResponseWriter.Write(p)
+	s.n += int64(n)
+	return n, err
+}
+
+func (s *sizeWriter) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+func (s *sizeWriter) Flush() {
+	http.NewResponseController(s.ResponseWriter).Flush()
+}
+
+var (
+	requestBodyBytes = newHistogramVec("http_request_body_bytes", "Request body size by endpoint (Content-Length, or bytes read when chunked).",
+		sizeBuckets, "path")
+	responseBodyBytes = newHistogramVec("http_response_body_bytes", "Response body size by endpoint, before compression.",
+		sizeBuckets, "path")
+)
+
+var sizeBuckets = []float64{0, 128, 512, 2048, 8192, 32768, 131072, 524288, 2097152, 8388608}
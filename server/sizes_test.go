@@ -0,0 +1,60 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// seriesStats reads the count and sum of one labelled series of h.
+func seriesStats(h *histogramVec, labelValues ...string) (uint64, float64) {
+	h.mu.Lock()
+	s := h.series[formatLabels(h.labels, labelValues)]
+	h.mu.Unlock()
+	if s == nil {
+		return 0, 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.count, s.sum
+}
+
+func sizedHandler(w http.ResponseWriter, r *http.Request) {
+	io.Copy(io.Discard, r.Body)
+	w.Write([]byte(strings.Repeat("r", 300)))
+}
+
+func TestSizeMetricsRecordBodies(t *testing.T) {
+	withConfig(t, func(c *Config) { c.EnableSizeMetrics = true })
+	reqCount, reqSum := seriesStats(requestBodyBytes, "other")
+	respCount, respSum := seriesStats(responseBodyBytes, "other")
+
+	handler := sizeMetricsMiddleware(http.HandlerFunc(sizedHandler))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/unregistered", strings.NewReader(strings.Repeat("q", 1000))))
+
+	// Chunked: nothing declared, so the bytes read are counted
+	chunked := httptest.NewRequest(http.MethodPost, "/unregistered", strings.NewReader(strings.Repeat("q", 50)))
+	chunked.ContentLength = -1
+	handler.ServeHTTP(httptest.NewRecorder(), chunked)
+
+	if n, sum := seriesStats(requestBodyBytes, "other"); n != reqCount+2 || sum != reqSum+1050 {
+		t.Fatalf("request sizes: count +%d sum +%g, want +2 and +1050", n-reqCount, sum-reqSum)
+	}
+	if n, sum := seriesStats(responseBodyBytes, "other"); n != respCount+2 || sum != respSum+600 {
+		t.Fatalf("response sizes: count +%d sum +%g, want +2 and +600", n-respCount, sum-respSum)
+	}
+}
+
+func TestSizeMetricsOffByDefault(t *testing.T) {
+	withConfig(t, nil)
+	if config.EnableSizeMetrics {
+		t.Fatal("ENABLE_SIZE_METRICS is on by default")
+	}
+	before, _ := seriesStats(requestBodyBytes, "other")
+	sizeMetricsMiddleware(http.HandlerFunc(sizedHandler)).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/unregistered", strings.NewReader("x")))
+	if after, _ := seriesStats(requestBodyBytes, "other"); after != before {
+		t.Fatal("request recorded with size metrics disabled")
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"os"
+)
+
+// slowClientMiddleware records responses whose writes hit the connection's
+// write deadline (the server WriteTimeout, or the per-write deadline on
+// streams): the client stopped reading fast enough to drain the response.
+//
+// Repro: store a few large messages, then
+//
+//	curl --limit-rate 1k -o /dev/null localhost:8888/api/db/messages
+//
+// with WRITE_TIMEOUT_SEC=1 and watch slow_client_write_timeouts_total.
+func slowClientMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(&deadlineAwareWriter{ResponseWriter: w, r: r}, r)
+	})
+}
+
+type deadlineAwareWriter struct {
+	http.ResponseWriter
+	r        *http.Request
+	written  int
+	reported bool
+}
+
+func (d *deadlineAwareWriter) Write(p []byte) (int, error) {
+	n, err := d.ResponseWriter.Write(p)
+	d.written += n
+	if err != nil && !d.reported && isTimeout(err) {
+		d.reported = true
+		slowClientTimeouts.inc(routeLabel(d.r))
+		log.Printf("[SLOW CLIENT] %s %s from %s: write timed out after %d bytes: %v",
+			d.r.Method, d.r.URL.Path, d.r.RemoteAddr, d.written, err)
+	}
+	return n, err
+}
+
+func (d *deadlineAwareWriter) Unwrap() http.ResponseWriter {
+	return d.ResponseWriter
+}
+
+func (d *deadlineAwareWriter) Flush() {
+	http.NewResponseController(d.ResponseWriter).Flush()
+}
+
+func isTimeout(err error) bool {
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+var slowClientTimeouts = newCounterVec("slow_client_write_timeouts_total", "Responses cut off because the client read too slowly for the write deadline.", "path")
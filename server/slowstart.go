@@ -0,0 +1,54 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// startSlowStart ramps the global limiter linearly from fraction of the
+// configured rate (and burst) up to the full values over duration, so a
+// freshly restarted instance doesn't hit cold downstream caches at full
+// throughput.
+func startSlowStart(l *rate.Limiter, duration time.Duration, fraction float64) {
+	if fraction <= 0 || fraction > 1 {
+		fraction = 0.1
+	}
+	fullRate := l.Limit()
+	fullBurst := l.Burst()
+	start := clock.Now()
+
+	apply := func(factor float64) {
+		now := clock.Now()
+		l.SetLimitAt(now, fullRate*rate.Limit(factor))
+		burst := int(float64(fullBurst) * factor)
+		if burst < 1 {
+			burst = 1
+		}
+		l.SetBurstAt(now, burst)
+	}
+	apply(fraction)
+
+	step := duration / 20
+	if step > time.Second {
+		step = time.Second
+	}
+	if step <= 0 {
+		step = time.Millisecond
+	}
+
+	go func() {
+		ticker := time.NewTicker(step)
+		defer ticker.Stop()
+		for range ticker.C {
+			progress := float64(clock.Now().Sub(start)) / float64(duration)
+			if progress >= 1 {
+				apply(1)
+				log.Printf("[RATELIMIT] Slow start finished after %v", duration)
+				return
+			}
+			apply(fraction + (1-fraction)*progress)
+		}
+	}()
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// waitForLimit polls until l's limit satisfies ok; the ramp runs on a real
+// ticker even though its progress is read from the mock clock.
+func waitForLimit(t *testing.T, l *rate.Limiter, ok func(rate.Limit) bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !ok(l.Limit()) && time.Now().Before(deadline) {
+		time.Sleep(2 * time.Millisecond)
+	}
+	if !ok(l.Limit()) {
+		t.Fatalf("limit stuck at %v", l.Limit())
+	}
+}
+
+func TestSlowStartRampsToFullRate(t *testing.T) {
+	clk := useMockClock(t)
+	l := rate.NewLimiter(100, 100)
+
+	startSlowStart(l, 200*time.Millisecond, 0.2)
+	if l.Limit() != 20 || l.Burst() != 20 {
+		t.Fatalf("start: limit %v burst %d, want 20 and 20", l.Limit(), l.Burst())
+	}
+
+	// Halfway: 20 + 80*0.5
+	clk.Advance(100 * time.Millisecond)
+	waitForLimit(t, l, func(r rate.Limit) bool { return math.Abs(float64(r)-60) < 1e-6 })
+	if l.Burst() != 60 {
+		t.Fatalf("halfway burst = %d, want 60", l.Burst())
+	}
+
+	clk.Advance(time.Second)
+	waitForLimit(t, l, func(r rate.Limit) bool { return r == 100 })
+	if l.Burst() != 100 {
+		t.Fatalf("final burst = %d, want 100", l.Burst())
+	}
+}
+
+func TestSlowStartInvalidFractionAndTinyBurst(t *testing.T) {
+	useMockClock(t)
+	l := rate.NewLimiter(5, 5)
+
+	// Out of range falls back to 10%, and the burst never drops below 1
+	startSlowStart(l, time.Hour, 3)
+	if l.Limit() != 0.5 || l.Burst() != 1 {
+		t.Fatalf("limit %v burst %d, want 0.5 and 1", l.Limit(), l.Burst())
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Server-Sent Events for new messages. Subscribers live in an in-process
+// hub, so each instance only streams the writes it handled itself.
+
+const sseSubscriberBuffer = 16
+
+type messageHub struct {
+	mu     sync.Mutex
+	subs   map[chan Message]struct{}
+	closed bool
+}
+
+var messageEvents = &messageHub{subs: make(map[chan Message]struct{})}
+
+func (h *messageHub) subscribe() (chan Message, func()) {
+	ch := make(chan Message, sseSubscriberBuffer)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		close(ch)
+		return ch, func() {}
+	}
+	h.subs[ch] = struct{}{}
+	return ch, func() {
+		h.mu.Lock()
+		if _, ok := h.subs[ch]; ok {
+			delete(h.subs, ch)
+			close(ch)
+		}
+		h.mu.Unlock()
+	}
+}
+
+// publish never blocks the writer: a subscriber whose buffer is full misses
+// the event.
+func (h *messageHub) publish(msg Message) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs {
+		select {
+		case ch <- msg:
+		default:
+		}
+	}
+}
+
+// close ends every stream so graceful shutdown isn't held up by clients
+// that would otherwise stay connected forever.
+func (h *messageHub) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for ch := range h.subs {
+		delete(h.subs, ch)
+		close(ch)
+	}
+}
+
+// sseHandler streams new messages as "message" events. The server-wide
+// WriteTimeout would cut the connection after a few seconds, so the write
+// deadline is pushed forward before every write instead: a live client can
+// stay connected indefinitely, a stalled one is still dropped.
+func sseHandler(w http.ResponseWriter, r *http.Request) {
+	rc := http.NewResponseController(w)
+	writeTimeout := time.Duration(config.SSEWriteTimeoutSec) * time.Second
+	extend := func() bool {
+		if err := rc.SetWriteDeadline(time.Now().Add(writeTimeout)); err != nil {
+			log.Printf("[SSE] Cannot manage write deadline, refusing stream: %v", err)
+			return false
+		}
+		return true
+	}
+	if !extend() {
+		writeJSON(w, r, http.StatusInternalServerError, map[string]string{
+			"error": "Streaming not supported",
+		})
+		return
+	}
+
+	events, unsubscribe := messageEvents.subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	rc.Flush()
+
+	heartbeat := time.NewTicker(time.Duration(config.SSEHeartbeatSec) * time.Second)
+	defer heartbeat.Stop()
+
+	for {
+		var err error
+		select {
+		case <-r.Context().Done():
+			return
+		case msg, ok := <-events:
+			if !ok {
+				return
+			}
+			data, _ := marshalPayload(msg)
+			if !extend() {
+				return
+			}
+			_, err = fmt.Fprintf(w, "id: %d\nevent: message\ndata: %s\n\n", msg.ID, data)
+		case <-heartbeat.C:
+			if !extend() {
+				return
+			}
+			_, err = fmt.Fprint(w, ": ping\n\n")
+		}
+		if err == nil {
+			err = rc.Flush()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// nextEventData reads the stream until its next data line.
+func nextEventData(t *testing.T, reader *bufio.Reader) string {
+	t.Helper()
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("stream ended: %v", err)
+		}
+		if strings.HasPrefix(line, "data: ") {
+			return line
+		}
+	}
+}
+
+func TestStreamOutlivesServerWriteTimeout(t *testing.T) {
+	withConfig(t, func(c *Config) {
+		c.SSEWriteTimeoutSec = 10
+		c.SSEHeartbeatSec = 60
+	})
+	useMessageHub(t)
+	useLimiter(t, rate.NewLimiter(rate.Inf, 0))
+	srv := httptest.NewUnstartedServer(newRouter())
+	srv.Config.WriteTimeout = 100 * time.Millisecond
+	srv.Start()
+	t.Cleanup(srv.Close)
+
+	resp := openStream(t, srv)
+	reader := bufio.NewReader(resp.Body)
+
+	for i, id := range []int{1, 2} {
+		// Each event arrives well after the server-wide deadline has passed
+		time.Sleep(250 * time.Millisecond)
+		messageEvents.publish(Message{ID: id, Content: "late"})
+		if line := nextEventData(t, reader); !strings.Contains(line, `"content":"late"`) {
+			t.Fatalf("event %d = %q", i+1, line)
+		}
+	}
+}
+
+func TestWriteTimeoutStillCutsOrdinaryResponses(t *testing.T) {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(250 * time.Millisecond)
+		w.Write([]byte("too late"))
+	}))
+	srv.Config.WriteTimeout = 100 * time.Millisecond
+	srv.Start()
+	t.Cleanup(srv.Close)
+
+	resp, err := http.Get(srv.URL)
+	if err == nil {
+		resp.Body.Close()
+		t.Fatal("response written past WriteTimeout reached the client")
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// Periodic traffic summary (STATS_LOG_INTERVAL_SEC): one log line per
+// window with request rate, 429s and the average throttle delay, for
+// diagnostics without turning on per-request access logs.
+
+type statsWindow struct {
+	requests   int64
+	rejected   int64 // 429 responses
+	throttleNs int64
+}
+
+var requestStats statsWindow
+
+func (s *statsWindow) observe(status int, throttle time.Duration) {
+	atomic.AddInt64(&s.requests, 1)
+	if status == http.StatusTooManyRequests {
+		atomic.AddInt64(&s.rejected, 1)
+	}
+	atomic.AddInt64(&s.throttleNs, int64(throttle))
+}
+
+// statsSummary is one closed window.
+type statsSummary struct {
+	requests    int64
+	rejected    int64
+	rps         float64
+	avgThrottle time.Duration
+}
+
+// flush closes the current window and starts the next one.
+func (s *statsWindow) flush(window time.Duration) statsSummary {
+	sum := statsSummary{
+		requests: atomic.SwapInt64(&s.requests, 0),
+		rejected: atomic.SwapInt64(&s.rejected, 0),
+	}
+	throttle := atomic.SwapInt64(&s.throttleNs, 0)
+	if window > 0 {
+		sum.rps = float64(sum.requests) / window.Seconds()
+	}
+	if sum.requests > 0 {
+		sum.avgThrottle = time.Duration(throttle / sum.requests)
+	}
+	return sum
+}
+
+func statsMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if config.StatsLogIntervalSec <= 0 {
+			next(w, r)
+			return
+		}
+		rec := newStatusRecorder(w)
+		next(rec, r)
+
+		var throttle time.Duration
+		if t := timingFrom(r.Context()); t != nil {
+			throttle = t.throttle
+		}
+		requestStats.observe(rec.status, throttle)
+	}
+}
+
+func startStatsLogger(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		last := time.Now()
+		for now := range ticker.C {
+			sum := requestStats.flush(now.Sub(last))
+			last = now
+			log.Printf("[STATS] last %v: %d requests (%.1f req/s), %d rejected with 429, avg throttle %v",
+				interval, sum.requests, sum.rps, sum.rejected, sum.avgThrottle.Round(time.Microsecond))
+		}
+	}()
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// useRequestStats starts the test with an empty window.
+func useRequestStats(t *testing.T) {
+	t.Helper()
+	requestStats.flush(0)
+	t.Cleanup(func() { requestStats.flush(0) })
+}
+
+func TestStatsWindowCounts(t *testing.T) {
+	withConfig(t, func(c *Config) { c.StatsLogIntervalSec = 10 })
+	useRequestStats(t)
+
+	// Status and throttle delay as the throttle middleware left them
+	handler := func(status int, throttle time.Duration) http.HandlerFunc {
+		return timingMiddleware(statsMiddleware(func(w http.ResponseWriter, r *http.Request) {
+			timingFrom(r.Context()).throttle = throttle
+			w.WriteHeader(status)
+		}))
+	}
+	for i := 0; i < 15; i++ {
+		handler(http.StatusOK, 20*time.Millisecond)(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/db/messages", nil))
+	}
+	for i := 0; i < 5; i++ {
+		handler(http.StatusTooManyRequests, 0)(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/db/messages", nil))
+	}
+
+	sum := requestStats.flush(10 * time.Second)
+	if sum.requests != 20 || sum.rejected != 5 {
+		t.Fatalf("window counted %d requests, %d rejected; want 20 and 5", sum.requests, sum.rejected)
+	}
+	if sum.rps != 2 {
+		t.Fatalf("rps = %v, want 2", sum.rps)
+	}
+	if sum.avgThrottle != 15*time.Millisecond {
+		t.Fatalf("avg throttle = %v, want 15ms", sum.avgThrottle)
+	}
+
+	// The flush closed the window: the next one starts from zero
+	if next := requestStats.flush(10 * time.Second); next != (statsSummary{}) {
+		t.Fatalf("next window = %+v, want empty", next)
+	}
+}
+
+func TestStatsOffByDefault(t *testing.T) {
+	withConfig(t, nil)
+	useRequestStats(t)
+
+	statsMiddleware(okHandler)(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/db/messages", nil))
+	if sum := requestStats.flush(time.Second); sum.requests != 0 {
+		t.Fatalf("counted %d requests with STATS_LOG_INTERVAL_SEC unset", sum.requests)
+	}
+}
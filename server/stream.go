@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"time"
+	"unicode/utf8"
+)
+
+// Streamed message ingestion: a text/plain body above
+// STREAM_CONTENT_THRESHOLD is the message content itself and is copied to
+// Postgres chunk by chunk, so memory use stays bounded by the chunk size
+// no matter how big the upload is.
+
+const streamChunkSize = 64 * 1024
+
+func shouldStreamContent(r *http.Request) bool {
+	if config.StreamContentThreshold <= 0 {
+		return false
+	}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "text/plain" {
+		return false
+	}
+	// -1 = chunked upload with unknown size, treated as large
+	return r.ContentLength == -1 || r.ContentLength > int64(config.StreamContentThreshold)
+}
+
+// dbStreamPostHandler stages the body in a temporary table and assembles
+// the final row server-side with string_agg.
+func dbStreamPostHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+
+	if !config.AllowedContentTypes[defaultContentType] {
+		writeValidationErrors(w, r, []fieldError{{Field: "content_type", Message: "unsupported"}})
+		return
+	}
+	if len(contentDenyPatterns) > 0 && denyPatternOverlap < 0 {
+		// A match could span any number of chunks; only a buffered body can be checked
+		writeJSON(w, r, http.StatusRequestEntityTooLarge, map[string]string{
+			"error": "Streamed uploads are disabled while CONTENT_DENY_PATTERNS has an unbounded pattern; send at most STREAM_CONTENT_THRESHOLD bytes",
+		})
+		return
+	}
+
+	// The body is the content, so title and author come in the query string
+	msg := Message{
+		ContentType: defaultContentType,
+		Title:       sanitizeContent(r.URL.Query().Get("title")),
+		Author:      sanitizeContent(r.URL.Query().Get("author")),
+	}
+	if errs := validateMessageMeta(msg.Title, msg.Author); len(errs) > 0 {
+		writeValidationErrors(w, r, errs)
+		return
+	}
+	// Like the body, query values reach the row as they are, with no JSON
+	// decoder to replace invalid bytes
+	for _, f := range []struct{ name, value string }{{"title", msg.Title}, {"author", msg.Author}} {
+		if !utf8.ValidString(f.value) {
+			writeValidationErrors(w, r, []fieldError{{Field: f.name, Message: "invalid UTF-8"}})
+			return
+		}
+	}
+	ttl, ok := ttlFromRequest(r)
+	if !ok {
+		writeValidationErrors(w, r, []fieldError{{Field: "ttl_seconds", Message: "must be a positive integer"}})
+		return
+	}
+	if ttl > 0 {
+		expiresAt := clock.Now().Add(ttl).UTC()
+		msg.ExpiresAt = &expiresAt
+	}
+
+	rc := http.NewResponseController(w)
+	rc.SetReadDeadline(time.Time{})
+
+	tx, err := db.BeginTx(r.Context(), nil)
+	if err != nil {
+		writeJSON(w, r, http.StatusInternalServerError, internalError(r, "Failed to insert message", err))
+		return
+	}
+	defer tx.Rollback()
+
+	createChunks, dropChunks := dialect.chunkTable()
+	if _, err := tx.Exec(createChunks); err != nil {
+		writeJSON(w, r, http.StatusInternalServerError, internalError(r, "Failed to insert message", err))
+		return
+	}
+	stmt, err := tx.Prepare("INSERT INTO upload_chunks (seq, data) VALUES ($1, $2)")
+	if err != nil {
+		writeJSON(w, r, http.StatusInternalServerError, internalError(r, "Failed to insert message", err))
+		return
+	}
+	defer stmt.Close()
+
+	reader := bufio.NewReaderSize(r.Body, streamChunkSize)
+	buf := make([]byte, streamChunkSize)
+	var carry []byte // incomplete UTF-8 sequence split across chunks
+	var tail []byte  // end of the previous chunk, re-checked against deny patterns
+	seq, total, runes := 0, 0, 0
+
+	for {
+		n, readErr := io.ReadFull(reader, buf)
+		chunk := append(carry, buf[:n]...)
+		carry = nil
+
+		// Postgres TEXT must be valid UTF-8, so never cut a rune in half
+		if readErr == nil {
+			if cut := incompleteRuneSuffix(chunk); cut > 0 {
+				carry = append([]byte(nil), chunk[len(chunk)-cut:]...)
+				chunk = chunk[:len(chunk)-cut]
+			}
+			// Nor a tag, or strip mode would only see half of it
+			if cut := openTagSuffix(chunk); cut > 0 && config.SanitizeContent == "strip" {
+				carry = append(append([]byte(nil), chunk[len(chunk)-cut:]...), carry...)
+				chunk = chunk[:len(chunk)-cut]
+			}
+		}
+
+		if len(chunk) > 0 {
+			if !utf8.Valid(chunk) {
+				writeValidationErrors(w, r, []fieldError{{Field: "content", Message: "invalid UTF-8"}})
+				return
+			}
+			chunk = []byte(sanitizeContent(string(chunk)))
+			if len(contentDenyPatterns) > 0 {
+				window := append(tail, chunk...)
+				if matchesDenyPattern(string(window)) {
+					writeValidationErrors(w, r, []fieldError{{Field: "content", Message: "matches a denied pattern"}})
+					return
+				}
+				keep := denyPatternOverlap
+				if keep > len(window) {
+					keep = len(window)
+				}
+				tail = append([]byte(nil), window[len(window)-keep:]...)
+			}
+			runes += utf8.RuneCount(chunk)
+			if config.MessageMaxLength > 0 && runes > config.MessageMaxLength {
+				writeValidationErrors(w, r, []fieldError{{Field: "content", Message: "too long"}})
+				return
+			}
+			if _, err := stmt.Exec(seq, string(chunk)); err != nil {
+				noteWriteError(err)
+				writeJSON(w, r, http.StatusInternalServerError, internalError(r, "Failed to insert message", err))
+				return
+			}
+			seq++
+			total += len(chunk)
+		}
+
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			break
+		}
+		if writeBodyLengthMismatch(w, r, readErr) {
+			return
+		}
+		if readErr != nil {
+			writeJSON(w, r, http.StatusBadRequest, map[string]string{
+				"error": "Failed to read request body",
+			})
+			return
+		}
+	}
+
+	if total == 0 {
+		writeValidationErrors(w, r, []fieldError{{Field: "content", Message: "required"}})
+		return
+	}
+
+	err = tx.QueryRow(`
+		INSERT INTO messages (content, content_type, title, author)
+		SELECT string_agg(data, '' ORDER BY seq), 'text/plain', $1, $2 FROM upload_chunks
+		RETURNING id, created_at
+	`, msg.Title, msg.Author).Scan(&msg.ID, &msg.CreatedAt)
+	if err == nil && msg.ExpiresAt != nil {
+		// Set apart: a bare parameter in the SELECT list has no type for Postgres to infer
+		_, err = tx.Exec("UPDATE messages SET expires_at = $1 WHERE id = $2", msg.ExpiresAt, msg.ID)
+	}
+	if err == nil && dropChunks != "" {
+		_, err = tx.Exec(dropChunks)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		noteWriteError(err)
+		writeJSON(w, r, http.StatusInternalServerError, internalError(r, "Failed to insert message", err))
+		return
+	}
+	messagesCache.invalidate()
+	auditWrite(r, "create", []int64{int64(msg.ID)}, 1, "")
+	msg.contentOmitted = true
+	messageStored(msg, total)
+
+	log.Printf("[STREAM] Stored message %d (%d bytes in %d chunks) in %v", msg.ID, total, seq, time.Since(start))
+
+	// O conteúdo não é ecoado: pode ter centenas de MB
+	writeJSON(w, r, http.StatusCreated, map[string]interface{}{
+		"message": "Message saved successfully",
+		"data": map[string]interface{}{
+			"id":           msg.ID,
+			"created_at":   msg.CreatedAt,
+			"bytes":        total,
+			"content_type": "text/plain",
+			"title":        msg.Title,
+			"author":       msg.Author,
+			"expires_at":   msg.ExpiresAt,
+		},
+	})
+}
+
+// maxCarriedTag bounds how much of an unterminated tag is carried to the
+// next chunk; a longer one is left to sanitizeContent, which escapes its "<".
+const maxCarriedTag = 1024
+
+// openTagSuffix returns how many trailing bytes of p follow a "<" that is
+// not closed within p.
+func openTagSuffix(p []byte) int {
+	open := bytes.LastIndexByte(p, '<')
+	if open < 0 || bytes.IndexByte(p[open:], '>') >= 0 || len(p)-open > maxCarriedTag {
+		return 0
+	}
+	return len(p) - open
+}
+
+// incompleteRuneSuffix returns how many trailing bytes of p form the start
+// of a UTF-8 sequence that continues in the next chunk.
+func incompleteRuneSuffix(p []byte) int {
+	for i := 1; i <= utf8.UTFMax && i <= len(p); i++ {
+		b := p[len(p)-i]
+		if utf8.RuneStart(b) {
+			if !utf8.FullRune(p[len(p)-i:]) {
+				return i
+			}
+			return 0
+		}
+	}
+	return 0
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// useDenyPatterns compiles patterns for the test and clears them after.
+func useDenyPatterns(t *testing.T, patterns ...string) {
+	t.Helper()
+	if err := compileDenyPatterns(patterns); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { compileDenyPatterns(nil) })
+}
+
+// streamUpload posts body as a chunked text/plain upload of unknown length.
+func streamUpload(t *testing.T, target, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+	r.Header.Set("Content-Type", "text/plain")
+	r.ContentLength = -1
+	if !shouldStreamContent(r) {
+		t.Fatal("upload would not take the streaming path")
+	}
+	w := httptest.NewRecorder()
+	dbStreamPostHandler(w, r)
+	return w
+}
+
+func streamConfig(edit func(*Config)) func(*Config) {
+	return func(c *Config) {
+		c.StreamContentThreshold = 1024
+		if edit != nil {
+			edit(c)
+		}
+	}
+}
+
+func TestStreamStoresChunkedUpload(t *testing.T) {
+	withConfig(t, streamConfig(nil))
+	useTestDB(t)
+	audit := captureAudit(t)
+	events := subscribeEvents(t)
+
+	// The two-byte rune straddles the first chunk boundary
+	body := strings.Repeat("a", streamChunkSize-1) + "é" + strings.Repeat("b", 100000)
+	w := streamUpload(t, "/api/db/messages?title=big&ttl_seconds=60", body)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("status = %d, body %s", w.Code, w.Body.String())
+	}
+
+	var resp struct {
+		Data struct {
+			ID        int64   `json:"id"`
+			Bytes     int     `json:"bytes"`
+			ExpiresAt *string `json:"expires_at"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Data.Bytes != len(body) || resp.Data.ExpiresAt == nil {
+		t.Fatalf("response data = %+v, want %d bytes and an expiry", resp.Data, len(body))
+	}
+	if got := messageContent(t, resp.Data.ID); got != body {
+		t.Fatalf("stored %d bytes, want the %d sent", len(got), len(body))
+	}
+
+	var expires *string
+	if err := db.QueryRow("SELECT expires_at FROM messages WHERE id = $1", resp.Data.ID).Scan(&expires); err != nil || expires == nil {
+		t.Fatalf("expires_at not stored (err %v)", err)
+	}
+
+	got := drainEvents(events)
+	if len(got) != 1 || int64(got[0].ID) != resp.Data.ID || !got[0].contentOmitted {
+		t.Fatalf("published events = %+v, want message %d without its content", got, resp.Data.ID)
+	}
+	if !strings.Contains(audit.String(), `"action":"create"`) {
+		t.Fatalf("no create audit entry:\n%s", audit.String())
+	}
+}
+
+func TestStreamSanitizesTagsSplitAcrossChunks(t *testing.T) {
+	withConfig(t, streamConfig(func(c *Config) { c.SanitizeContent = "strip" }))
+	useTestDB(t)
+
+	// "<b" ends the first chunk and ">" starts the second
+	prefix := strings.Repeat("a", streamChunkSize-2)
+	body := prefix + "<b>bold</b> text <script src=x"
+	w := streamUpload(t, "/api/db/messages", body)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("status = %d, body %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		Data struct {
+			ID int64 `json:"id"`
+		} `json:"data"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+
+	want := prefix + "bold text &lt;script src=x"
+	if got := messageContent(t, resp.Data.ID); got != want {
+		t.Fatalf("stored tail %q, want %q", got[len(prefix):], want[len(prefix):])
+	}
+}
+
+func TestStreamDenyPatternAcrossChunks(t *testing.T) {
+	withConfig(t, streamConfig(nil))
+	useTestDB(t)
+	useDenyPatterns(t, "forbidden")
+
+	body := strings.Repeat("a", streamChunkSize-4) + "forbidden" + strings.Repeat("z", 1000)
+	w := streamUpload(t, "/api/db/messages", body)
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("status = %d, want 422 (%s)", w.Code, w.Body.String())
+	}
+	if n := countMessages(t); n != 0 {
+		t.Fatalf("%d messages stored despite the denied pattern", n)
+	}
+}
+
+func TestStreamRejectedWithUnboundedDenyPattern(t *testing.T) {
+	withConfig(t, streamConfig(nil))
+	useTestDB(t)
+	useDenyPatterns(t, "bad.*word")
+
+	w := streamUpload(t, "/api/db/messages", strings.Repeat("a", 4096))
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("status = %d, want 413", w.Code)
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// requestTiming accumulates how long each phase of a request took, so
+// intentional throttle delay can be told apart from real handler work.
+type requestTiming struct {
+	start        time.Time
+	throttle     time.Duration
+	rateLimit    time.Duration
+	handlerStart time.Time
+	handler      time.Duration
+	db           time.Duration // summed over the request's DB calls
+}
+
+type timingKey struct{}
+
+func timingFrom(ctx context.Context) *requestTiming {
+	t, _ := ctx.Value(timingKey{}).(*requestTiming)
+	return t
+}
+
+// handlerTimer measures the wrapped handler alone, excluding the
+// middlewares in front of it.
+func handlerTimer(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		t := timingFrom(r.Context())
+		if t != nil {
+			t.handlerStart = start
+		}
+		next(w, r)
+		if t != nil {
+			t.handler = time.Since(start)
+		}
+	}
+}
+
+// timingMiddleware attaches a requestTiming to the context, sends it as
+// Server-Timing when ENABLE_SERVER_TIMING is set and records the request
+// duration once the handler returns.
+func timingMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		t := &requestTiming{start: time.Now()}
+		r = r.WithContext(context.WithValue(r.Context(), timingKey{}, t))
+
+		if config.EnableServerTiming {
+			rec := newStatusRecorder(w)
+			rec.beforeWrite = func() {
+				rec.Header().Set("Server-Timing", t.serverTiming())
+			}
+			w = rec
+		}
+
+		next(w, r)
+
+		traceID := ""
+		if config.EnableExemplars {
+			traceID = traceIDFrom(r)
+		}
+		requestDuration.observeWithTrace(time.Since(t.start).Seconds(), traceID)
+	}
+}
+
+// sloMiddleware reports handlers slower than their path's SLO
+// (SLO_THRESHOLDS_MS), using the requestTiming from timingMiddleware.
+func sloMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		t := timingFrom(r.Context())
+		if t == nil {
+			// "timing" skipped on this route: measure the handler anyway
+			t = &requestTiming{start: time.Now()}
+			r = r.WithContext(context.WithValue(r.Context(), timingKey{}, t))
+		}
+
+		next(w, r)
+
+		slo, ok := config.SLOThresholds[r.URL.Path]
+		if ok && t.handler > slo {
+			sloBreaches.inc(routeLabel(r))
+			log.Printf("[SLO] WARN %s %s handler took %v (SLO %v, throttle %v excluded)",
+				r.Method, r.URL.Path, t.handler, slo, t.throttle)
+		}
+	}
+}
+
+// dbLatencyEWMA is an exponentially weighted average of DB call latency in
+// nanoseconds, fed by observeDB.
+var dbLatencyEWMA int64
+
+const dbLatencyAlpha = 0.2
+
+// observeDB adds the time since start to the request's DB total.
+func observeDB(r *http.Request, start time.Time) {
+	elapsed := time.Since(start)
+	if t := timingFrom(r.Context()); t != nil {
+		t.db += elapsed
+	}
+	for {
+		old := atomic.LoadInt64(&dbLatencyEWMA)
+		next := int64(elapsed)
+		if old != 0 {
+			next = int64(dbLatencyAlpha*float64(elapsed) + (1-dbLatencyAlpha)*float64(old))
+		}
+		if atomic.CompareAndSwapInt64(&dbLatencyEWMA, old, next) {
+			return
+		}
+	}
+}
+
+func recentDBLatency() time.Duration {
+	return time.Duration(atomic.LoadInt64(&dbLatencyEWMA))
+}
+
+var _ = newGaugeFunc("db_latency_ewma_seconds", "Moving average of DB call latency used by THROTTLE_DB_LATENCY_MS.", func() float64 {
+	return recentDBLatency().Seconds()
+})
+
+// serverTiming renders the Server-Timing header. It is built when the
+// response headers go out, so the handler and total figures cover the work
+// done up to that point, which for these handlers is all of it.
+func (t *requestTiming) serverTiming() string {
+	handler := t.handler
+	if handler == 0 && !t.handlerStart.IsZero() {
+		handler = time.Since(t.handlerStart)
+	}
+	return fmt.Sprintf("throttle;dur=%s, ratelimit;dur=%s, handler;dur=%s, db;dur=%s, total;dur=%s",
+		durMs(t.throttle), durMs(t.rateLimit), durMs(handler), durMs(t.db), durMs(time.Since(t.start)))
+}
+
+func durMs(d time.Duration) string {
+	return strconv.FormatFloat(float64(d.Microseconds())/1000, 'f', 3, 64)
+}
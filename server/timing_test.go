@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+func TestServerTimingHeader(t *testing.T) {
+	withConfig(t, func(c *Config) { c.EnableServerTiming = true })
+	useLimiter(t, rate.NewLimiter(rate.Inf, 0))
+
+	w := httptest.NewRecorder()
+	routeMiddleware("/api/get", getHandler)(w, httptest.NewRequest(http.MethodGet, "/api/get", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d", w.Code)
+	}
+	got := w.Header().Get("Server-Timing")
+	for _, metric := range []string{"throttle;dur=", "ratelimit;dur=", "handler;dur=", "db;dur=", "total;dur="} {
+		if !strings.Contains(got, metric) {
+			t.Errorf("Server-Timing %q lacks %s", got, metric)
+		}
+	}
+}
+
+func TestServerTimingOffByDefault(t *testing.T) {
+	withConfig(t, nil)
+	useLimiter(t, rate.NewLimiter(rate.Inf, 0))
+
+	w := httptest.NewRecorder()
+	routeMiddleware("/api/get", getHandler)(w, httptest.NewRequest(http.MethodGet, "/api/get", nil))
+	if got := w.Header().Get("Server-Timing"); got != "" {
+		t.Fatalf("Server-Timing = %q without ENABLE_SERVER_TIMING", got)
+	}
+}
+
+// Routes that skip "slo", like the stream, keep their timing and duration.
+func TestTimingSurvivesSkippedSLO(t *testing.T) {
+	withConfig(t, func(c *Config) { c.EnableServerTiming = true })
+	useLimiter(t, rate.NewLimiter(rate.Inf, 0))
+
+	before, _ := histogramStats(requestDuration)
+	w := httptest.NewRecorder()
+	routeMiddleware("/api/get", getHandler, "slo")(w, httptest.NewRequest(http.MethodGet, "/api/get", nil))
+	if w.Header().Get("Server-Timing") == "" {
+		t.Fatal("no Server-Timing once slo is skipped")
+	}
+	if got, _ := histogramStats(requestDuration); got != before+1 {
+		t.Fatalf("http_request_duration_seconds count = %d, want %d", got, before+1)
+	}
+}
+
+func TestStreamSendsServerTiming(t *testing.T) {
+	withConfig(t, func(c *Config) { c.EnableServerTiming = true })
+	useTestDB(t)
+	srv := useRouter(t)
+
+	resp := openStream(t, srv)
+	if resp.Header.Get("Server-Timing") == "" {
+		t.Fatal("stream response lacks Server-Timing")
+	}
+}
+
+func TestSLOBreachCounted(t *testing.T) {
+	withConfig(t, func(c *Config) {
+		c.SLOThresholds = map[string]time.Duration{"/api/slow": 5 * time.Millisecond, "/api/fast": time.Second}
+	})
+	useKnownRoute(t, "/api/slow")
+	useKnownRoute(t, "/api/fast")
+	slow := handlerTimer(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+		w.WriteHeader(http.StatusOK)
+	})
+	before, fastBefore := vecValue(sloBreaches, "/api/slow"), vecValue(sloBreaches, "/api/fast")
+
+	timingMiddleware(sloMiddleware(slow))(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/slow", nil))
+	if got := vecValue(sloBreaches, "/api/slow"); got != before+1 {
+		t.Fatalf(`slo_breaches_total{path="/api/slow"} = %d, want %d`, got, before+1)
+	}
+
+	// The same handler under a looser SLO is not a breach
+	timingMiddleware(sloMiddleware(slow))(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/fast", nil))
+	if got := vecValue(sloBreaches, "/api/fast"); got != fastBefore {
+		t.Fatalf(`slo_breaches_total{path="/api/fast"} = %d, want %d`, got, fastBefore)
+	}
+}
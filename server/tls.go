@@ -0,0 +1,29 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// parseTLSVersion maps TLS_MIN_VERSION ("1.2", "1.3", ...) to its
+// crypto/tls constant.
+func parseTLSVersion(value string) (uint16, error) {
+	v, ok := tlsVersions[value]
+	if !ok {
+		return 0, fmt.Errorf("unknown TLS version %q (expected 1.0, 1.1, 1.2 or 1.3)", value)
+	}
+	return v, nil
+}
+
+// serverTLSConfig is used when TLS_CERT_FILE/TLS_KEY_FILE are set. Clients
+// offering only older protocol versions fail the handshake.
+func serverTLSConfig(minVersion uint16) *tls.Config {
+	return &tls.Config{MinVersion: minVersion}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseTLSVersion(t *testing.T) {
+	for value, want := range tlsVersions {
+		if got, err := parseTLSVersion(value); err != nil || got != want {
+			t.Errorf("parseTLSVersion(%q) = %v, %v", value, got, err)
+		}
+	}
+	for _, value := range []string{"", "1.4", "TLS1.2", "ssl3"} {
+		if _, err := parseTLSVersion(value); err == nil {
+			t.Errorf("parseTLSVersion(%q) accepted", value)
+		}
+	}
+}
+
+func TestTLSRefusesOlderClients(t *testing.T) {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(okHandler))
+	srv.TLS = serverTLSConfig(tls.VersionTLS13)
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+
+	get := func(maxVersion uint16) error {
+		transport := srv.Client().Transport.(*http.Transport).Clone()
+		transport.TLSClientConfig.MaxVersion = maxVersion
+		resp, err := (&http.Client{Transport: transport}).Get(srv.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+
+	if err := get(tls.VersionTLS12); err == nil {
+		t.Fatal("TLS 1.2 client was served with TLS_MIN_VERSION=1.3")
+	}
+	if err := get(tls.VersionTLS13); err != nil {
+		t.Fatalf("TLS 1.3 client refused: %v", err)
+	}
+}
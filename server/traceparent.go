@@ -0,0 +1,35 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// This server doesn't start traces of its own; when the caller (or a proxy
+// in front) is traced, the W3C traceparent header it propagates names the
+// trace a request belongs to, and that id becomes the metric exemplar.
+
+// traceIDFrom returns the trace id of a "00-<trace-id>-<parent-id>-<flags>"
+// traceparent header, or "" when it is absent or malformed.
+func traceIDFrom(r *http.Request) string {
+	parts := strings.Split(strings.TrimSpace(r.Header.Get("traceparent")), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return ""
+	}
+	traceID := parts[1]
+	if len(traceID) != 32 || !isLowerHex(traceID) || strings.Count(traceID, "0") == len(traceID) {
+		// An all-zero trace id is invalid per the spec
+		return ""
+	}
+	return traceID
+}
+
+func isLowerHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
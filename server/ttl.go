@@ -0,0 +1,65 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Message expiry: POST /api/db/messages?ttl_seconds=N sets expires_at, reads
+// skip expired rows right away and a background purge deletes them.
+
+// ttlFromRequest reads ?ttl_seconds=. ok is false when the value is present
+// but not a positive integer.
+func ttlFromRequest(r *http.Request) (time.Duration, bool) {
+	value := r.URL.Query().Get("ttl_seconds")
+	if value == "" {
+		return 0, true
+	}
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds <= 0 {
+		return 0, false
+	}
+	return time.Duration(seconds) * time.Second, true
+}
+
+// unexpired drops messages whose expires_at has passed, for lists that were
+// read (or cached) before they expired. The input is never modified.
+func unexpired(messages []Message, now time.Time) []Message {
+	for i, m := range messages {
+		if m.ExpiresAt != nil && !m.ExpiresAt.After(now) {
+			kept := append([]Message{}, messages[:i]...)
+			for _, m := range messages[i+1:] {
+				if m.ExpiresAt == nil || m.ExpiresAt.After(now) {
+					kept = append(kept, m)
+				}
+			}
+			return kept
+		}
+	}
+	return messages
+}
+
+func startExpiryPurge(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			purgeExpiredMessages()
+		}
+	}()
+}
+
+func purgeExpiredMessages() {
+	start := time.Now()
+	res, err := db.Exec("DELETE FROM messages WHERE expires_at <= " + dialect.now())
+	if err != nil {
+		log.Printf("[TTL] Purge of expired messages failed: %v", err)
+		return
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		messagesCache.invalidate()
+		log.Printf("[TTL] Purged %d expired messages in %v", n, time.Since(start))
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTTLFromRequest(t *testing.T) {
+	tests := []struct {
+		query  string
+		want   time.Duration
+		wantOK bool
+	}{
+		{"", 0, true},
+		{"ttl_seconds=90", 90 * time.Second, true},
+		{"ttl_seconds=0", 0, false},
+		{"ttl_seconds=-5", 0, false},
+		{"ttl_seconds=soon", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := ttlFromRequest(httptest.NewRequest(http.MethodPost, "/api/db/messages?"+tt.query, nil))
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("%q: got %v, %v; want %v, %v", tt.query, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestUnexpiredDropsPastMessages(t *testing.T) {
+	now := time.Now()
+	past, future := now.Add(-time.Minute), now.Add(time.Minute)
+	messages := []Message{{ID: 1}, {ID: 2, ExpiresAt: &past}, {ID: 3, ExpiresAt: &future}}
+
+	got := unexpired(messages, now)
+	if len(got) != 2 || got[0].ID != 1 || got[1].ID != 3 {
+		t.Fatalf("unexpired = %+v, want messages 1 and 3", got)
+	}
+	if len(messages) != 3 || messages[1].ID != 2 {
+		t.Fatal("input slice was modified")
+	}
+}
+
+func TestPostWithTTLAndPurge(t *testing.T) {
+	withConfig(t, nil)
+	useTestDB(t)
+
+	w := httptest.NewRecorder()
+	dbPostHandler(w, httptest.NewRequest(http.MethodPost, "/api/db/messages?ttl_seconds=3600", strings.NewReader(`{"content":"short-lived"}`)))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("status = %d, body %s", w.Code, w.Body.String())
+	}
+	data := decodeBody(t, w)["data"].(map[string]interface{})
+	if data["expires_at"] == nil {
+		t.Fatal("response lacks expires_at")
+	}
+	postBulk(dbBulkHandler, `[{"content":"forever"}]`)
+
+	// Not expired yet: the purge keeps it
+	purgeExpiredMessages()
+	if n := countMessages(t); n != 2 {
+		t.Fatalf("%d rows after purge, want 2", n)
+	}
+
+	if _, err := db.Exec("UPDATE messages SET expires_at = '2000-01-01 00:00:00' WHERE expires_at IS NOT NULL"); err != nil {
+		t.Fatal(err)
+	}
+	purgeExpiredMessages()
+	if n := countMessages(t); n != 1 {
+		t.Fatalf("%d rows after purging the expired one, want 1", n)
+	}
+}
+
+func TestPostRejectsInvalidTTL(t *testing.T) {
+	withConfig(t, nil)
+	useTestDB(t)
+
+	w := httptest.NewRecorder()
+	dbPostHandler(w, httptest.NewRequest(http.MethodPost, "/api/db/messages?ttl_seconds=0", strings.NewReader(`{"content":"x"}`)))
+	if w.Code != http.StatusUnprocessableEntity || !strings.Contains(w.Body.String(), "ttl_seconds") {
+		t.Fatalf("got %d %s, want 422 on ttl_seconds", w.Code, w.Body.String())
+	}
+}
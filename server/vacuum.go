@@ -0,0 +1,38 @@
+package main
+
+import (
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+var vacuumRunning atomic.Bool
+
+// startAutoVacuum runs VACUUM ANALYZE on the messages table every
+// MAINTENANCE_INTERVAL_SEC while AUTO_MAINTENANCE is enabled.
+func startAutoVacuum(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			runVacuum()
+		}
+	}()
+}
+
+// runVacuum skips the run if the previous one is still going, so a slow
+// VACUUM never piles up on itself.
+func runVacuum() {
+	if !vacuumRunning.CompareAndSwap(false, true) {
+		log.Printf("[MAINTENANCE] Previous VACUUM ANALYZE still running, skipping")
+		return
+	}
+	defer vacuumRunning.Store(false)
+
+	start := time.Now()
+	if _, err := db.Exec("VACUUM ANALYZE messages"); err != nil {
+		log.Printf("[MAINTENANCE] VACUUM ANALYZE failed after %v: %v", time.Since(start), err)
+		return
+	}
+	log.Printf("[MAINTENANCE] VACUUM ANALYZE messages completed in %v", time.Since(start))
+}
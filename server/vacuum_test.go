@@ -0,0 +1,45 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestRunVacuum(t *testing.T) {
+	fake := useFakeDB(t, nil)
+	runVacuum()
+	if n := fake.count("VACUUM ANALYZE messages"); n != 1 {
+		t.Fatalf("%d VACUUM statements, want 1", n)
+	}
+	if vacuumRunning.Load() {
+		t.Fatal("vacuumRunning still set after the run")
+	}
+}
+
+func TestRunVacuumSkipsWhileRunning(t *testing.T) {
+	fake := useFakeDB(t, nil)
+	logs := captureLog(t)
+	vacuumRunning.Store(true)
+	t.Cleanup(func() { vacuumRunning.Store(false) })
+
+	runVacuum()
+	if n := fake.count("VACUUM"); n != 0 {
+		t.Fatalf("%d VACUUM statements while one was running, want 0", n)
+	}
+	if !strings.Contains(logs.String(), "still running, skipping") {
+		t.Fatalf("skip not logged:\n%s", logs.String())
+	}
+	if !vacuumRunning.Load() {
+		t.Fatal("the skipped run cleared the running flag of the other one")
+	}
+}
+
+func TestRunVacuumReleasesGuardOnFailure(t *testing.T) {
+	fake := useFakeDB(t, errors.New("vacuum failed"))
+	runVacuum()
+	runVacuum()
+	if n := fake.count("VACUUM"); n != 2 {
+		t.Fatalf("%d VACUUM attempts, want 2: a failure must not leave the guard held", n)
+	}
+}
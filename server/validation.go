@@ -0,0 +1,232 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"regexp"
+	"regexp/syntax"
+	"strings"
+	"unicode/utf8"
+)
+
+// contentDenyPatterns is compiled once at startup from CONTENT_DENY_PATTERNS.
+var contentDenyPatterns []*regexp.Regexp
+
+// denyPatternOverlap is how many bytes of one streamed chunk are checked
+// again with the next, so a match split across the boundary is still
+// found: the longest possible match minus one. -1 means some pattern has
+// no upper bound (*, +, {n,}) and streamed uploads can't be checked.
+var denyPatternOverlap int
+
+func compileDenyPatterns(patterns []string) error {
+	compiled := make([]*regexp.Regexp, 0, len(patterns))
+	overlap := 0
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return fmt.Errorf("invalid CONTENT_DENY_PATTERNS entry %q: %w", p, err)
+		}
+		compiled = append(compiled, re)
+
+		parsed, _ := syntax.Parse(p, syntax.Perl)
+		n, bounded := maxMatchLen(parsed.Simplify())
+		switch {
+		case !bounded:
+			overlap = -1
+		case overlap >= 0 && n-1 > overlap:
+			overlap = n - 1
+		}
+	}
+	contentDenyPatterns = compiled
+	denyPatternOverlap = overlap
+	return nil
+}
+
+// maxMatchLen is the longest match of re in bytes, if it has a bound.
+func maxMatchLen(re *syntax.Regexp) (int, bool) {
+	switch re.Op {
+	case syntax.OpLiteral:
+		if re.Flags&syntax.FoldCase != 0 {
+			// A case variant can be longer than the rune as written (k, K)
+			return len(re.Rune) * utf8.UTFMax, true
+		}
+		return len(string(re.Rune)), true
+	case syntax.OpCharClass, syntax.OpAnyCharNotNL, syntax.OpAnyChar:
+		return utf8.UTFMax, true
+	case syntax.OpCapture, syntax.OpQuest:
+		return maxMatchLen(re.Sub[0])
+	case syntax.OpStar, syntax.OpPlus:
+		return 0, false
+	case syntax.OpRepeat:
+		n, ok := maxMatchLen(re.Sub[0])
+		if !ok || re.Max < 0 {
+			return 0, false
+		}
+		return n * re.Max, true
+	case syntax.OpConcat, syntax.OpAlternate:
+		total := 0
+		for _, sub := range re.Sub {
+			n, ok := maxMatchLen(sub)
+			if !ok {
+				return 0, false
+			}
+			if re.Op == syntax.OpConcat {
+				total += n
+			} else if n > total {
+				total = n
+			}
+		}
+		return total, true
+	}
+	// Empty matches and assertions (^, $, \b) consume nothing
+	return 0, true
+}
+
+func matchesDenyPattern(content string) bool {
+	for _, re := range contentDenyPatterns {
+		if re.MatchString(content) {
+			return true
+		}
+	}
+	return false
+}
+
+const defaultContentType = "text/plain"
+
+var (
+	scriptBlock = regexp.MustCompile(`(?is)<(script|style)\b[^>]*>.*?</(script|style)\s*>`)
+	htmlTag     = regexp.MustCompile(`(?s)<[^>]*>`)
+)
+
+// sanitizeContent applies SANITIZE_CONTENT before storage: "escape" keeps
+// the text but HTML-escapes it, "strip" drops tags (and script/style bodies
+// along with them) and escapes any "<" left over, such as an unterminated
+// "<script src=x". It runs before validation, so length limits apply to
+// what is actually stored.
+func sanitizeContent(content string) string {
+	switch config.SanitizeContent {
+	case "escape":
+		return html.EscapeString(content)
+	case "strip":
+		stripped := htmlTag.ReplaceAllString(scriptBlock.ReplaceAllString(content, ""), "")
+		return strings.ReplaceAll(stripped, "<", "&lt;")
+	}
+	return content
+}
+
+// sanitizeMessage applies SANITIZE_CONTENT to every free-text field.
+func sanitizeMessage(msg *Message) {
+	msg.Content = sanitizeContent(msg.Content)
+	msg.Title = sanitizeContent(msg.Title)
+	msg.Author = sanitizeContent(msg.Author)
+}
+
+// fieldError is a machine-readable validation failure for one field.
+type fieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// validateMessage collects every validation failure of an incoming
+// message instead of stopping at the first one.
+func validateMessage(msg Message) []fieldError {
+	var errs []fieldError
+
+	if msg.Content == "" {
+		errs = append(errs, fieldError{Field: "content", Message: "required"})
+	} else if config.MessageMaxLength > 0 && utf8.RuneCountInString(msg.Content) > config.MessageMaxLength {
+		errs = append(errs, fieldError{Field: "content", Message: "too long"})
+	} else if matchesDenyPattern(msg.Content) {
+		errs = append(errs, fieldError{Field: "content", Message: "matches a denied pattern"})
+	}
+
+	if !config.AllowedContentTypes[msg.ContentType] {
+		errs = append(errs, fieldError{Field: "content_type", Message: "unsupported"})
+	}
+
+	errs = append(errs, validateMessageMeta(msg.Title, msg.Author)...)
+
+	// Campos gerados pelo banco não podem ser enviados pelo cliente
+	if msg.ID != 0 {
+		errs = append(errs, fieldError{Field: "id", Message: "read-only"})
+	}
+	if !msg.CreatedAt.IsZero() {
+		errs = append(errs, fieldError{Field: "created_at", Message: "read-only"})
+	}
+	if msg.ExpiresAt != nil {
+		errs = append(errs, fieldError{Field: "expires_at", Message: "read-only, use ?ttl_seconds="})
+	}
+
+	return errs
+}
+
+// validateMessageMeta checks title and author against
+// REQUIRED_MESSAGE_FIELDS and MESSAGE_META_MAX_LENGTH.
+func validateMessageMeta(title, author string) []fieldError {
+	var errs []fieldError
+	for _, f := range []struct{ name, value string }{{"title", title}, {"author", author}} {
+		if strings.TrimSpace(f.value) == "" {
+			if config.RequiredMessageFields[f.name] {
+				errs = append(errs, fieldError{Field: f.name, Message: "required"})
+			}
+		} else if config.MessageMetaMaxLength > 0 && utf8.RuneCountInString(f.value) > config.MessageMetaMaxLength {
+			errs = append(errs, fieldError{Field: f.name, Message: "too long"})
+		}
+	}
+	return errs
+}
+
+// errInvalidUTF8 is returned by utf8Reader once its input stops being
+// valid UTF-8.
+var errInvalidUTF8 = errors.New("invalid UTF-8")
+
+// utf8Reader enforces ENFORCE_UTF8 on bodies that are decoded as a stream:
+// by the time a json.Decoder hands out strings, invalid bytes have already
+// become U+FFFD. A sequence split across reads is checked once it completes.
+type utf8Reader struct {
+	r       io.Reader
+	partial []byte
+}
+
+func (u *utf8Reader) Read(p []byte) (int, error) {
+	n, err := u.r.Read(p)
+	data := append(u.partial, p[:n]...)
+	cut := 0
+	if err == nil {
+		cut = incompleteRuneSuffix(data)
+	}
+	if !utf8.Valid(data[:len(data)-cut]) {
+		return 0, errInvalidUTF8
+	}
+	u.partial = append([]byte(nil), data[len(data)-cut:]...)
+	return n, err
+}
+
+// writeInvalidUTF8 answers 422 when err comes from utf8Reader.
+func writeInvalidUTF8(w http.ResponseWriter, r *http.Request, err error) bool {
+	if !errors.Is(err, errInvalidUTF8) {
+		return false
+	}
+	// The decoder reads ahead, so the bytes can't be pinned on one element
+	writeValidationErrors(w, r, []fieldError{{Field: "body", Message: "invalid UTF-8"}})
+	return true
+}
+
+func writeValidationErrors(w http.ResponseWriter, r *http.Request, errs []fieldError) {
+	writeJSON(w, r, http.StatusUnprocessableEntity, map[string]interface{}{
+		"errors": errs,
+	})
+}
+
+// summarizeFieldErrors renders errors as "field: message; ..." for places
+// that report a single string, like import failures.
+func summarizeFieldErrors(errs []fieldError) string {
+	parts := make([]string, len(errs))
+	for i, e := range errs {
+		parts[i] = e.Field + ": " + e.Message
+	}
+	return strings.Join(parts, "; ")
+}
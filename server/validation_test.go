@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestSanitizeContent(t *testing.T) {
+	tests := []struct {
+		mode, in, want string
+	}{
+		{"", "<b>hi</b>", "<b>hi</b>"},
+		{"escape", `<b>"hi"</b>`, "&lt;b&gt;&#34;hi&#34;&lt;/b&gt;"},
+		{"strip", "<b>hi</b> there", "hi there"},
+		{"strip", "a<script>alert(1)</script>b", "ab"},
+		{"strip", "a<STYLE type=x>p{}</style >b", "ab"},
+		{"strip", "x <script src=evil", "x &lt;script src=evil"},
+		{"strip", "1 < 2", "1 &lt; 2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode+"/"+tt.in, func(t *testing.T) {
+			withConfig(t, func(c *Config) { c.SanitizeContent = tt.mode })
+			if got := sanitizeContent(tt.in); got != tt.want {
+				t.Fatalf("sanitizeContent(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDenyPatternOverlap(t *testing.T) {
+	tests := []struct {
+		patterns []string
+		want     int
+	}{
+		{nil, 0},
+		{[]string{"forbidden"}, 8},
+		{[]string{"ab", "abcdef"}, 5},
+		{[]string{"colou?r"}, 5},
+		{[]string{"x{2,4}"}, 3},
+		{[]string{"[0-9]{3}"}, 3*4 - 1},
+		{[]string{"(?i)k"}, 3},
+		{[]string{"bad.*word"}, -1},
+		{[]string{"short", "x+"}, -1},
+		{[]string{"x{3,}"}, -1},
+	}
+	for _, tt := range tests {
+		if err := compileDenyPatterns(tt.patterns); err != nil {
+			t.Fatal(err)
+		}
+		if denyPatternOverlap != tt.want {
+			t.Errorf("overlap for %q = %d, want %d", tt.patterns, denyPatternOverlap, tt.want)
+		}
+	}
+	compileDenyPatterns(nil)
+}
+
+func TestCompileDenyPatternsRejectsInvalid(t *testing.T) {
+	if err := compileDenyPatterns([]string{"("}); err == nil {
+		t.Fatal("invalid pattern compiled")
+	}
+	compileDenyPatterns(nil)
+}
+
+func TestMatchesDenyPattern(t *testing.T) {
+	useDenyPatterns(t, "(?i)drop\\s+table", "forbidden")
+	for content, want := range map[string]bool{
+		"please DROP  TABLE users": true,
+		"a forbidden word":         true,
+		"nothing to see":           false,
+	} {
+		if got := matchesDenyPattern(content); got != want {
+			t.Errorf("matchesDenyPattern(%q) = %v, want %v", content, got, want)
+		}
+	}
+}
+
+func TestEnforceUTF8(t *testing.T) {
+	valid := `{"content":"olá"}`
+	invalid := "{\"content\":\"bad \xff byte\"}"
+	tests := []struct {
+		name    string
+		enforce bool
+		body    string
+		want    int
+	}{
+		{"valid", true, valid, http.StatusCreated},
+		{"invalid", true, invalid, http.StatusUnprocessableEntity},
+		{"disabled", false, invalid, http.StatusCreated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withConfig(t, func(c *Config) { c.EnforceUTF8 = tt.enforce })
+			useTestDB(t)
+
+			if w := postMessage("/api/db/messages", tt.body); w.Code != tt.want {
+				t.Fatalf("POST: status = %d, want %d (%s)", w.Code, tt.want, w.Body.String())
+			}
+			w := postBulk(dbBulkHandler, "["+tt.body+","+valid+"]")
+			if w.Code != tt.want {
+				t.Fatalf("bulk: status = %d, want %d (%s)", w.Code, tt.want, w.Body.String())
+			}
+			if tt.want == http.StatusUnprocessableEntity {
+				if n := countMessages(t); n != 0 {
+					t.Fatalf("%d rows stored from rejected bodies", n)
+				}
+				return
+			}
+			if !tt.enforce && !strings.Contains(messageContent(t, 1), "�") {
+				t.Fatalf("stored %q, want the invalid byte replaced by U+FFFD", messageContent(t, 1))
+			}
+		})
+	}
+}
+
+func TestUTF8ReaderAcrossReads(t *testing.T) {
+	for input, wantErr := range map[string]bool{
+		"olá, ação €":   false,
+		"ol\xc3":        true, // truncated at EOF
+		"ab\xe2\x82xyz": true,
+	} {
+		_, err := io.ReadAll(&utf8Reader{r: iotest.OneByteReader(strings.NewReader(input))})
+		if (err != nil) != wantErr {
+			t.Errorf("%q: err = %v, want error %v", input, err, wantErr)
+		}
+	}
+}
+
+func TestStreamRejectsInvalidUTF8Meta(t *testing.T) {
+	withConfig(t, streamConfig(nil))
+	useTestDB(t)
+
+	w := streamUpload(t, "/api/db/messages?title=%FF", "content")
+	if w.Code != http.StatusUnprocessableEntity || !strings.Contains(w.Body.String(), `"field":"title"`) {
+		t.Fatalf("got %d %s, want 422 on title", w.Code, w.Body.String())
+	}
+}
+
+func TestValidationReportsEveryInvalidField(t *testing.T) {
+	withConfig(t, func(c *Config) { c.RequiredMessageFields = map[string]bool{"title": true} })
+	useTestDB(t)
+
+	w := postMessage("/api/db/messages", `{"content":"","content_type":"application/x-bogus","id":7,"created_at":"2024-01-01T00:00:00Z"}`)
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("status = %d, want 422 (%s)", w.Code, w.Body.String())
+	}
+	var body struct {
+		Errors []fieldError `json:"errors"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]string, len(body.Errors))
+	for _, e := range body.Errors {
+		got[e.Field] = e.Message
+	}
+	want := map[string]string{
+		"content":      "required",
+		"content_type": "unsupported",
+		"title":        "required",
+		"id":           "read-only",
+		"created_at":   "read-only",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("errors = %+v, want one per field in %v", body.Errors, want)
+	}
+	for field, msg := range want {
+		if got[field] != msg {
+			t.Errorf("%s: %q, want %q", field, got[field], msg)
+		}
+	}
+	if n := countMessages(t); n != 0 {
+		t.Fatalf("%d messages stored despite the errors", n)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Outbound webhook: each created message is POSTed as JSON to WEBHOOK_URL
+// by a background worker, with retries and a per-attempt timeout. Like the
+// shadow writes, the queue is bounded and drops events when full so a slow
+// receiver can never hold back the API.
+
+const webhookQueueSize = 1000
+
+var (
+	webhookQueue  chan Message
+	webhookClient *http.Client
+
+	webhookDeliveries = newCounter("webhook_deliveries_total", "Webhook calls acknowledged with a 2xx response.")
+	webhookFailures   = newCounter("webhook_failures_total", "Webhook events dropped after all retries or because the queue was full.")
+)
+
+func startWebhook() {
+	webhookClient = &http.Client{Timeout: time.Duration(config.WebhookTimeoutMs) * time.Millisecond}
+	webhookQueue = make(chan Message, webhookQueueSize)
+	go webhookWorker()
+}
+
+// fireWebhook enqueues msg for delivery without blocking.
+func fireWebhook(msg Message) {
+	if webhookQueue == nil {
+		return
+	}
+	select {
+	case webhookQueue <- msg:
+	default:
+		webhookFailures.inc()
+		log.Printf("[WEBHOOK] Queue full, dropping event for message %d", msg.ID)
+	}
+}
+
+func webhookWorker() {
+	for msg := range webhookQueue {
+		if err := deliverWebhook(msg); err != nil {
+			webhookFailures.inc()
+			log.Printf("[WEBHOOK] Giving up on message %d: %v", msg.ID, err)
+			continue
+		}
+		webhookDeliveries.inc()
+	}
+}
+
+// deliverWebhook retries network errors, 429 and 5xx with exponential
+// backoff, honoring Retry-After when the receiver sends one.
+func deliverWebhook(msg Message) error {
+	payload, err := marshalPayload(map[string]interface{}{
+		"event": "message.created",
+		"data":  msg,
+	})
+	if err != nil {
+		return err
+	}
+
+	backoff := 500 * time.Millisecond
+	for attempt := 0; ; attempt++ {
+		wait := backoff
+		retryable, err := postWebhook(payload, &wait)
+		if err == nil {
+			return nil
+		}
+		if !retryable || attempt >= config.WebhookRetries {
+			return err
+		}
+		log.Printf("[WEBHOOK] Attempt %d for message %d failed, retrying in %v: %v", attempt+1, msg.ID, wait, err)
+		clock.Sleep(context.Background(), wait)
+		if backoff < 30*time.Second {
+			backoff *= 2
+		}
+	}
+}
+
+// postWebhook sends one attempt and reports whether a failure is worth
+// retrying. A retryable response may adjust *wait through Retry-After.
+func postWebhook(payload []byte, wait *time.Duration) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, config.WebhookURL, bytes.NewReader(payload))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return true, nil
+	}
+	err = fmt.Errorf("receiver answered %d", resp.StatusCode)
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode < 500 {
+		// Other 4xx won't change on retry
+		return false, err
+	}
+	if d, ok := ParseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); ok && d < time.Minute {
+		*wait = d
+	}
+	return true, err
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// useWebhookReceiver points WEBHOOK_URL at a test server running handler.
+// Call it after withConfig.
+func useWebhookReceiver(t *testing.T, handler http.HandlerFunc) {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	config.WebhookURL = srv.URL
+	prev := webhookClient
+	webhookClient = srv.Client()
+	t.Cleanup(func() { webhookClient = prev })
+}
+
+func TestWebhookPayloadFollowsJSONCase(t *testing.T) {
+	withConfig(t, func(c *Config) { c.JSONCase = "camel" })
+	var got string
+	useWebhookReceiver(t, func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		got = string(b)
+	})
+
+	if err := deliverWebhook(Message{ID: 1, Content: "x", ContentType: "text/plain"}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(got, `"contentType":"text/plain"`) || !strings.Contains(got, `"event":"message.created"`) {
+		t.Fatalf("payload = %s, want camelCase keys", got)
+	}
+}
+
+// countingReceiver answers each call with the next status of statuses,
+// repeating the last one, and counts the calls.
+func countingReceiver(calls *int32, statuses ...int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		n := int(atomic.AddInt32(calls, 1))
+		if n > len(statuses) {
+			n = len(statuses)
+		}
+		w.WriteHeader(statuses[n-1])
+	}
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	withConfig(t, func(c *Config) { c.WebhookRetries = 3 })
+	var calls int32
+	var got map[string]interface{}
+	useWebhookReceiver(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("%s with Content-Type %q", r.Method, r.Header.Get("Content-Type"))
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+	})
+
+	if err := deliverWebhook(Message{ID: 5, Content: "hello"}); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 1 {
+		t.Fatalf("%d calls, want 1", calls)
+	}
+	data, _ := got["data"].(map[string]interface{})
+	if got["event"] != "message.created" || data["id"] != float64(5) || data["content"] != "hello" {
+		t.Fatalf("payload = %v", got)
+	}
+}
+
+func TestWebhookRetriesTransientFailures(t *testing.T) {
+	for _, status := range []int{http.StatusInternalServerError, http.StatusServiceUnavailable, http.StatusTooManyRequests} {
+		withConfig(t, func(c *Config) { c.WebhookRetries = 3 })
+		clk := useMockClock(t)
+		var calls int32
+		useWebhookReceiver(t, countingReceiver(&calls, status, status, http.StatusOK))
+
+		if err := deliverWebhook(Message{ID: 1}); err != nil {
+			t.Fatalf("%d then 200: %v", status, err)
+		}
+		if calls != 3 {
+			t.Fatalf("%d: %d calls, want 3", status, calls)
+		}
+		// Exponential backoff between the attempts
+		if got := clk.Slept(); got != 1500*time.Millisecond {
+			t.Fatalf("%d: backed off %v, want 500ms + 1s", status, got)
+		}
+	}
+}
+
+func TestWebhookHonoursRetryAfter(t *testing.T) {
+	withConfig(t, func(c *Config) { c.WebhookRetries = 1 })
+	clk := useMockClock(t)
+	var calls int32
+	useWebhookReceiver(t, func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.Header().Set("Retry-After", "7")
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	})
+
+	if err := deliverWebhook(Message{ID: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if got := clk.Slept(); got != 7*time.Second {
+		t.Fatalf("waited %v, want the 7s from Retry-After", got)
+	}
+}
+
+func TestWebhookGivesUp(t *testing.T) {
+	tests := []struct {
+		status    int
+		wantCalls int32
+	}{
+		{http.StatusBadRequest, 1}, // 4xx is final
+		{http.StatusNotFound, 1},
+		{http.StatusBadGateway, 3}, // 1 + WEBHOOK_RETRIES
+	}
+	for _, tt := range tests {
+		withConfig(t, func(c *Config) { c.WebhookRetries = 2 })
+		useMockClock(t)
+		var calls int32
+		useWebhookReceiver(t, countingReceiver(&calls, tt.status))
+
+		if err := deliverWebhook(Message{ID: 1}); err == nil {
+			t.Fatalf("%d: delivery reported success", tt.status)
+		}
+		if calls != tt.wantCalls {
+			t.Fatalf("%d: %d calls, want %d", tt.status, calls, tt.wantCalls)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+)
+
+// Bounded write queue in front of dbPostHandler: bursts wait in the channel
+// and a fixed pool of workers drains it, capping concurrent INSERTs.
+
+var errWriteQueueFull = errors.New("write queue full")
+
+type writeResult struct {
+	id        int
+	createdAt time.Time
+	err       error
+}
+
+type writeJob struct {
+	ctx  context.Context // request values (query tags) and the total deadline
+	msg  Message
+	done chan writeResult
+}
+
+var writeQueue chan writeJob
+
+var (
+	writeQueueRejections = newCounter("db_write_queue_rejections_total", "Writes rejected because the DB write queue was full.")
+
+	_ = newGaugeFunc("db_write_queue_depth", "Writes waiting in the DB write queue.", func() float64 {
+		return float64(len(writeQueue))
+	})
+)
+
+func startWriteQueue(depth, workers int) {
+	if workers <= 0 {
+		workers = 1
+	}
+	writeQueue = make(chan writeJob, depth)
+	for i := 0; i < workers; i++ {
+		go writeWorker()
+	}
+}
+
+func writeWorker() {
+	for job := range writeQueue {
+		id, createdAt, err := insertMessage(job.ctx, job.msg)
+		writeQueueThroughput.done()
+		job.done <- writeResult{id: id, createdAt: createdAt, err: err}
+	}
+}
+
+// queueInsert stores msg through the write queue when it is enabled, or
+// inline otherwise. It returns errWriteQueueFull without blocking when the
+// queue has no room.
+func queueInsert(ctx context.Context, msg Message) (int, time.Time, error) {
+	if writeQueue == nil {
+		return insertMessage(ctx, msg)
+	}
+
+	// Buffered so a worker never blocks on a caller that stopped waiting
+	job := writeJob{ctx: ctx, msg: msg, done: make(chan writeResult, 1)}
+	select {
+	case writeQueue <- job:
+	default:
+		writeQueueRejections.inc()
+		log.Printf("[QUEUE] Write queue full (%d), rejecting write", cap(writeQueue))
+		return 0, time.Time{}, errWriteQueueFull
+	}
+
+	// A job still queued when the total deadline passes fails in the worker
+	// on its expired context
+	res := <-job.done
+	return res.id, res.createdAt, res.err
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// useWriteQueue starts a write queue for the test and stops its workers
+// after.
+func useWriteQueue(t *testing.T, depth, workers int) {
+	t.Helper()
+	startWriteQueue(depth, workers)
+	q := writeQueue
+	t.Cleanup(func() {
+		writeQueue = nil
+		close(q)
+	})
+}
+
+func TestWriteQueueStoresEveryWrite(t *testing.T) {
+	withConfig(t, nil)
+	useTestDB(t)
+	useWriteQueue(t, 16, 2)
+
+	const writes = 10
+	var wg sync.WaitGroup
+	codes := make([]int, writes)
+	for i := 0; i < writes; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			codes[i] = postMessage("/api/db/messages", `{"content":"queued `+strconv.Itoa(i)+`"}`).Code
+		}(i)
+	}
+	wg.Wait()
+
+	for i, code := range codes {
+		if code != http.StatusCreated {
+			t.Fatalf("write %d = %d, want 201", i, code)
+		}
+	}
+	if n := countMessages(t); n != writes {
+		t.Fatalf("%d rows stored through the queue, want %d", n, writes)
+	}
+}
+
+func TestWriteQueueFullAnswers503(t *testing.T) {
+	withConfig(t, nil)
+	useTestDB(t)
+	// No workers: the one slot stays taken
+	writeQueue = make(chan writeJob, 1)
+	t.Cleanup(func() { writeQueue = nil })
+	writeQueue <- writeJob{}
+
+	rejected := atomic.LoadInt64(&writeQueueRejections.value)
+	w := postMessage("/api/db/messages", `{"content":"no room"}`)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want 503 (%s)", w.Code, w.Body.String())
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Fatal("503 without Retry-After")
+	}
+	if n := atomic.LoadInt64(&writeQueueRejections.value) - rejected; n != 1 {
+		t.Fatalf("db_write_queue_rejections_total grew by %d, want 1", n)
+	}
+	if n := countMessages(t); n != 0 {
+		t.Fatalf("%d rows stored by a rejected write", n)
+	}
+}